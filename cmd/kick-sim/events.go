@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/scorfly/gokick"
+
+	"github.com/arnokay/arnobot-kick/internal/data"
+)
+
+// allEventTypes are the events WebhookService subscribes to
+var allEventTypes = []string{
+	gokick.SubscriptionNameChatMessage.String(),
+	gokick.SubscriptionNameChannelFollow.String(),
+	gokick.SubscriptionNameChannelSubscriptionRenewal.String(),
+	gokick.SubscriptionNameChannelSubscriptionGifts.String(),
+	gokick.SubscriptionNameChannelSubscriptionCreated.String(),
+	gokick.SubscriptionNameLivestreamStatusUpdated.String(),
+	gokick.SubscriptionNameLivestreamMetadataUpdated.String(),
+	gokick.SubscriptionNameModerationBanned.String(),
+}
+
+func buildEvent(eventType string, opts options) ([]byte, error) {
+	now := time.Now().UTC()
+	broadcaster := userEvent(opts.Broadcaster, []string{"broadcaster"})
+	sender := userEvent(opts.Sender, splitList(opts.Badges))
+
+	var event any
+
+	switch eventType {
+	case gokick.SubscriptionNameChatMessage.String():
+		chatEvent := data.ChatMessageEvent{
+			ChatMessageEvent: gokick.ChatMessageEvent{
+				MessageID:   fmt.Sprintf("sim-%d", now.UnixNano()),
+				Broadcaster: broadcaster,
+				Sender:      sender,
+				Content:     opts.Content,
+				Emotes:      []gokick.ChatMessageEmotesEvent{},
+			},
+		}
+		// replied message is sent by the broadcaster
+		if opts.ReplyTo != "" {
+			chatEvent.RepliesTo = &data.ChatMessageReplyEvent{
+				MessageID: opts.ReplyTo,
+				Content:   opts.ReplyContent,
+				Sender:    broadcaster,
+			}
+		}
+		event = chatEvent
+	case gokick.SubscriptionNameChannelFollow.String():
+		event = gokick.ChannelFollowEvent{
+			Broadcaster: broadcaster,
+			Follower:    sender,
+		}
+	case gokick.SubscriptionNameChannelSubscriptionRenewal.String():
+		event = gokick.ChannelSubscriptionRenewalEvent{
+			Broadcaster: broadcaster,
+			Subscriber:  sender,
+			Duration:    opts.Duration,
+			CreatedAt:   now.Format(time.RFC3339),
+			ExpiresAt:   now.AddDate(0, 1, 0).Format(time.RFC3339),
+		}
+	case gokick.SubscriptionNameChannelSubscriptionCreated.String():
+		event = gokick.ChannelSubscriptionCreatedEvent{
+			Broadcaster: broadcaster,
+			Subscriber:  sender,
+			Duration:    opts.Duration,
+			CreatedAt:   now.Format(time.RFC3339),
+			ExpiresAt:   now.AddDate(0, 1, 0).Format(time.RFC3339),
+		}
+	case gokick.SubscriptionNameChannelSubscriptionGifts.String():
+		var giftees []gokick.UserEvent
+		for i, name := range splitList(opts.Giftees) {
+			giftees = append(giftees, userEvent(user{ID: opts.Sender.ID + 1000 + i, Username: name}, nil))
+		}
+		event = gokick.ChannelSubscriptionGiftsEvent{
+			Broadcaster: broadcaster,
+			Gifter:      sender,
+			Giftees:     giftees,
+			CreatedAt:   now.Format(time.RFC3339),
+			ExpiresAt:   now.AddDate(0, 1, 0).Format(time.RFC3339),
+		}
+	case gokick.SubscriptionNameLivestreamStatusUpdated.String():
+		livestream := gokick.LivestreamStatusUpdatedEvent{
+			Broadcaster: broadcaster,
+			IsLive:      opts.IsLive,
+			Title:       opts.Title,
+			StartedAt:   now.Format(time.RFC3339),
+		}
+		if !opts.IsLive {
+			livestream.StartedAt = now.Add(-time.Hour).Format(time.RFC3339)
+			livestream.EndedAt = now.Format(time.RFC3339)
+		}
+		event = livestream
+	case gokick.SubscriptionNameLivestreamMetadataUpdated.String():
+		var metadata gokick.LivestreamMetadataUpdatedEvent
+		metadata.Broadcaster = broadcaster
+		metadata.Metadata.Title = opts.Title
+		metadata.Metadata.Language = "en"
+		metadata.Metadata.Category.ID = strings.ToLower(strings.ReplaceAll(opts.Category, " ", "-"))
+		metadata.Metadata.Category.Name = opts.Category
+		event = metadata
+	case gokick.SubscriptionNameModerationBanned.String():
+		var ban data.ModerationBannedEvent
+		ban.Broadcaster = broadcaster
+		ban.Moderator = userEvent(opts.Broadcaster, []string{"broadcaster"})
+		ban.BannedUser = sender
+		ban.Metadata.Reason = opts.Content
+		ban.Metadata.CreatedAt = now.Format(time.RFC3339)
+		if opts.Expires > 0 {
+			expiresAt := now.Add(opts.Expires).Format(time.RFC3339)
+			ban.Metadata.ExpiresAt = &expiresAt
+		}
+		event = ban
+	default:
+		return nil, fmt.Errorf("unknown event type: %s", eventType)
+	}
+
+	return json.Marshal(event)
+}
+
+// readCaptured reads captured webhook, file is either
+// {"eventType": "...", "body": {...}} or just the body of eventType event
+func readCaptured(file string, eventType string) (string, []byte, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot read %s: %w", file, err)
+	}
+
+	var captured struct {
+		EventType string          `json:"eventType"`
+		Body      json.RawMessage `json:"body"`
+	}
+	err = json.Unmarshal(raw, &captured)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot decode %s: %w", file, err)
+	}
+
+	if captured.EventType != "" && len(captured.Body) != 0 {
+		return captured.EventType, captured.Body, nil
+	}
+
+	if eventType == "" || eventType == "all" {
+		return "", nil, fmt.Errorf("%s has no eventType, set it with -event", file)
+	}
+
+	return eventType, raw, nil
+}
+
+func userEvent(u user, badges []string) gokick.UserEvent {
+	event := gokick.UserEvent{
+		UserID:      u.ID,
+		Username:    u.Username,
+		ChannelSlug: strings.ToLower(u.Username),
+		Identity: gokick.IdentityEvent{
+			UsernameColor: "#53FC18",
+			Badges:        []gokick.Badge{},
+		},
+	}
+	for _, badge := range badges {
+		event.Identity.Badges = append(event.Identity.Badges, gokick.Badge{
+			Text: badge,
+			Type: badge,
+		})
+	}
+
+	return event
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
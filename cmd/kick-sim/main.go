@@ -0,0 +1,160 @@
+// kick-sim sends signed kick webhook events to the callback endpoint.
+//
+// Generate key pair and start the service with the public one:
+//
+//	go run ./cmd/kick-sim -gen-key sim
+//	go run ./cmd -wh-public-key-file sim.pub.pem
+//
+// Send events:
+//
+//	go run ./cmd/kick-sim -key sim.pem -event chat.message.sent -content "!ping"
+//	go run ./cmd/kick-sim -key sim.pem -content "yes" -reply-to <message id>
+//	go run ./cmd/kick-sim -key sim.pem -event all
+//	go run ./cmd/kick-sim -key sim.pem -replay captured/*.json
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type options struct {
+	URL         string
+	KeyFile     string
+	Event       string
+	Replay      bool
+	GenKey      string
+	Broadcaster user
+	Sender      user
+	Badges      string
+	Content     string
+	// ReplyTo is id of message the chat message replies to
+	ReplyTo      string
+	ReplyContent string
+	Title        string
+	Category     string
+	Duration     int
+	Giftees      string
+	IsLive       bool
+	Expires      time.Duration
+}
+
+type user struct {
+	ID       int
+	Username string
+}
+
+func main() {
+	var opts options
+
+	flag.StringVar(&opts.URL, "url", "http://localhost:3000/v1/callback", "callback url")
+	flag.StringVar(&opts.KeyFile, "key", "", "PEM private key to sign events with")
+	flag.StringVar(&opts.Event, "event", "chat.message.sent", "event type to send or \"all\"")
+	flag.BoolVar(&opts.Replay, "replay", false, "replay captured JSON files given as arguments")
+	flag.StringVar(&opts.GenKey, "gen-key", "", "generate key pair <name>.pem and <name>.pub.pem and exit")
+	flag.IntVar(&opts.Broadcaster.ID, "broadcaster-id", 1, "broadcaster user id")
+	flag.StringVar(&opts.Broadcaster.Username, "broadcaster", "broadcaster", "broadcaster username")
+	flag.IntVar(&opts.Sender.ID, "sender-id", 2, "sender (chatter, follower, subscriber, gifter, banned user) user id")
+	flag.StringVar(&opts.Sender.Username, "sender", "chatter", "sender username")
+	flag.StringVar(&opts.Badges, "badges", "", "comma separated sender badges, e.g. moderator,subscriber")
+	flag.StringVar(&opts.Content, "content", "hello from kick-sim", "chat message content or ban reason")
+	flag.StringVar(&opts.ReplyTo, "reply-to", "", "id of message the chat message replies to, empty sends no reply")
+	flag.StringVar(&opts.ReplyContent, "reply-content", "replied message", "content of message the chat message replies to")
+	flag.StringVar(&opts.Title, "title", "kick-sim stream", "stream title")
+	flag.StringVar(&opts.Category, "category", "Just Chatting", "stream category")
+	flag.IntVar(&opts.Duration, "duration", 1, "subscription duration in months")
+	flag.StringVar(&opts.Giftees, "giftees", "giftee1,giftee2", "comma separated gifted subscription recipients")
+	flag.BoolVar(&opts.IsLive, "live", true, "livestream status, false sends offline event")
+	flag.DurationVar(&opts.Expires, "expires", 0, "ban expiry, 0 sends permanent ban")
+	flag.Parse()
+
+	if opts.GenKey != "" {
+		err := generateKeyPair(opts.GenKey)
+		exitOnErr(err)
+		fmt.Printf("generated %s.pem and %s.pub.pem\n", opts.GenKey, opts.GenKey)
+		return
+	}
+
+	if opts.KeyFile == "" {
+		exitOnErr(fmt.Errorf("-key is required"))
+	}
+	signer, err := newSigner(opts.KeyFile)
+	exitOnErr(err)
+
+	if opts.Replay {
+		for _, pattern := range flag.Args() {
+			files, err := filepath.Glob(pattern)
+			exitOnErr(err)
+			for _, file := range files {
+				eventType, body, err := readCaptured(file, opts.Event)
+				exitOnErr(err)
+				exitOnErr(send(opts.URL, signer, eventType, body))
+			}
+		}
+		return
+	}
+
+	eventTypes := []string{opts.Event}
+	if opts.Event == "all" {
+		eventTypes = allEventTypes
+	}
+
+	for _, eventType := range eventTypes {
+		body, err := buildEvent(eventType, opts)
+		exitOnErr(err)
+		exitOnErr(send(opts.URL, signer, eventType, body))
+	}
+}
+
+func send(url string, signer *signer, eventType string, body []byte) error {
+	messageID := uuid.NewString()
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+
+	signature, err := signer.Sign(messageID, timestamp, body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Kick-Event-Type", eventType)
+	req.Header.Set("Kick-Event-Version", "1")
+	req.Header.Set("Kick-Event-Message-Id", messageID)
+	req.Header.Set("Kick-Event-Message-Timestamp", timestamp)
+	req.Header.Set("Kick-Event-Signature", signature)
+	req.Header.Set("Kick-Event-Subscription-Id", "kick-sim")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot send %s: %w", eventType, err)
+	}
+	defer res.Body.Close()
+
+	resBody, _ := io.ReadAll(res.Body)
+	fmt.Printf("%s %s -> %d %s\n", eventType, messageID, res.StatusCode, strings.TrimSpace(string(resBody)))
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("%s was rejected with status %d", eventType, res.StatusCode)
+	}
+
+	return nil
+}
+
+func exitOnErr(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "kick-sim:", err)
+		os.Exit(1)
+	}
+}
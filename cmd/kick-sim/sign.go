@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+type signer struct {
+	privateKey *rsa.PrivateKey
+}
+
+func newSigner(keyFile string) (*signer, error) {
+	raw, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read private key: %w", err)
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+
+	var privateKey *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, parseErr := x509.ParsePKCS8PrivateKey(block.Bytes)
+		err = parseErr
+		if err == nil {
+			var ok bool
+			privateKey, ok = parsed.(*rsa.PrivateKey)
+			if !ok {
+				err = errors.New("private key is not RSA key")
+			}
+		}
+	default:
+		err = fmt.Errorf("unsupported private key type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse private key: %w", err)
+	}
+
+	return &signer{privateKey: privateKey}, nil
+}
+
+// Sign signs "<message id>.<timestamp>.<body>" the same way kick does
+func (s *signer) Sign(messageID, timestamp string, body []byte) (string, error) {
+	payload := bytes.Join([][]byte{[]byte(messageID), []byte(timestamp), body}, []byte("."))
+	hashed := sha256.Sum256(payload)
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", fmt.Errorf("cannot sign payload: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+func generateKeyPair(name string) error {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(name+".pem", pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	}), 0o600)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(name+".pub.pem", pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: publicKey,
+	}), 0o644)
+}
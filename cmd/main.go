@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"net/http"
 	"os"
 	"time"
 
@@ -9,7 +10,6 @@ import (
 	sharedMiddleware "github.com/arnokay/arnobot-shared/middlewares"
 	"github.com/arnokay/arnobot-shared/pkg/assert"
 	sharedService "github.com/arnokay/arnobot-shared/service"
-	"github.com/arnokay/arnobot-shared/storage"
 	"github.com/charmbracelet/log"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/labstack/echo/v4"
@@ -19,8 +19,10 @@ import (
 	apiController "github.com/arnokay/arnobot-kick/internal/api/controller"
 	apiMiddleware "github.com/arnokay/arnobot-kick/internal/api/middleware"
 	"github.com/arnokay/arnobot-kick/internal/config"
+	"github.com/arnokay/arnobot-kick/internal/db"
 	mbController "github.com/arnokay/arnobot-kick/internal/mb/controller"
 	"github.com/arnokay/arnobot-kick/internal/service"
+	"github.com/arnokay/arnobot-kick/internal/storage"
 )
 
 const AppName = "kick"
@@ -30,6 +32,7 @@ type application struct {
 
 	msgBroker *nats.Conn
 	api       *echo.Echo
+	metrics   *http.Server
 	db        *pgxpool.Pool
 	storage   storage.Storager
 	cache     jetstream.KeyValue
@@ -40,6 +43,8 @@ type application struct {
 	mbControllers *mbController.Controllers
 
 	services *service.Services
+
+	cancelWorkers context.CancelFunc
 }
 
 func main() {
@@ -63,35 +68,100 @@ func main() {
 	app.storage = storage.NewStorage(app.db)
 
 	// load message broker
-	mbConn, _, kv := openMB(ctx)
+	mbConn, js, kv := openMB(ctx)
 	app.msgBroker = mbConn
 	app.cache = kv
+	chatCache := openChatCache(ctx, js)
 
 	// load services
 	services := &service.Services{}
 	services.TransactionService = sharedService.NewPgxTransactionService(app.db)
-	services.AuthModule = sharedService.NewAuthModule(app.msgBroker)
-	services.PlatformModule = sharedService.NewPlatformModuleOut(app.msgBroker)
+	services.AuthModule = service.NewAuthModule(app.msgBroker)
+	services.PlatformModule = service.NewPlatformModuleOut(app.msgBroker)
 	services.KickManager = service.NewKickManager(
 		app.cache,
-		services.AuthModule,
+		services.AuthModule.AuthModule,
 		config.Config.Kick.ClientID,
 		config.Config.Kick.ClientSecret,
+		service.KickManagerOptions{
+			APIBaseURL:        config.Config.Kick.APIURL,
+			AuthBaseURL:       config.Config.Kick.AuthURL,
+			MaxClients:        config.Config.Kick.MaxClients,
+			ClientIdleTimeout: config.Config.Kick.ClientIdleTimeout,
+		},
+	)
+	botOverrides := make(map[string]service.ChatLimit)
+	for botID, limit := range config.Config.Chat.BotLimits {
+		botOverrides[botID] = service.ChatLimit{Rate: limit.Rate, Burst: limit.Burst}
+	}
+	services.ChatLimiterService = service.NewChatLimiterService(service.ChatLimiterOptions{
+		Bot: service.ChatLimit{
+			Rate:  config.Config.Chat.BotLimit.Rate,
+			Burst: config.Config.Chat.BotLimit.Burst,
+		},
+		Channel: service.ChatLimit{
+			Rate:  config.Config.Chat.ChannelLimit.Rate,
+			Burst: config.Config.Chat.ChannelLimit.Burst,
+		},
+		BotOverrides: botOverrides,
+		MaxWait:      config.Config.Chat.MaxWait,
+		IdleTimeout:  config.Config.Kick.LimiterIdleTimeout,
+	})
+	services.KickManager.OnRateLimited(services.ChatLimiterService.PauseBot)
+	services.KickService = service.NewKickService(
+		services.KickManager,
+		services.ChatLimiterService,
+		service.KickServiceOptions{
+			MaxMessageLength: config.Config.Chat.MaxLength,
+			MaxMessageParts:  config.Config.Chat.MaxParts,
+		},
+	)
+	services.WebhookService = service.NewWebhookService(
+		app.storage,
+		services.AuthModule.AuthModule,
+		services.KickManager,
+		services.KickService,
 	)
-	services.KickService = service.NewKickService(services.KickManager)
-	services.WebhookService = service.NewWebhookService(services.KickManager, services.KickService)
 	services.BotService = service.NewBotService(
 		app.storage,
 		services.TransactionService,
 		services.AuthModule,
 		services.WebhookService,
 		services.KickService,
+		services.PlatformModule,
+	)
+	services.KickManager.OnReauthRequired(services.BotService.HandleReauthRequired)
+	services.StreamService = service.NewStreamService(app.storage, services.TransactionService)
+	services.ModerationService = service.NewModerationService(app.storage)
+	services.ChatSettingsService = service.NewChatSettingsService(app.storage)
+	services.ChatMessageCacheService = service.NewChatMessageCacheService(chatCache)
+	services.WebhookDedupService = service.NewWebhookDedupService(js, app.cache, service.WebhookDedupOptions{
+		TTL: config.Config.Webhooks.DedupTTL,
+	})
+	services.WebhookKeyService = service.NewWebhookKeyService(services.KickManager, service.WebhookKeyOptions{
+		PublicKey:       config.Config.Webhooks.PublicKey,
+		KeyFile:         config.Config.Webhooks.PublicKeyFile,
+		Fetch:           config.Config.Webhooks.PublicKeyFetch,
+		RefreshInterval: config.Config.Webhooks.PublicKeyRefreshPeriod,
+	})
+	services.WebhookReconcilerService = service.NewWebhookReconcilerService(
+		app.storage,
+		services.AuthModule.AuthModule,
+		services.KickManager,
+		services.WebhookService,
+		app.cache,
+		service.WebhookReconcilerOptions{
+			Interval:    config.Config.Webhooks.ReconcileInterval,
+			OrphanGrace: config.Config.Webhooks.ReconcileGrace,
+		},
 	)
 	app.services = services
 
 	// load api middlewares
 	app.apiMiddlewares = apiMiddleware.New(
-		sharedMiddleware.NewAuthMiddleware(app.services.AuthModule),
+		sharedMiddleware.NewAuthMiddleware(app.services.AuthModule.AuthModule),
+		app.services.WebhookDedupService,
+		app.services.WebhookKeyService,
 	)
 
 	// load api controllers
@@ -99,7 +169,10 @@ func main() {
 		WebhookController: apiController.NewWebhookController(
 			app.apiMiddlewares,
 			app.services.BotService,
+			app.services.StreamService,
+			app.services.ModerationService,
 			app.services.PlatformModule,
+			app.services.ChatMessageCacheService,
 		),
 	}
 
@@ -107,9 +180,21 @@ func main() {
 	app.mbControllers = &mbController.Controllers{
 		ChatController: mbController.NewChatController(
 			app.services.KickService,
-			app.services.AuthModule,
+			app.services.AuthModule.AuthModule,
+			app.services.PlatformModule,
+			app.services.ChatSettingsService,
+			app.services.ChatMessageCacheService,
+			js,
+			app.cache,
+			mbController.ChatQueueOptions{
+				Partitions:  config.Config.Chat.QueuePartitions,
+				MaxAttempts: config.Config.Chat.QueueMaxAttempts,
+				MaxAge:      config.Config.Chat.QueueMaxAge,
+			},
 		),
-		BotController: mbController.NewBotController(app.services.BotService),
+		BotController:        mbController.NewBotController(app.services.BotService),
+		StreamController:     mbController.NewStreamController(app.services.StreamService),
+		ModerationController: mbController.NewModerationController(app.services.ModerationService),
 	}
 
 	app.Start()
@@ -136,6 +221,14 @@ func openDB() *pgxpool.Pool {
 	err = pool.Ping(ctx)
 	assert.NoError(err, "openDB: cannot ping")
 
+	// migrations may wait for advisory lock of other replica, so they do not
+	// share the ping timeout
+	migrateCtx, cancelMigrate := context.WithTimeout(context.Background(), config.Config.DB.MigrateTimeout)
+	defer cancelMigrate()
+
+	err = db.Migrate(migrateCtx, pool)
+	assert.NoError(err, "openDB: cannot migrate")
+
 	return pool
 }
 
@@ -152,3 +245,14 @@ func openMB(ctx context.Context) (*nats.Conn, jetstream.JetStream, jetstream.Key
 
 	return nc, js, kv
 }
+
+// openChatCache opens KV bucket of recent chat messages, they expire with bucket TTL
+func openChatCache(ctx context.Context, js jetstream.JetStream) jetstream.KeyValue {
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket: "kick-chat-messages",
+		TTL:    config.Config.Chat.ReplyTTL,
+	})
+	assert.NoError(err, "openChatCache: cannot create KVstore")
+
+	return kv
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/arnokay/arnobot-kick/internal/config"
 	"context"
 	"errors"
+	"expvar"
 	"fmt"
 	"net/http"
 	"os"
@@ -20,6 +21,10 @@ func (app *application) Start() {
 	startError := make(chan error)
 	shutdownError := make(chan error)
 
+	workersCtx, cancelWorkers := context.WithCancel(context.Background())
+	app.cancelWorkers = cancelWorkers
+	startWorkers(workersCtx, app)
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 
@@ -42,6 +47,16 @@ func (app *application) Start() {
 		}
 	}()
 
+	app.metrics = newMetricsServer()
+	if app.metrics != nil {
+		go func() {
+			err := startMetricsServer(app)
+			if err != nil {
+				startError <- err
+			}
+		}()
+	}
+
 	go func() {
 		err := startMBServer(app)
 		if err != nil {
@@ -62,7 +77,10 @@ func (app *application) Start() {
 
 func (app *application) Shutdown(ctx context.Context) error {
 	var wg sync.WaitGroup
-	errCh := make(chan error, 2)
+	errCh := make(chan error, 3)
+
+	app.logger.Debug("#shutdown.workers: stopping background workers")
+	app.cancelWorkers()
 
 	wg.Add(1)
 	go func() {
@@ -103,6 +121,18 @@ func (app *application) Shutdown(ctx context.Context) error {
 		app.logger.Debug("#shutdown.api: gracefully closed api")
 	}()
 
+	if app.metrics != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.logger.Debug("#shutdown.metrics: gracefully closing metrics server")
+			err := app.metrics.Shutdown(ctx)
+			if err != nil {
+				errCh <- err
+			}
+		}()
+	}
+
 	wg.Wait()
 	close(errCh)
 
@@ -117,6 +147,28 @@ func (app *application) Shutdown(ctx context.Context) error {
 	return nil
 }
 
+func startWorkers(ctx context.Context, a *application) {
+	go a.services.WebhookKeyService.Run(ctx)
+	go a.services.WebhookDedupService.Janitor(ctx)
+	go a.services.KickManager.Janitor(ctx)
+	go a.services.KickManager.RunAppTokenRefresh(ctx)
+	go a.services.KickManager.WatchUserTokens(ctx)
+	go a.services.ChatLimiterService.Janitor(ctx)
+	go a.services.WebhookReconcilerService.Run(ctx)
+}
+
+// ready responds with 503 until kick app token is acquired, service is
+// running without it but kick app operations fail
+func (a *application) ready(c echo.Context) error {
+	status := http.StatusOK
+	kickReady := a.services.KickManager.Ready()
+	if !kickReady {
+		status = http.StatusServiceUnavailable
+	}
+
+	return c.JSON(status, map[string]bool{"kick": kickReady})
+}
+
 func startMBServer(a *application) error {
 	if a.msgBroker == nil {
 		return errors.New("startMBServer: msgBroker is nil")
@@ -131,6 +183,34 @@ func startMBServer(a *application) error {
 	return nil
 }
 
+// newMetricsServer returns internal server of expvar, it is never mounted on
+// api server because expvar publishes cmdline with secrets from flags
+func newMetricsServer() *http.Server {
+	addr := config.Config.Global.MetricsAddr
+	if addr == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /debug/vars", expvar.Handler())
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+}
+
+func startMetricsServer(a *application) error {
+	a.logger.Info("starting metrics server", "addr", a.metrics.Addr)
+	err := a.metrics.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
 func startAPIServer(a *application) error {
 	e := echo.New()
 
@@ -143,6 +223,8 @@ func startAPIServer(a *application) error {
 
   e.Use(a.apiMiddlewares.RequestLogger)
 
+	e.GET("/ready", a.ready)
+
 	mainGroup := e.Group("/v1")
 	a.apiControllers.Routes(mainGroup)
 
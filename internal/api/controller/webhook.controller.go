@@ -1,18 +1,19 @@
 package controller
 
 import (
-	
+	"errors"
 	"strconv"
 
+	"github.com/arnokay/arnobot-shared/apperror"
 	"github.com/arnokay/arnobot-shared/applog"
-	"github.com/arnokay/arnobot-shared/events"
+	sharedEvents "github.com/arnokay/arnobot-shared/events"
 	"github.com/arnokay/arnobot-shared/platform"
-	sharedService "github.com/arnokay/arnobot-shared/service"
 	"github.com/labstack/echo/v4"
 	"github.com/scorfly/gokick"
 
 	"github.com/arnokay/arnobot-kick/internal/api/middleware"
 	"github.com/arnokay/arnobot-kick/internal/data"
+	"github.com/arnokay/arnobot-kick/internal/events"
 	"github.com/arnokay/arnobot-kick/internal/service"
 )
 
@@ -21,70 +22,462 @@ type WebhookController struct {
 
 	middlewares *middleware.Middlewares
 
-	kickService    *service.KickService
-	botService     *service.BotService
-	platformModule *sharedService.PlatformModuleOut
+	kickService       *service.KickService
+	botService        *service.BotService
+	streamService     *service.StreamService
+	moderationService *service.ModerationService
+	platformModule    *service.PlatformModuleOut
+	chatMessageCache  *service.ChatMessageCacheService
 }
 
 func NewWebhookController(
 	middlewares *middleware.Middlewares,
 	botService *service.BotService,
-	platformModule *sharedService.PlatformModuleOut,
+	streamService *service.StreamService,
+	moderationService *service.ModerationService,
+	platformModule *service.PlatformModuleOut,
+	chatMessageCache *service.ChatMessageCacheService,
 ) *WebhookController {
-	logger := applog.NewServiceLogger("ChatController")
+	logger := applog.NewServiceLogger("api-webhook-controller")
 
 	return &WebhookController{
 		logger: logger,
 
-		middlewares:    middlewares,
-		botService:     botService,
-		platformModule: platformModule,
+		middlewares:       middlewares,
+		botService:        botService,
+		streamService:     streamService,
+		moderationService: moderationService,
+		platformModule:    platformModule,
+		chatMessageCache:  chatMessageCache,
 	}
 }
 
 func (c *WebhookController) Routes(parentGroup *echo.Group) {
-	parentGroup.POST("/callback", c.Callback, c.middlewares.VerifyKickWebhook)
+	parentGroup.POST(
+		"/callback",
+		c.Callback,
+		c.middlewares.VerifyKickWebhook,
+		c.middlewares.DeduplicateKickWebhook,
+	)
 }
 
 func (c *WebhookController) Callback(ctx echo.Context) error {
 	switch ctx.Request().Header.Get("Kick-Event-Type") {
 	case gokick.SubscriptionNameChatMessage.String():
-		var event gokick.ChatMessageEvent
-		ctx.Bind(&event)
+		return c.chatMessage(ctx)
+	case gokick.SubscriptionNameChannelFollow.String():
+		return c.channelFollow(ctx)
+	case gokick.SubscriptionNameChannelSubscriptionCreated.String():
+		return c.channelSubscriptionCreated(ctx)
+	case gokick.SubscriptionNameChannelSubscriptionRenewal.String():
+		return c.channelSubscriptionRenewal(ctx)
+	case gokick.SubscriptionNameChannelSubscriptionGifts.String():
+		return c.channelSubscriptionGifts(ctx)
+	case gokick.SubscriptionNameLivestreamStatusUpdated.String():
+		return c.livestreamStatusUpdated(ctx)
+	case gokick.SubscriptionNameLivestreamMetadataUpdated.String():
+		return c.livestreamMetadataUpdated(ctx)
+	case gokick.SubscriptionNameModerationBanned.String():
+		return c.moderationBanned(ctx)
+	}
 
-    broadcasterID := strconv.Itoa(event.Broadcaster.UserID)
-		bot, err := c.botService.SelectedBotGetByBroadcasterID(ctx.Request().Context(), broadcasterID)
-		if err != nil {
-			c.logger.ErrorContext(ctx.Request().Context(), "cannot get selected bot")
-			return nil
-		}
+	return nil
+}
 
-		chatterID := strconv.Itoa(event.Sender.UserID)
+func (c *WebhookController) chatMessage(ctx echo.Context) error {
+	var event data.ChatMessageEvent
+	err := ctx.Bind(&event)
+	if err != nil {
+		return c.invalidEvent(ctx, err)
+	}
 
-		internalEvent := events.Message{
-			EventCommon: events.EventCommon{
-				Platform:      platform.Kick,
-				BroadcasterID: broadcasterID,
-				UserID:        bot.UserID,
-				BotID:         bot.BotID,
-			},
+	broadcasterID := strconv.Itoa(event.Broadcaster.UserID)
+	eventCommon, err := c.eventCommon(ctx, broadcasterID)
+	if err != nil {
+		return nil
+	}
+
+	chatterID := strconv.Itoa(event.Sender.UserID)
+
+	internalEvent := events.Message{
+		Message: sharedEvents.Message{
+			EventCommon:      eventCommon,
 			MessageID:        event.MessageID,
 			Message:          event.Content,
-			ReplyTo:          "",
 			BroadcasterLogin: event.Broadcaster.Username,
 			BroadcasterName:  event.Broadcaster.Username,
 			ChatterID:        chatterID,
 			ChatterName:      event.Sender.Username,
 			ChatterRole:      data.GetChatterRole(event.Sender.Identity.Badges),
 			ChatterLogin:     event.Sender.Username,
+		},
+	}
+	if event.RepliesTo != nil {
+		internalEvent.ReplyTo = event.RepliesTo.MessageID
+		internalEvent.ReplyToChatterID = strconv.Itoa(event.RepliesTo.Sender.UserID)
+		internalEvent.ReplyToChatterLogin = event.RepliesTo.Sender.Username
+		internalEvent.ReplyToChatterName = event.RepliesTo.Sender.Username
+	}
+
+	// responses to the message can be sent as replies
+	c.chatMessageCache.Remember(ctx.Request().Context(), broadcasterID, event.MessageID, chatterID)
+
+	err = c.platformModule.ChatMessageNotify(ctx.Request().Context(), internalEvent)
+	if err != nil {
+		c.logger.ErrorContext(ctx.Request().Context(), "cannot notify message")
+		return nil
+	}
+
+	return nil
+}
+
+func (c *WebhookController) channelFollow(ctx echo.Context) error {
+	var event gokick.ChannelFollowEvent
+	err := ctx.Bind(&event)
+	if err != nil {
+		return c.invalidEvent(ctx, err)
+	}
+
+	broadcasterID := strconv.Itoa(event.Broadcaster.UserID)
+	eventCommon, err := c.eventCommon(ctx, broadcasterID)
+	if err != nil {
+		return nil
+	}
+
+	internalEvent := events.Follow{
+		EventCommon:      eventCommon,
+		BroadcasterLogin: event.Broadcaster.Username,
+		BroadcasterName:  event.Broadcaster.Username,
+		FollowerID:       strconv.Itoa(event.Follower.UserID),
+		FollowerLogin:    event.Follower.Username,
+		FollowerName:     event.Follower.Username,
+	}
+
+	err = c.platformModule.ChannelFollowNotify(ctx.Request().Context(), internalEvent)
+	if err != nil {
+		c.logger.ErrorContext(ctx.Request().Context(), "cannot notify follow")
+		return nil
+	}
+
+	return nil
+}
+
+func (c *WebhookController) channelSubscriptionCreated(ctx echo.Context) error {
+	var event gokick.ChannelSubscriptionCreatedEvent
+	err := ctx.Bind(&event)
+	if err != nil {
+		return c.invalidEvent(ctx, err)
+	}
+
+	return c.channelSubscription(ctx, gokick.ChannelSubscriptionRenewalEvent(event), false)
+}
+
+func (c *WebhookController) channelSubscriptionRenewal(ctx echo.Context) error {
+	var event gokick.ChannelSubscriptionRenewalEvent
+	err := ctx.Bind(&event)
+	if err != nil {
+		return c.invalidEvent(ctx, err)
+	}
+
+	return c.channelSubscription(ctx, event, true)
+}
+
+func (c *WebhookController) channelSubscription(
+	ctx echo.Context,
+	event gokick.ChannelSubscriptionRenewalEvent,
+	renewal bool,
+) error {
+	broadcasterID := strconv.Itoa(event.Broadcaster.UserID)
+	eventCommon, err := c.eventCommon(ctx, broadcasterID)
+	if err != nil {
+		return nil
+	}
+
+	internalEvent := events.Subscription{
+		EventCommon:      eventCommon,
+		BroadcasterLogin: event.Broadcaster.Username,
+		BroadcasterName:  event.Broadcaster.Username,
+		SubscriberID:     strconv.Itoa(event.Subscriber.UserID),
+		SubscriberLogin:  event.Subscriber.Username,
+		SubscriberName:   event.Subscriber.Username,
+		Renewal:          renewal,
+		Duration:         event.Duration,
+		CreatedAt:        data.ParseTime(event.CreatedAt),
+		ExpiresAt:        data.ParseTime(event.ExpiresAt),
+	}
+
+	err = c.platformModule.ChannelSubscriptionNotify(ctx.Request().Context(), internalEvent)
+	if err != nil {
+		c.logger.ErrorContext(ctx.Request().Context(), "cannot notify subscription")
+		return nil
+	}
+
+	return nil
+}
+
+func (c *WebhookController) channelSubscriptionGifts(ctx echo.Context) error {
+	var event gokick.ChannelSubscriptionGiftsEvent
+	err := ctx.Bind(&event)
+	if err != nil {
+		return c.invalidEvent(ctx, err)
+	}
+
+	broadcasterID := strconv.Itoa(event.Broadcaster.UserID)
+	eventCommon, err := c.eventCommon(ctx, broadcasterID)
+	if err != nil {
+		return nil
+	}
+
+	internalEvent := events.SubscriptionGift{
+		EventCommon:      eventCommon,
+		BroadcasterLogin: event.Broadcaster.Username,
+		BroadcasterName:  event.Broadcaster.Username,
+		GifterAnonymous:  event.Gifter.IsAnonymous,
+		Recipients:       make([]events.SubscriptionGiftRecipient, 0, len(event.Giftees)),
+		CreatedAt:        data.ParseTime(event.CreatedAt),
+		ExpiresAt:        data.ParseTime(event.ExpiresAt),
+	}
+	if !event.Gifter.IsAnonymous {
+		internalEvent.GifterID = strconv.Itoa(event.Gifter.UserID)
+		internalEvent.GifterLogin = event.Gifter.Username
+		internalEvent.GifterName = event.Gifter.Username
+	}
+	for _, giftee := range event.Giftees {
+		internalEvent.Recipients = append(internalEvent.Recipients, events.SubscriptionGiftRecipient{
+			RecipientID:    strconv.Itoa(giftee.UserID),
+			RecipientLogin: giftee.Username,
+			RecipientName:  giftee.Username,
+		})
+	}
+
+	err = c.platformModule.ChannelSubscriptionGiftNotify(ctx.Request().Context(), internalEvent)
+	if err != nil {
+		c.logger.ErrorContext(ctx.Request().Context(), "cannot notify subscription gift")
+		return nil
+	}
+
+	return nil
+}
+
+func (c *WebhookController) livestreamStatusUpdated(ctx echo.Context) error {
+	var event gokick.LivestreamStatusUpdatedEvent
+	err := ctx.Bind(&event)
+	if err != nil {
+		return c.invalidEvent(ctx, err)
+	}
+
+	broadcasterID := strconv.Itoa(event.Broadcaster.UserID)
+	eventCommon, err := c.eventCommon(ctx, broadcasterID)
+	if err != nil {
+		return nil
+	}
+
+	if event.IsLive {
+		internalEvent := events.StreamOnline{
+			EventCommon:      eventCommon,
+			BroadcasterLogin: event.Broadcaster.Username,
+			BroadcasterName:  event.Broadcaster.Username,
+			Title:            event.Title,
+			StartedAt:        data.ParseTime(event.StartedAt),
 		}
 
-		err = c.platformModule.ChatMessageNotify(ctx.Request().Context(), internalEvent)
+		session, err := c.streamService.StreamSessionStart(ctx.Request().Context(), data.StreamSessionStart{
+			BroadcasterID: broadcasterID,
+			Title:         event.Title,
+			StartedAt:     internalEvent.StartedAt,
+		})
 		if err != nil {
-			c.logger.ErrorContext(ctx.Request().Context(), "cannot notify message")
+			c.logger.ErrorContext(ctx.Request().Context(), "cannot start stream session", "err", err)
+		} else {
+			internalEvent.SessionID = session.ID
+			internalEvent.StartedAt = session.StartedAt
+		}
+
+		err = c.platformModule.StreamOnlineNotify(ctx.Request().Context(), internalEvent)
+		if err != nil {
+			c.logger.ErrorContext(ctx.Request().Context(), "cannot notify stream online")
 			return nil
 		}
+
+		return nil
+	}
+
+	internalEvent := events.StreamOffline{
+		EventCommon:      eventCommon,
+		BroadcasterLogin: event.Broadcaster.Username,
+		BroadcasterName:  event.Broadcaster.Username,
+		StartedAt:        data.ParseTime(event.StartedAt),
+		EndedAt:          data.ParseTime(event.EndedAt),
+	}
+
+	session, err := c.streamService.StreamSessionEnd(ctx.Request().Context(), data.StreamSessionEnd{
+		BroadcasterID: broadcasterID,
+		EndedAt:       internalEvent.EndedAt,
+	})
+	if err != nil {
+		c.logger.ErrorContext(ctx.Request().Context(), "cannot end stream session", "err", err)
+	} else {
+		internalEvent.SessionID = session.ID
+		internalEvent.StartedAt = session.StartedAt
+		internalEvent.EndedAt = *session.EndedAt
+	}
+
+	err = c.platformModule.StreamOfflineNotify(ctx.Request().Context(), internalEvent)
+	if err != nil {
+		c.logger.ErrorContext(ctx.Request().Context(), "cannot notify stream offline")
+		return nil
 	}
 
 	return nil
 }
+
+func (c *WebhookController) livestreamMetadataUpdated(ctx echo.Context) error {
+	var event gokick.LivestreamMetadataUpdatedEvent
+	err := ctx.Bind(&event)
+	if err != nil {
+		return c.invalidEvent(ctx, err)
+	}
+
+	broadcasterID := strconv.Itoa(event.Broadcaster.UserID)
+	eventCommon, err := c.eventCommon(ctx, broadcasterID)
+	if err != nil {
+		return nil
+	}
+
+	change, err := c.streamService.StreamMetadataUpdate(ctx.Request().Context(), data.StreamMetadataUpdate{
+		BroadcasterID:    broadcasterID,
+		Title:            event.Metadata.Title,
+		Language:         event.Metadata.Language,
+		CategoryID:       event.Metadata.Category.ID,
+		CategoryName:     event.Metadata.Category.Name,
+		HasMatureContent: event.Metadata.HasMatureContent,
+	})
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoAction) {
+			return nil
+		}
+		c.logger.ErrorContext(ctx.Request().Context(), "cannot update stream metadata", "err", err)
+		return nil
+	}
+
+	internalEvent := events.StreamMetadataChange{
+		EventCommon:      eventCommon,
+		BroadcasterLogin: event.Broadcaster.Username,
+		BroadcasterName:  event.Broadcaster.Username,
+		New: events.StreamMetadata{
+			Title:            change.New.Title,
+			Language:         change.New.Language,
+			CategoryID:       change.New.CategoryID,
+			CategoryName:     change.New.CategoryName,
+			HasMatureContent: change.New.HasMatureContent,
+		},
+		TitleChanged:    true,
+		CategoryChanged: true,
+		LanguageChanged: true,
+	}
+	if change.Old != nil {
+		internalEvent.Old = &events.StreamMetadata{
+			Title:            change.Old.Title,
+			Language:         change.Old.Language,
+			CategoryID:       change.Old.CategoryID,
+			CategoryName:     change.Old.CategoryName,
+			HasMatureContent: change.Old.HasMatureContent,
+		}
+		internalEvent.TitleChanged = change.Old.Title != change.New.Title
+		internalEvent.CategoryChanged = change.Old.CategoryID != change.New.CategoryID
+		internalEvent.LanguageChanged = change.Old.Language != change.New.Language
+	}
+
+	err = c.platformModule.StreamMetadataNotify(ctx.Request().Context(), internalEvent)
+	if err != nil {
+		c.logger.ErrorContext(ctx.Request().Context(), "cannot notify stream metadata")
+		return nil
+	}
+
+	return nil
+}
+
+func (c *WebhookController) moderationBanned(ctx echo.Context) error {
+	var event data.ModerationBannedEvent
+	err := ctx.Bind(&event)
+	if err != nil {
+		return c.invalidEvent(ctx, err)
+	}
+
+	broadcasterID := strconv.Itoa(event.Broadcaster.UserID)
+	eventCommon, err := c.eventCommon(ctx, broadcasterID)
+	if err != nil {
+		return nil
+	}
+
+	internalEvent := events.ModerationBan{
+		EventCommon:      eventCommon,
+		BroadcasterLogin: event.Broadcaster.Username,
+		BroadcasterName:  event.Broadcaster.Username,
+		ModeratorID:      strconv.Itoa(event.Moderator.UserID),
+		ModeratorLogin:   event.Moderator.Username,
+		ModeratorName:    event.Moderator.Username,
+		TargetID:         strconv.Itoa(event.BannedUser.UserID),
+		TargetLogin:      event.BannedUser.Username,
+		TargetName:       event.BannedUser.Username,
+		Reason:           event.Metadata.Reason,
+		CreatedAt:        data.ParseTime(event.Metadata.CreatedAt),
+	}
+	if event.Metadata.ExpiresAt != nil {
+		expiresAt := data.ParseTime(*event.Metadata.ExpiresAt)
+		if !expiresAt.IsZero() {
+			internalEvent.Timeout = true
+			internalEvent.ExpiresAt = &expiresAt
+		}
+	}
+
+	_, err = c.moderationService.ModerationBanCreate(ctx.Request().Context(), data.ModerationBanCreate{
+		BroadcasterID: broadcasterID,
+		UserID:        internalEvent.TargetID,
+		UserName:      internalEvent.TargetName,
+		ModeratorID:   internalEvent.ModeratorID,
+		ModeratorName: internalEvent.ModeratorName,
+		Reason:        internalEvent.Reason,
+		CreatedAt:     internalEvent.CreatedAt,
+		ExpiresAt:     internalEvent.ExpiresAt,
+	})
+	if err != nil {
+		c.logger.ErrorContext(ctx.Request().Context(), "cannot save moderation ban", "err", err)
+	}
+
+	err = c.platformModule.ModerationBanNotify(ctx.Request().Context(), internalEvent)
+	if err != nil {
+		c.logger.ErrorContext(ctx.Request().Context(), "cannot notify moderation ban")
+		return nil
+	}
+
+	return nil
+}
+
+// invalidEvent rejects webhook with body that cannot be decoded into its event
+func (c *WebhookController) invalidEvent(ctx echo.Context, err error) error {
+	c.logger.WarnContext(
+		ctx.Request().Context(),
+		"cannot decode webhook event",
+		"err", err,
+		"eventType", ctx.Request().Header.Get("Kick-Event-Type"),
+	)
+
+	return apperror.New(apperror.CodeInvalidInput, "cannot decode kick event", err)
+}
+
+// eventCommon resolves selected bot of the broadcaster into events.EventCommon
+func (c *WebhookController) eventCommon(ctx echo.Context, broadcasterID string) (sharedEvents.EventCommon, error) {
+	bot, err := c.botService.SelectedBotGetByBroadcasterID(ctx.Request().Context(), broadcasterID)
+	if err != nil {
+		c.logger.ErrorContext(ctx.Request().Context(), "cannot get selected bot", "broadcasterID", broadcasterID)
+		return sharedEvents.EventCommon{}, err
+	}
+
+	return sharedEvents.EventCommon{
+		Platform:      platform.Kick,
+		BroadcasterID: broadcasterID,
+		UserID:        bot.UserID,
+		BotID:         bot.BotID,
+	}, nil
+}
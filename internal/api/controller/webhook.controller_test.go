@@ -0,0 +1,672 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arnokay/arnobot-shared/apperror"
+	"github.com/arnokay/arnobot-shared/apptype"
+	sharedDB "github.com/arnokay/arnobot-shared/db"
+	"github.com/arnokay/arnobot-shared/middlewares"
+	"github.com/arnokay/arnobot-shared/platform"
+	sharedTopics "github.com/arnokay/arnobot-shared/topics"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/scorfly/gokick"
+
+	"github.com/arnokay/arnobot-kick/internal/db"
+	"github.com/arnokay/arnobot-kick/internal/events"
+	"github.com/arnokay/arnobot-kick/internal/service"
+	"github.com/arnokay/arnobot-kick/internal/topics"
+)
+
+const testBroadcasterID = "100"
+
+// webhookTestEnv is webhook controller with in-memory storage and
+// in-process NATS, webhooks are posted without kick middlewares
+type webhookTestEnv struct {
+	mb    *nats.Conn
+	store *fakeStore
+	api   *echo.Echo
+	bot   sharedDB.KickSelectedBot
+}
+
+func newWebhookTestEnv(t *testing.T) *webhookTestEnv {
+	t.Helper()
+
+	mb := startNATS(t)
+	store := newFakeStore()
+	bot := sharedDB.KickSelectedBot{
+		UserID:        uuid.New(),
+		BroadcasterID: testBroadcasterID,
+		BotID:         "200",
+		Enabled:       true,
+	}
+	store.selectedBots = append(store.selectedBots, bot)
+
+	controller := NewWebhookController(
+		nil,
+		service.NewBotService(store, fakeTx{}, nil, nil, nil, nil),
+		service.NewStreamService(store, fakeTx{}),
+		service.NewModerationService(store),
+		service.NewPlatformModuleOut(mb),
+		nil,
+	)
+
+	api := echo.New()
+	api.HTTPErrorHandler = middlewares.ErrHandler
+	api.POST("/v1/callback", controller.Callback)
+
+	return &webhookTestEnv{
+		mb:    mb,
+		store: store,
+		api:   api,
+		bot:   bot,
+	}
+}
+
+func (env *webhookTestEnv) post(t *testing.T, eventType gokick.SubscriptionName, body string) int {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/callback", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Kick-Event-Type", eventType.String())
+	rec := httptest.NewRecorder()
+	env.api.ServeHTTP(rec, req)
+
+	// events are published before the response, flush makes sure they are
+	// delivered to subscriptions of the test
+	err := env.mb.Flush()
+	if err != nil {
+		t.Fatalf("cannot flush nats: %v", err)
+	}
+
+	return rec.Code
+}
+
+// subscribe returns events published to the kick topic of test broadcaster
+func (env *webhookTestEnv) subscribe(t *testing.T, topic string) chan *nats.Msg {
+	t.Helper()
+
+	topic = sharedTopics.TopicBuilder(topic).
+		Platform(platform.Kick).
+		BroadcasterID(testBroadcasterID).
+		Build()
+
+	ch := make(chan *nats.Msg, 16)
+	_, err := env.mb.ChanSubscribe(topic, ch)
+	if err != nil {
+		t.Fatalf("cannot subscribe to %s: %v", topic, err)
+	}
+
+	return ch
+}
+
+func receive[T any](t *testing.T, ch chan *nats.Msg) T {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		var req apptype.Request[T]
+		err := req.Decode(msg.Data)
+		if err != nil {
+			t.Fatalf("cannot decode event: %v", err)
+		}
+		return req.Data
+	case <-time.After(2 * time.Second):
+		t.Fatal("event is not published")
+	}
+
+	var event T
+	return event
+}
+
+func noEvent(t *testing.T, ch chan *nats.Msg) {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		t.Fatalf("unexpected event on %s: %s", msg.Subject, msg.Data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWebhookChannelFollow(t *testing.T) {
+	env := newWebhookTestEnv(t)
+	follows := env.subscribe(t, topics.PlatformBroadcasterChannelFollowNotify)
+
+	code := env.post(t, gokick.SubscriptionNameChannelFollow, `{
+		"broadcaster": {"user_id": 100, "username": "broadcaster"},
+		"follower": {"user_id": 300, "username": "follower"}
+	}`)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", code)
+	}
+
+	event := receive[events.Follow](t, follows)
+	if event.Platform != platform.Kick || event.BroadcasterID != testBroadcasterID {
+		t.Errorf("got event of %s/%s, want kick/%s", event.Platform, event.BroadcasterID, testBroadcasterID)
+	}
+	if event.UserID != env.bot.UserID || event.BotID != env.bot.BotID {
+		t.Errorf("event is not of selected bot: %+v", event.EventCommon)
+	}
+	if event.FollowerID != "300" || event.FollowerName != "follower" || event.BroadcasterName != "broadcaster" {
+		t.Errorf("got follow %+v", event)
+	}
+}
+
+func TestWebhookChannelFollowOfUnknownBroadcaster(t *testing.T) {
+	env := newWebhookTestEnv(t)
+	follows := sharedTopics.TopicBuilder(topics.PlatformBroadcasterChannelFollowNotify).
+		Platform(platform.Kick).
+		BroadcasterID("999").
+		Build()
+	ch := make(chan *nats.Msg, 1)
+	_, err := env.mb.ChanSubscribe(follows, ch)
+	if err != nil {
+		t.Fatalf("cannot subscribe: %v", err)
+	}
+
+	// kick must not retry webhook of channel without bot
+	code := env.post(t, gokick.SubscriptionNameChannelFollow, `{
+		"broadcaster": {"user_id": 999, "username": "unknown"},
+		"follower": {"user_id": 300, "username": "follower"}
+	}`)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", code)
+	}
+	noEvent(t, ch)
+}
+
+func TestWebhookRejectsInvalidBody(t *testing.T) {
+	eventTypes := []gokick.SubscriptionName{
+		gokick.SubscriptionNameChatMessage,
+		gokick.SubscriptionNameChannelFollow,
+		gokick.SubscriptionNameChannelSubscriptionCreated,
+		gokick.SubscriptionNameChannelSubscriptionRenewal,
+		gokick.SubscriptionNameChannelSubscriptionGifts,
+		gokick.SubscriptionNameLivestreamStatusUpdated,
+		gokick.SubscriptionNameLivestreamMetadataUpdated,
+		gokick.SubscriptionNameModerationBanned,
+	}
+
+	env := newWebhookTestEnv(t)
+	all := make(chan *nats.Msg, 16)
+	_, err := env.mb.ChanSubscribe(">", all)
+	if err != nil {
+		t.Fatalf("cannot subscribe: %v", err)
+	}
+
+	for _, eventType := range eventTypes {
+		t.Run(eventType.String(), func(t *testing.T) {
+			code := env.post(t, eventType, `{"broadcaster": {"user_id": "100"`)
+			if code != http.StatusBadRequest {
+				t.Errorf("got status %d, want 400", code)
+			}
+		})
+	}
+
+	noEvent(t, all)
+	if len(env.store.sessions) != 0 || len(env.store.metadata) != 0 || len(env.store.bans) != 0 {
+		t.Error("invalid webhook is written to storage")
+	}
+}
+
+func TestWebhookChannelSubscription(t *testing.T) {
+	tests := []struct {
+		eventType gokick.SubscriptionName
+		renewal   bool
+	}{
+		{gokick.SubscriptionNameChannelSubscriptionCreated, false},
+		{gokick.SubscriptionNameChannelSubscriptionRenewal, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.eventType.String(), func(t *testing.T) {
+			env := newWebhookTestEnv(t)
+			subscriptions := env.subscribe(t, topics.PlatformBroadcasterChannelSubscriptionNotify)
+
+			code := env.post(t, tt.eventType, `{
+				"broadcaster": {"user_id": 100, "username": "broadcaster"},
+				"subscriber": {"user_id": 300, "username": "subscriber"},
+				"duration": 3,
+				"created_at": "2025-01-14T16:08:06Z",
+				"expires_at": "2025-02-14T16:08:06Z"
+			}`)
+			if code != http.StatusOK {
+				t.Fatalf("got status %d, want 200", code)
+			}
+
+			event := receive[events.Subscription](t, subscriptions)
+			if event.BotID != env.bot.BotID {
+				t.Errorf("got bot %q, want %q", event.BotID, env.bot.BotID)
+			}
+			if event.Renewal != tt.renewal {
+				t.Errorf("got renewal %v, want %v", event.Renewal, tt.renewal)
+			}
+			if event.SubscriberID != "300" || event.Duration != 3 {
+				t.Errorf("got subscription %+v", event)
+			}
+			wantCreatedAt := time.Date(2025, 1, 14, 16, 8, 6, 0, time.UTC)
+			if !event.CreatedAt.Equal(wantCreatedAt) || !event.ExpiresAt.Equal(wantCreatedAt.AddDate(0, 1, 0)) {
+				t.Errorf("got times %s - %s", event.CreatedAt, event.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestWebhookChannelSubscriptionGifts(t *testing.T) {
+	tests := []struct {
+		name       string
+		gifter     string
+		wantGifter string
+		anonymous  bool
+	}{
+		{
+			name:       "named gifter",
+			gifter:     `{"is_anonymous": false, "user_id": 300, "username": "gifter"}`,
+			wantGifter: "300",
+		},
+		{
+			name:      "anonymous gifter",
+			gifter:    `{"is_anonymous": true, "user_id": null, "username": null}`,
+			anonymous: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newWebhookTestEnv(t)
+			gifts := env.subscribe(t, topics.PlatformBroadcasterChannelSubscriptionGiftNotify)
+
+			code := env.post(t, gokick.SubscriptionNameChannelSubscriptionGifts, `{
+				"broadcaster": {"user_id": 100, "username": "broadcaster"},
+				"gifter": `+tt.gifter+`,
+				"giftees": [
+					{"user_id": 401, "username": "giftee1"},
+					{"user_id": 402, "username": "giftee2"}
+				],
+				"created_at": "2025-01-14T16:08:06Z",
+				"expires_at": "2025-02-14T16:08:06Z"
+			}`)
+			if code != http.StatusOK {
+				t.Fatalf("got status %d, want 200", code)
+			}
+
+			event := receive[events.SubscriptionGift](t, gifts)
+			if event.GifterAnonymous != tt.anonymous || event.GifterID != tt.wantGifter {
+				t.Errorf("got gifter %q anonymous %v, want %q anonymous %v",
+					event.GifterID, event.GifterAnonymous, tt.wantGifter, tt.anonymous)
+			}
+			if len(event.Recipients) != 2 ||
+				event.Recipients[0].RecipientID != "401" ||
+				event.Recipients[1].RecipientID != "402" {
+				t.Errorf("got recipients %+v", event.Recipients)
+			}
+		})
+	}
+}
+
+func TestWebhookLivestreamStatusUpdated(t *testing.T) {
+	env := newWebhookTestEnv(t)
+	onlines := env.subscribe(t, topics.PlatformBroadcasterStreamOnlineNotify)
+	offlines := env.subscribe(t, topics.PlatformBroadcasterStreamOfflineNotify)
+
+	online := `{
+		"broadcaster": {"user_id": 100, "username": "broadcaster"},
+		"is_live": true,
+		"title": "stream",
+		"started_at": "2025-01-01T11:00:00Z",
+		"ended_at": null
+	}`
+	code := env.post(t, gokick.SubscriptionNameLivestreamStatusUpdated, online)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", code)
+	}
+
+	startedAt := time.Date(2025, 1, 1, 11, 0, 0, 0, time.UTC)
+	onlineEvent := receive[events.StreamOnline](t, onlines)
+	if onlineEvent.SessionID == 0 || onlineEvent.Title != "stream" || !onlineEvent.StartedAt.Equal(startedAt) {
+		t.Errorf("got online %+v", onlineEvent)
+	}
+	session := env.store.session(onlineEvent.SessionID)
+	if session.BroadcasterID != testBroadcasterID || session.EndedAt != nil {
+		t.Fatalf("got stored session %+v", session)
+	}
+
+	// retry of online webhook continues the same session
+	env.post(t, gokick.SubscriptionNameLivestreamStatusUpdated, online)
+	retryEvent := receive[events.StreamOnline](t, onlines)
+	if retryEvent.SessionID != onlineEvent.SessionID {
+		t.Errorf("retry started session %d, want %d", retryEvent.SessionID, onlineEvent.SessionID)
+	}
+
+	code = env.post(t, gokick.SubscriptionNameLivestreamStatusUpdated, `{
+		"broadcaster": {"user_id": 100, "username": "broadcaster"},
+		"is_live": false,
+		"title": "stream",
+		"started_at": "2025-01-01T11:00:00Z",
+		"ended_at": "2025-01-01T15:00:00Z"
+	}`)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", code)
+	}
+
+	offlineEvent := receive[events.StreamOffline](t, offlines)
+	endedAt := time.Date(2025, 1, 1, 15, 0, 0, 0, time.UTC)
+	if offlineEvent.SessionID != onlineEvent.SessionID || !offlineEvent.EndedAt.Equal(endedAt) {
+		t.Errorf("got offline %+v", offlineEvent)
+	}
+	session = env.store.session(onlineEvent.SessionID)
+	if session.EndedAt == nil || !session.EndedAt.Equal(endedAt) {
+		t.Errorf("stored session is not ended: %+v", session)
+	}
+}
+
+func TestWebhookLivestreamMetadataUpdated(t *testing.T) {
+	env := newWebhookTestEnv(t)
+	changes := env.subscribe(t, topics.PlatformBroadcasterStreamMetadataNotify)
+
+	metadata := func(title, category string) string {
+		return `{
+			"broadcaster": {"user_id": 100, "username": "broadcaster"},
+			"metadata": {
+				"title": "` + title + `",
+				"language": "en",
+				"has_mature_content": false,
+				"category": {"id": "` + category + `", "name": "category ` + category + `"}
+			}
+		}`
+	}
+
+	code := env.post(t, gokick.SubscriptionNameLivestreamMetadataUpdated, metadata("title", "1"))
+	if code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", code)
+	}
+	first := receive[events.StreamMetadataChange](t, changes)
+	if first.Old != nil || first.New.Title != "title" || first.New.CategoryName != "category 1" {
+		t.Errorf("got first change %+v", first)
+	}
+
+	// unchanged metadata is not published again
+	env.post(t, gokick.SubscriptionNameLivestreamMetadataUpdated, metadata("title", "1"))
+	noEvent(t, changes)
+
+	env.post(t, gokick.SubscriptionNameLivestreamMetadataUpdated, metadata("new title", "1"))
+	change := receive[events.StreamMetadataChange](t, changes)
+	if change.Old == nil || change.Old.Title != "title" || change.New.Title != "new title" {
+		t.Errorf("got change %+v", change)
+	}
+	if !change.TitleChanged || change.CategoryChanged || change.LanguageChanged {
+		t.Errorf("got changed title %v, category %v, language %v, want only title",
+			change.TitleChanged, change.CategoryChanged, change.LanguageChanged)
+	}
+
+	if len(env.store.metadata) != 2 {
+		t.Errorf("got %d stored metadata, want 2", len(env.store.metadata))
+	}
+}
+
+func TestWebhookModerationBanned(t *testing.T) {
+	tests := []struct {
+		name        string
+		expiresAt   string
+		wantTimeout bool
+	}{
+		{
+			name:      "ban",
+			expiresAt: `null`,
+		},
+		{
+			name:        "timeout",
+			expiresAt:   `"2025-01-14T16:18:06Z"`,
+			wantTimeout: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newWebhookTestEnv(t)
+			bans := env.subscribe(t, topics.PlatformBroadcasterModerationBanNotify)
+
+			code := env.post(t, gokick.SubscriptionNameModerationBanned, `{
+				"broadcaster": {"user_id": 100, "username": "broadcaster"},
+				"moderator": {"user_id": 300, "username": "moderator"},
+				"banned_user": {"user_id": 400, "username": "banned"},
+				"metadata": {
+					"reason": "spam",
+					"created_at": "2025-01-14T16:08:06Z",
+					"expires_at": `+tt.expiresAt+`
+				}
+			}`)
+			if code != http.StatusOK {
+				t.Fatalf("got status %d, want 200", code)
+			}
+
+			event := receive[events.ModerationBan](t, bans)
+			if event.TargetID != "400" || event.ModeratorID != "300" || event.Reason != "spam" {
+				t.Errorf("got ban %+v", event)
+			}
+			if event.Timeout != tt.wantTimeout || (event.ExpiresAt != nil) != tt.wantTimeout {
+				t.Errorf("got timeout %v until %v, want timeout %v", event.Timeout, event.ExpiresAt, tt.wantTimeout)
+			}
+
+			if len(env.store.bans) != 1 {
+				t.Fatalf("got %d stored bans, want 1", len(env.store.bans))
+			}
+			ban := env.store.bans[0]
+			if ban.BroadcasterID != testBroadcasterID || ban.UserID != "400" || ban.Reason != "spam" {
+				t.Errorf("got stored ban %+v", ban)
+			}
+			if (ban.ExpiresAt != nil) != tt.wantTimeout {
+				t.Errorf("got stored ban expiring at %v, want timeout %v", ban.ExpiresAt, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func startNATS(t *testing.T) *nats.Conn {
+	t.Helper()
+
+	ns, err := server.NewServer(&server.Options{
+		DontListen: true,
+		NoLog:      true,
+		NoSigs:     true,
+	})
+	if err != nil {
+		t.Fatalf("cannot create nats server: %v", err)
+	}
+	ns.Start()
+	t.Cleanup(ns.Shutdown)
+
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server is not ready")
+	}
+
+	mb, err := nats.Connect("", nats.InProcessServer(ns))
+	if err != nil {
+		t.Fatalf("cannot connect to nats server: %v", err)
+	}
+	t.Cleanup(mb.Close)
+
+	return mb
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Begin(ctx context.Context) (context.Context, error) { return ctx, nil }
+func (fakeTx) Commit(ctx context.Context) error                   { return nil }
+func (fakeTx) Rollback(ctx context.Context) error                 { return nil }
+
+// fakeStore is in-memory storage of what webhooks write, queries the tests
+// do not need panic
+type fakeStore struct {
+	mu           sync.Mutex
+	selectedBots []sharedDB.KickSelectedBot
+	sessions     []db.KickStreamSession
+	metadata     []db.KickStreamMetadatum
+	bans         []db.KickModerationBan
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{}
+}
+
+func (s *fakeStore) Query(ctx context.Context) sharedDB.Querier {
+	return &fakeQueries{store: s}
+}
+
+func (s *fakeStore) KickQuery(ctx context.Context) db.Querier {
+	return &fakeKickQueries{store: s}
+}
+
+func (s *fakeStore) Database(ctx context.Context) sharedDB.DBTX {
+	return nil
+}
+
+func (s *fakeStore) HandleErr(ctx context.Context, err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apperror.ErrNotFound
+	}
+
+	return err
+}
+
+func (s *fakeStore) session(id int32) db.KickStreamSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, session := range s.sessions {
+		if session.ID == id {
+			return session
+		}
+	}
+
+	return db.KickStreamSession{}
+}
+
+type fakeQueries struct {
+	sharedDB.Querier
+	store *fakeStore
+}
+
+func (q *fakeQueries) KickSelectedBotGetByBroadcasterID(ctx context.Context, broadcasterID string) (sharedDB.KickSelectedBot, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	for _, bot := range q.store.selectedBots {
+		if bot.BroadcasterID == broadcasterID {
+			return bot, nil
+		}
+	}
+
+	return sharedDB.KickSelectedBot{}, pgx.ErrNoRows
+}
+
+type fakeKickQueries struct {
+	db.Querier
+	store *fakeStore
+}
+
+func (q *fakeKickQueries) KickStreamSessionCreate(ctx context.Context, arg db.KickStreamSessionCreateParams) (db.KickStreamSession, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	session := db.KickStreamSession{
+		ID:            int32(len(q.store.sessions) + 1),
+		BroadcasterID: arg.BroadcasterID,
+		Title:         arg.Title,
+		StartedAt:     arg.StartedAt,
+	}
+	q.store.sessions = append(q.store.sessions, session)
+
+	return session, nil
+}
+
+func (q *fakeKickQueries) KickStreamSessionEnd(ctx context.Context, arg db.KickStreamSessionEndParams) (db.KickStreamSession, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	for i, session := range q.store.sessions {
+		if session.BroadcasterID == arg.BroadcasterID && session.EndedAt == nil {
+			endedAt := arg.EndedAt
+			q.store.sessions[i].EndedAt = &endedAt
+			return q.store.sessions[i], nil
+		}
+	}
+
+	return db.KickStreamSession{}, pgx.ErrNoRows
+}
+
+func (q *fakeKickQueries) KickStreamSessionGetActive(ctx context.Context, broadcasterID string) (db.KickStreamSession, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	for _, session := range q.store.sessions {
+		if session.BroadcasterID == broadcasterID && session.EndedAt == nil {
+			return session, nil
+		}
+	}
+
+	return db.KickStreamSession{}, pgx.ErrNoRows
+}
+
+func (q *fakeKickQueries) KickStreamMetadataCreate(ctx context.Context, arg db.KickStreamMetadataCreateParams) (db.KickStreamMetadatum, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	metadata := db.KickStreamMetadatum{
+		ID:               int32(len(q.store.metadata) + 1),
+		BroadcasterID:    arg.BroadcasterID,
+		Title:            arg.Title,
+		Language:         arg.Language,
+		CategoryID:       arg.CategoryID,
+		CategoryName:     arg.CategoryName,
+		HasMatureContent: arg.HasMatureContent,
+		CreatedAt:        time.Now(),
+	}
+	q.store.metadata = append(q.store.metadata, metadata)
+
+	return metadata, nil
+}
+
+func (q *fakeKickQueries) KickStreamMetadataGetLatest(ctx context.Context, broadcasterID string) (db.KickStreamMetadatum, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	for i := len(q.store.metadata) - 1; i >= 0; i-- {
+		if q.store.metadata[i].BroadcasterID == broadcasterID {
+			return q.store.metadata[i], nil
+		}
+	}
+
+	return db.KickStreamMetadatum{}, pgx.ErrNoRows
+}
+
+func (q *fakeKickQueries) KickModerationBanUpsert(ctx context.Context, arg db.KickModerationBanUpsertParams) (db.KickModerationBan, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	ban := db.KickModerationBan{
+		BroadcasterID: arg.BroadcasterID,
+		UserID:        arg.UserID,
+		UserName:      arg.UserName,
+		ModeratorID:   arg.ModeratorID,
+		ModeratorName: arg.ModeratorName,
+		Reason:        arg.Reason,
+		CreatedAt:     arg.CreatedAt,
+		ExpiresAt:     arg.ExpiresAt,
+	}
+	q.store.bans = append(q.store.bans, ban)
+
+	return ban, nil
+}
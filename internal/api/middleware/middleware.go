@@ -2,7 +2,9 @@ package middleware
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"net/http"
 	"time"
 
 	"github.com/arnokay/arnobot-shared/appctx"
@@ -11,39 +13,93 @@ import (
 	"github.com/arnokay/arnobot-shared/middlewares"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"github.com/scorfly/gokick"
+
+	"github.com/arnokay/arnobot-kick/internal/config"
+	"github.com/arnokay/arnobot-kick/internal/service"
 )
 
 type Middlewares struct {
 	logger applog.Logger
 
 	AuthMiddlewares *middlewares.AuthMiddlewares
+
+	webhookDedupService *service.WebhookDedupService
+	webhookKeyService   *service.WebhookKeyService
+
+	maxBodySize     int64
+	freshnessWindow time.Duration
 }
 
 func New(
 	authMiddlewares *middlewares.AuthMiddlewares,
+	webhookDedupService *service.WebhookDedupService,
+	webhookKeyService *service.WebhookKeyService,
 ) *Middlewares {
 	logger := applog.NewServiceLogger("app-middleware")
 
 	return &Middlewares{
-		logger:          logger,
-		AuthMiddlewares: authMiddlewares,
+		logger:              logger,
+		AuthMiddlewares:     authMiddlewares,
+		webhookDedupService: webhookDedupService,
+		webhookKeyService:   webhookKeyService,
+		maxBodySize:         config.Config.Webhooks.MaxBodySize,
+		freshnessWindow:     config.Config.Webhooks.FreshnessWindow,
 	}
 }
 
+// VerifyKickWebhook checks size, freshness and signature of kick webhook.
+// Replays of captured payload are rejected when the timestamp is outside of
+// the freshness window, inside of the window message id is already known to
+// DeduplicateKickWebhook (dedup ttl is at least twice the window), so the
+// replay is acknowledged but never processed.
 func (m *Middlewares) VerifyKickWebhook(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		// TODO: handle length of request body
-		body, err := io.ReadAll(c.Request().Body)
+		ctx := c.Request().Context()
+
+		if c.Request().ContentLength > m.maxBodySize {
+			m.logger.WarnContext(ctx, "webhook body is too large", "size", c.Request().ContentLength)
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "request body is too large")
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request().Body, m.maxBodySize+1))
 		if err != nil {
-			m.logger.ErrorContext(c.Request().Context(), "cannot read body", "err", err)
-			return apperror.ErrUnauthorized
+			m.logger.ErrorContext(ctx, "cannot read body", "err", err)
+			return apperror.New(apperror.CodeInvalidInput, "cannot read request body", err)
 		}
 		c.Request().Body.Close()
+		if int64(len(body)) > m.maxBodySize {
+			m.logger.WarnContext(ctx, "webhook body is too large", "size", len(body))
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "request body is too large")
+		}
 		c.Request().Body = io.NopCloser(bytes.NewReader(body))
 
-		if !gokick.ValidateEvent(c.Request().Header, body) {
-			m.logger.ErrorContext(c.Request().Context(), "unverified attempt to access webhook")
+		header := c.Request().Header
+		if header.Get("Kick-Event-Message-Id") == "" ||
+			header.Get("Kick-Event-Message-Timestamp") == "" ||
+			header.Get("Kick-Event-Signature") == "" {
+			m.logger.WarnContext(ctx, "webhook is missing kick headers")
+			return apperror.New(apperror.CodeInvalidInput, "missing kick event headers", nil)
+		}
+
+		timestamp, err := time.Parse(time.RFC3339, header.Get("Kick-Event-Message-Timestamp"))
+		if err != nil {
+			m.logger.WarnContext(ctx, "invalid webhook timestamp", "timestamp", header.Get("Kick-Event-Message-Timestamp"))
+			return apperror.New(apperror.CodeInvalidInput, "invalid kick event timestamp", err)
+		}
+
+		skew := time.Since(timestamp)
+		if skew > m.freshnessWindow || skew < -m.freshnessWindow {
+			m.logger.WarnContext(
+				ctx,
+				"webhook timestamp is outside of freshness window",
+				"timestamp", timestamp,
+				"messageID", header.Get("Kick-Event-Message-Id"),
+			)
+			return apperror.New(apperror.CodeUnauthorized, "kick event timestamp is outside of allowed window", nil)
+		}
+
+		if !m.webhookKeyService.Verify(header, body) {
+			m.logger.ErrorContext(ctx, "unverified attempt to access webhook")
 			return apperror.ErrUnauthorized
 		}
 
@@ -51,6 +107,38 @@ func (m *Middlewares) VerifyKickWebhook(next echo.HandlerFunc) echo.HandlerFunc
 	}
 }
 
+// DeduplicateKickWebhook skips kick webhook retries, duplicates are
+// acknowledged with 200 so kick stops retrying
+func (m *Middlewares) DeduplicateKickWebhook(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		messageID := c.Request().Header.Get("Kick-Event-Message-Id")
+		if messageID == "" {
+			return next(c)
+		}
+
+		err := m.webhookDedupService.Claim(ctx, messageID)
+		if err != nil {
+			if errors.Is(err, apperror.ErrAlreadyExists) {
+				m.logger.DebugContext(ctx, "duplicate webhook message", "messageID", messageID)
+				return c.NoContent(http.StatusOK)
+			}
+			return err
+		}
+
+		err = next(c)
+		if err != nil {
+			// kick retries failed message, the retry has to be processed
+			m.webhookDedupService.Release(ctx, messageID)
+			return err
+		}
+
+		m.webhookDedupService.Done(ctx, messageID)
+
+		return nil
+	}
+}
+
 func (m *Middlewares) RequestLogger(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
     now := time.Now()
@@ -67,7 +155,7 @@ func (m *Middlewares) RequestLogger(next echo.HandlerFunc) echo.HandlerFunc {
 			"user_id", userID,
 		)
 
-		next(c)
+		err := next(c)
 
 		m.logger.DebugContext(
 			c.Request().Context(),
@@ -78,6 +166,6 @@ func (m *Middlewares) RequestLogger(next echo.HandlerFunc) echo.HandlerFunc {
       "took", time.Since(now).Milliseconds(),
 		)
 
-    return nil
+    return err
 	}
 }
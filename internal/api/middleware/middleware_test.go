@@ -0,0 +1,370 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arnokay/arnobot-shared/applog"
+	"github.com/arnokay/arnobot-shared/middlewares"
+	"github.com/labstack/echo/v4"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/arnokay/arnobot-kick/internal/service"
+)
+
+const (
+	testMaxBodySize     = 64
+	testFreshnessWindow = time.Minute
+)
+
+// webhookTestEnv is echo with kick webhook middlewares in front of handler
+// that counts calls
+type webhookTestEnv struct {
+	api        *echo.Echo
+	privateKey *rsa.PrivateKey
+	// handled are bodies the handler got
+	handled []string
+	// handlerErr is returned by the handler
+	handlerErr error
+}
+
+func newWebhookTestEnv(t *testing.T) *webhookTestEnv {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("cannot marshal public key: %v", err)
+	}
+
+	mb := startNATS(t)
+	js, err := jetstream.New(mb)
+	if err != nil {
+		t.Fatalf("cannot create jetstream: %v", err)
+	}
+	kv, err := js.CreateKeyValue(context.Background(), jetstream.KeyValueConfig{Bucket: "default-kick"})
+	if err != nil {
+		t.Fatalf("cannot create kv: %v", err)
+	}
+
+	m := &Middlewares{
+		logger:              applog.NewServiceLogger("app-middleware"),
+		webhookDedupService: service.NewWebhookDedupService(js, kv, service.WebhookDedupOptions{TTL: time.Hour}),
+		webhookKeyService: service.NewWebhookKeyService(nil, service.WebhookKeyOptions{
+			PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})),
+		}),
+		maxBodySize:     testMaxBodySize,
+		freshnessWindow: testFreshnessWindow,
+	}
+
+	env := &webhookTestEnv{
+		api:        echo.New(),
+		privateKey: privateKey,
+	}
+	env.api.HTTPErrorHandler = middlewares.ErrHandler
+	env.api.POST(
+		"/callback",
+		func(c echo.Context) error {
+			body, _ := io.ReadAll(c.Request().Body)
+			env.handled = append(env.handled, string(body))
+			return env.handlerErr
+		},
+		m.VerifyKickWebhook,
+		m.DeduplicateKickWebhook,
+	)
+
+	return env
+}
+
+// header returns kick headers of the webhook signed at the time
+func (env *webhookTestEnv) header(t *testing.T, messageID string, timestamp time.Time, body string) http.Header {
+	t.Helper()
+
+	header := http.Header{}
+	header.Set("Kick-Event-Message-Id", messageID)
+	header.Set("Kick-Event-Message-Timestamp", timestamp.UTC().Format(time.RFC3339))
+
+	payload := bytes.Join([][]byte{
+		[]byte(messageID),
+		[]byte(header.Get("Kick-Event-Message-Timestamp")),
+		[]byte(body),
+	}, []byte("."))
+	hashed := sha256.Sum256(payload)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, env.privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatalf("cannot sign webhook: %v", err)
+	}
+	header.Set("Kick-Event-Signature", base64.StdEncoding.EncodeToString(signature))
+
+	return header
+}
+
+func (env *webhookTestEnv) post(header http.Header, body io.Reader, contentLength int64) int {
+	req := httptest.NewRequest(http.MethodPost, "/callback", body)
+	req.Header = header
+	req.ContentLength = contentLength
+	rec := httptest.NewRecorder()
+	env.api.ServeHTTP(rec, req)
+
+	return rec.Code
+}
+
+func TestVerifyKickWebhook(t *testing.T) {
+	const body = `{"follower":{"user_id":1}}`
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		// request returns headers, body and content length of the request
+		request    func(env *webhookTestEnv) (http.Header, io.Reader, int64)
+		wantStatus int
+	}{
+		{
+			name: "valid webhook",
+			request: func(env *webhookTestEnv) (http.Header, io.Reader, int64) {
+				return env.header(t, "id", now, body), strings.NewReader(body), int64(len(body))
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "declared body over limit",
+			request: func(env *webhookTestEnv) (http.Header, io.Reader, int64) {
+				return env.header(t, "id", now, body), strings.NewReader(body), testMaxBodySize + 1
+			},
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "chunked body over limit",
+			request: func(env *webhookTestEnv) (http.Header, io.Reader, int64) {
+				large := strings.Repeat("a", testMaxBodySize+1)
+				return env.header(t, "id", now, large), strings.NewReader(large), -1
+			},
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "body at limit",
+			request: func(env *webhookTestEnv) (http.Header, io.Reader, int64) {
+				limit := strings.Repeat("a", testMaxBodySize)
+				return env.header(t, "id", now, limit), strings.NewReader(limit), -1
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "missing message id",
+			request: func(env *webhookTestEnv) (http.Header, io.Reader, int64) {
+				header := env.header(t, "id", now, body)
+				header.Del("Kick-Event-Message-Id")
+				return header, strings.NewReader(body), int64(len(body))
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "missing timestamp",
+			request: func(env *webhookTestEnv) (http.Header, io.Reader, int64) {
+				header := env.header(t, "id", now, body)
+				header.Del("Kick-Event-Message-Timestamp")
+				return header, strings.NewReader(body), int64(len(body))
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "missing signature",
+			request: func(env *webhookTestEnv) (http.Header, io.Reader, int64) {
+				header := env.header(t, "id", now, body)
+				header.Del("Kick-Event-Signature")
+				return header, strings.NewReader(body), int64(len(body))
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "invalid timestamp",
+			request: func(env *webhookTestEnv) (http.Header, io.Reader, int64) {
+				header := env.header(t, "id", now, body)
+				header.Set("Kick-Event-Message-Timestamp", now.Format(time.RFC1123))
+				return header, strings.NewReader(body), int64(len(body))
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "stale timestamp",
+			request: func(env *webhookTestEnv) (http.Header, io.Reader, int64) {
+				stale := now.Add(-testFreshnessWindow - time.Minute)
+				return env.header(t, "id", stale, body), strings.NewReader(body), int64(len(body))
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "future timestamp",
+			request: func(env *webhookTestEnv) (http.Header, io.Reader, int64) {
+				future := now.Add(testFreshnessWindow + time.Minute)
+				return env.header(t, "id", future, body), strings.NewReader(body), int64(len(body))
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "timestamp within window",
+			request: func(env *webhookTestEnv) (http.Header, io.Reader, int64) {
+				skewed := now.Add(testFreshnessWindow / 2)
+				return env.header(t, "id", skewed, body), strings.NewReader(body), int64(len(body))
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "bad signature",
+			request: func(env *webhookTestEnv) (http.Header, io.Reader, int64) {
+				header := env.header(t, "id", now, body)
+				header.Set("Kick-Event-Signature", base64.StdEncoding.EncodeToString([]byte("signature")))
+				return header, strings.NewReader(body), int64(len(body))
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "changed body",
+			request: func(env *webhookTestEnv) (http.Header, io.Reader, int64) {
+				changed := `{"follower":{"user_id":2}}`
+				return env.header(t, "id", now, body), strings.NewReader(changed), int64(len(changed))
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "replayed with new timestamp",
+			request: func(env *webhookTestEnv) (http.Header, io.Reader, int64) {
+				header := env.header(t, "id", now.Add(-2*testFreshnessWindow), body)
+				header.Set("Kick-Event-Message-Timestamp", now.UTC().Format(time.RFC3339))
+				return header, strings.NewReader(body), int64(len(body))
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newWebhookTestEnv(t)
+
+			code := env.post(tt.request(env))
+			if code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", code, tt.wantStatus)
+			}
+
+			handled := len(env.handled) == 1
+			if handled != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("webhook with status %d is handled: %v", code, handled)
+			}
+		})
+	}
+}
+
+func TestVerifyKickWebhookPassesBody(t *testing.T) {
+	const body = `{"follower":{"user_id":1}}`
+	env := newWebhookTestEnv(t)
+
+	code := env.post(env.header(t, "id", time.Now(), body), strings.NewReader(body), int64(len(body)))
+	if code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", code)
+	}
+	if len(env.handled) != 1 || env.handled[0] != body {
+		t.Errorf("handler got %q, want %q", env.handled, body)
+	}
+}
+
+func TestDeduplicateKickWebhook(t *testing.T) {
+	const body = `{}`
+	env := newWebhookTestEnv(t)
+	now := time.Now()
+
+	code := env.post(env.header(t, "message", now, body), strings.NewReader(body), int64(len(body)))
+	if code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", code)
+	}
+
+	// kick retry of the same message with new signature
+	code = env.post(env.header(t, "message", now.Add(time.Second), body), strings.NewReader(body), int64(len(body)))
+	if code != http.StatusOK {
+		t.Errorf("duplicate: got status %d, want 200", code)
+	}
+	if len(env.handled) != 1 {
+		t.Errorf("message is handled %d times, want once", len(env.handled))
+	}
+
+	code = env.post(env.header(t, "other-message", now, body), strings.NewReader(body), int64(len(body)))
+	if code != http.StatusOK {
+		t.Fatalf("other message: got status %d, want 200", code)
+	}
+	if len(env.handled) != 2 {
+		t.Errorf("other message is not handled")
+	}
+}
+
+func TestDeduplicateKickWebhookFailedMessage(t *testing.T) {
+	const body = `{}`
+	env := newWebhookTestEnv(t)
+	env.handlerErr = errors.New("handler failed")
+
+	code := env.post(env.header(t, "message", time.Now(), body), strings.NewReader(body), int64(len(body)))
+	if code != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want 500", code)
+	}
+
+	// retry of failed message is processed
+	env.handlerErr = nil
+	code = env.post(env.header(t, "message", time.Now(), body), strings.NewReader(body), int64(len(body)))
+	if code != http.StatusOK {
+		t.Fatalf("retry: got status %d, want 200", code)
+	}
+	if len(env.handled) != 2 {
+		t.Fatalf("message is handled %d times, want twice", len(env.handled))
+	}
+
+	code = env.post(env.header(t, "message", time.Now(), body), strings.NewReader(body), int64(len(body)))
+	if code != http.StatusOK || len(env.handled) != 2 {
+		t.Errorf("processed retry is handled again, status %d", code)
+	}
+}
+
+func startNATS(t *testing.T) *nats.Conn {
+	t.Helper()
+
+	ns, err := server.NewServer(&server.Options{
+		DontListen: true,
+		JetStream:  true,
+		StoreDir:   t.TempDir(),
+		NoLog:      true,
+		NoSigs:     true,
+	})
+	if err != nil {
+		t.Fatalf("cannot create nats server: %v", err)
+	}
+	ns.Start()
+	t.Cleanup(ns.Shutdown)
+
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server is not ready")
+	}
+
+	mb, err := nats.Connect("", nats.InProcessServer(ns))
+	if err != nil {
+		t.Fatalf("cannot connect to nats server: %v", err)
+	}
+	t.Cleanup(mb.Close)
+
+	return mb
+}
@@ -5,17 +5,21 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/arnokay/arnobot-shared/pkg/assert"
 )
 
 const (
-	EnvMBURL            = "MB_URL"
-	EnvDBDsn            = "DB_DSN"
-	EnvKickWHCallback   = "KICK_WH_CALLBACK"
-	EnvPort             = "PORT"
-	EnvKickClientID     = "KICK_CLIENT_ID"
-	EnvKickClientSecret = "KICK_CLIENT_SECRET"
+	EnvMBURL               = "MB_URL"
+	EnvDBDsn               = "DB_DSN"
+	EnvKickWHCallback      = "KICK_WH_CALLBACK"
+	EnvPort                = "PORT"
+	EnvKickClientID        = "KICK_CLIENT_ID"
+	EnvKickClientSecret    = "KICK_CLIENT_SECRET"
+	EnvKickWHPublicKey     = "KICK_WH_PUBLIC_KEY"
+	EnvKickWHPublicKeyFile = "KICK_WH_PUBLIC_KEY_FILE"
 )
 
 type config struct {
@@ -24,11 +28,46 @@ type config struct {
 	MB       MBConfig
 	DB       DBConfig
 	Webhooks Webhooks
+	Chat     ChatConfig
+}
+
+type RateLimit struct {
+	// Rate is events per second
+	Rate  float64
+	Burst int
+}
+
+type ChatConfig struct {
+	BotLimit     RateLimit
+	ChannelLimit RateLimit
+	// BotLimits overrides BotLimit for bot ids
+	BotLimits map[string]RateLimit
+	// MaxWait is how long outbound message can wait for rate limiter
+	MaxWait time.Duration
+	// MaxLength is kick chat message limit, longer messages are split into
+	// at most MaxParts parts
+	MaxLength int
+	MaxParts  int
+	// ReplyTTL is how long chat messages can be replied to
+	ReplyTTL time.Duration
+	// QueuePartitions, QueueMaxAttempts and QueueMaxAge configure outbound chat stream
+	QueuePartitions  int
+	QueueMaxAttempts int
+	QueueMaxAge      time.Duration
 }
 
 type KickConfig struct {
 	ClientID     string
 	ClientSecret string
+	// APIURL and AuthURL override kick api, empty uses api.kick.com and id.kick.com
+	APIURL  string
+	AuthURL string
+	// MaxClients and ClientIdleTimeout limit cached user clients
+	MaxClients        int
+	ClientIdleTimeout time.Duration
+	// LimiterIdleTimeout is how long chat rate limiters of unused bots and
+	// channels are kept
+	LimiterIdleTimeout time.Duration
 }
 
 type DBConfig struct {
@@ -36,11 +75,16 @@ type DBConfig struct {
 	MaxIdleConns int
 	MaxOpenConns int
 	MaxIdleTime  string
+	// MigrateTimeout is how long startup migrations may run
+	MigrateTimeout time.Duration
 }
 
 type GlobalConfig struct {
 	LogLevel int
 	Port     int
+	// MetricsAddr is address of internal listener serving /debug/vars,
+	// empty disables it. It must not be reachable from the internet.
+	MetricsAddr string
 }
 
 type MBConfig struct {
@@ -49,6 +93,21 @@ type MBConfig struct {
 
 type Webhooks struct {
 	Callback string
+	DedupTTL time.Duration
+	// MaxBodySize is max size of webhook request body in bytes
+	MaxBodySize int64
+	// FreshnessWindow is max allowed clock skew of webhook message timestamp
+	FreshnessWindow time.Duration
+
+	PublicKey              string
+	PublicKeyFile          string
+	PublicKeyFetch         bool
+	PublicKeyRefreshPeriod time.Duration
+
+	// ReconcileInterval is how often subscriptions are reconciled, 0 disables it
+	ReconcileInterval time.Duration
+	// ReconcileGrace is min age of subscription before it is deleted as orphan
+	ReconcileGrace time.Duration
 }
 
 var Config *config
@@ -69,15 +128,88 @@ func Load() *config {
 	flag.StringVar(&Config.MB.URL, "mb-url", os.Getenv(EnvMBURL), "Message Broker URL")
 	flag.IntVar(&Config.Global.LogLevel, "log-level", Config.Global.LogLevel, "Minimal Log Level (default: -4)")
 	flag.StringVar(&Config.Webhooks.Callback, "wh-callback", os.Getenv(EnvKickWHCallback), "kick secret")
+	flag.DurationVar(&Config.Webhooks.DedupTTL, "wh-dedup-ttl", 10*time.Minute, "how long webhook message ids are kept for deduplication")
+	flag.Int64Var(&Config.Webhooks.MaxBodySize, "wh-max-body-size", 1<<20, "max webhook request body size in bytes")
+	flag.StringVar(&Config.Webhooks.PublicKey, "wh-public-key", os.Getenv(EnvKickWHPublicKey), "PEM public key to verify webhooks with (default: kick public key)")
+	flag.StringVar(&Config.Webhooks.PublicKeyFile, "wh-public-key-file", os.Getenv(EnvKickWHPublicKeyFile), "path to PEM public key to verify webhooks with")
+	flag.BoolVar(&Config.Webhooks.PublicKeyFetch, "wh-public-key-fetch", false, "fetch webhook public key from kick api")
+	flag.DurationVar(&Config.Webhooks.PublicKeyRefreshPeriod, "wh-public-key-refresh", time.Hour, "how often webhook public key file is reread or fetched")
+	flag.DurationVar(&Config.Webhooks.ReconcileInterval, "wh-reconcile-interval", 5*time.Minute, "how often webhook subscriptions of enabled bots are reconciled with kick (0 disables it)")
+	flag.DurationVar(&Config.Webhooks.ReconcileGrace, "wh-reconcile-grace", 10*time.Minute, "min age of subscription of channel without enabled bot before it is deleted")
+	flag.DurationVar(&Config.Webhooks.FreshnessWindow, "wh-freshness-window", 5*time.Minute, "max allowed age (and clock skew) of webhook message timestamp")
 	flag.IntVar(&Config.Global.Port, "port", Config.Global.Port, "http port")
+	flag.StringVar(&Config.Global.MetricsAddr, "metrics-addr", "127.0.0.1:9090", "internal address metrics are served on, empty disables them")
 	flag.StringVar(&Config.Kick.ClientID, "client-id", os.Getenv(EnvKickClientID), "kick client id")
 	flag.StringVar(&Config.Kick.ClientSecret, "client-secret", os.Getenv(EnvKickClientSecret), "kick client id")
+	flag.StringVar(&Config.Kick.APIURL, "kick-api-url", "", "kick api base url (default: https://api.kick.com)")
+	flag.StringVar(&Config.Kick.AuthURL, "kick-auth-url", "", "kick auth base url (default: https://id.kick.com)")
+	flag.IntVar(&Config.Kick.MaxClients, "kick-max-clients", 5000, "max number of cached kick user clients, 0 is unlimited")
+	flag.DurationVar(&Config.Kick.ClientIdleTimeout, "kick-client-idle-timeout", 30*time.Minute, "how long unused kick user client is cached, 0 is forever")
+	flag.DurationVar(&Config.Kick.LimiterIdleTimeout, "kick-limiter-idle-timeout", time.Hour, "how long chat rate limiter of unused bot or channel is kept, 0 is forever")
+	flag.Float64Var(&Config.Chat.BotLimit.Rate, "chat-bot-rate", 1, "messages per second one bot can send to all channels, 0 is unlimited")
+	flag.IntVar(&Config.Chat.BotLimit.Burst, "chat-bot-burst", 5, "messages one bot can send at once")
+	flag.Float64Var(&Config.Chat.ChannelLimit.Rate, "chat-channel-rate", 1, "messages per second sent to one channel, 0 is unlimited")
+	flag.IntVar(&Config.Chat.ChannelLimit.Burst, "chat-channel-burst", 3, "messages sent to one channel at once")
+	flag.Func("chat-bot-limits", "per bot limits, e.g. 123=2:10,app=0.5:3 (botID=rate:burst, app limits messages sent as bot)", func(value string) error {
+		limits, err := parseRateLimits(value)
+		Config.Chat.BotLimits = limits
+		return err
+	})
+	flag.DurationVar(&Config.Chat.MaxWait, "chat-max-wait", 10*time.Second, "how long outbound message can wait for rate limiter before it is dropped")
+	flag.IntVar(&Config.Chat.MaxLength, "chat-max-length", 500, "max length of chat message, longer messages are split")
+	flag.IntVar(&Config.Chat.MaxParts, "chat-max-parts", 3, "max number of parts long chat message is split into, 0 is unlimited")
+	flag.DurationVar(&Config.Chat.ReplyTTL, "chat-reply-ttl", 30*time.Minute, "how long chat messages are remembered, replies to older messages are sent without reply")
+	flag.IntVar(&Config.Chat.QueuePartitions, "chat-queue-partitions", 16, "number of outbound chat consumers, messages of one channel are sent in order and retried message holds back other channels of its consumer")
+	flag.IntVar(&Config.Chat.QueueMaxAttempts, "chat-queue-max-attempts", 5, "how many times outbound message is sent before it is dead lettered")
+	flag.DurationVar(&Config.Chat.QueueMaxAge, "chat-queue-max-age", time.Hour, "how long outbound message can wait in the queue")
 	flag.StringVar(&Config.DB.DSN, "db-dsn", os.Getenv(EnvDBDsn), "DB DSN")
 	flag.IntVar(&Config.DB.MaxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.IntVar(&Config.DB.MaxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.StringVar(&Config.DB.MaxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.DurationVar(&Config.DB.MigrateTimeout, "db-migrate-timeout", 10*time.Minute, "how long startup migrations may run, including wait for other replica")
 
 	flag.Parse()
 
+	// message ids has to be remembered for the whole window, otherwise
+	// captured payload can be replayed while its timestamp is still fresh
+	assert.Assert(
+		Config.Webhooks.DedupTTL >= 2*Config.Webhooks.FreshnessWindow,
+		"wh-dedup-ttl has to be at least twice as long as wh-freshness-window",
+	)
+
 	return Config
 }
+
+// parseRateLimits parses comma separated key=rate:burst list
+func parseRateLimits(value string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		key, limit, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid limit %q, expected key=rate:burst", item)
+		}
+		rateValue, burstValue, ok := strings.Cut(limit, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid limit %q, expected key=rate:burst", item)
+		}
+
+		rate, err := strconv.ParseFloat(rateValue, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate of %q: %w", key, err)
+		}
+		burst, err := strconv.Atoi(burstValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid burst of %q: %w", key, err)
+		}
+
+		limits[key] = RateLimit{Rate: rate, Burst: burst}
+	}
+
+	return limits, nil
+}
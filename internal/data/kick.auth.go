@@ -0,0 +1,10 @@
+package data
+
+// AuthProviderReauthRequired marks auth provider as needing re-auth,
+// its tokens were rejected by kick and cannot be refreshed
+type AuthProviderReauthRequired struct {
+	ID             int32  `json:"id"`
+	Provider       string `json:"provider"`
+	ProviderUserID string `json:"providerUserId"`
+	Reason         string `json:"reason"`
+}
@@ -0,0 +1,76 @@
+package data
+
+import (
+	"github.com/scorfly/gokick"
+
+	"github.com/arnokay/arnobot-kick/internal/db"
+)
+
+// ChatSendAs is how chat messages are sent
+type ChatSendAs string
+
+const (
+	// ChatSendAsUser sends messages from bot account with its user token
+	ChatSendAsUser ChatSendAs = "user"
+	// ChatSendAsBot sends messages from our registered app with app token
+	ChatSendAsBot ChatSendAs = "bot"
+)
+
+func (s ChatSendAs) Valid() bool {
+	return s == ChatSendAsUser || s == ChatSendAsBot
+}
+
+type ChatSettings struct {
+	BroadcasterID string     `json:"broadcasterId"`
+	SendAs        ChatSendAs `json:"sendAs"`
+}
+
+type ChatSettingsGet struct {
+	BroadcasterID string `json:"broadcasterId"`
+}
+
+type ChatSettingsUpdate struct {
+	BroadcasterID string     `json:"broadcasterId"`
+	SendAs        ChatSendAs `json:"sendAs"`
+}
+
+func NewChatSettingsFromDB(fromDB db.KickChatSetting) ChatSettings {
+	return ChatSettings{
+		BroadcasterID: fromDB.BroadcasterID,
+		SendAs:        ChatSendAs(fromDB.SendAs),
+	}
+}
+
+// ChatMessageSendFailure is why chat message was not sent
+type ChatMessageSendFailure string
+
+const (
+	ChatMessageSendRateLimited ChatMessageSendFailure = "rate_limited"
+	ChatMessageSendForbidden   ChatMessageSendFailure = "forbidden"
+	ChatMessageSendBotBanned   ChatMessageSendFailure = "bot_banned"
+	ChatMessageSendTooLong     ChatMessageSendFailure = "too_long"
+	ChatMessageSendFailed      ChatMessageSendFailure = "failed"
+)
+
+// ChatMessageSendResult is response to chat message send request
+type ChatMessageSendResult struct {
+	// MessageID is kick id of the first part, follow-up replies thread under it
+	MessageID string `json:"messageId,omitempty"`
+	// MessageIDs are kick ids of all parts of split message
+	MessageIDs []string               `json:"messageIds,omitempty"`
+	Failure    ChatMessageSendFailure `json:"failure,omitempty"`
+}
+
+// ChatMessageEvent is gokick.ChatMessageEvent with replied message,
+// gokick does not decode replies_to
+type ChatMessageEvent struct {
+	gokick.ChatMessageEvent
+
+	RepliesTo *ChatMessageReplyEvent `json:"replies_to"`
+}
+
+type ChatMessageReplyEvent struct {
+	MessageID string           `json:"message_id"`
+	Content   string           `json:"content"`
+	Sender    gokick.UserEvent `json:"sender"`
+}
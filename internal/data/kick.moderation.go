@@ -0,0 +1,62 @@
+package data
+
+import (
+	"time"
+
+	"github.com/scorfly/gokick"
+
+	"github.com/arnokay/arnobot-kick/internal/db"
+)
+
+// ModerationBannedEvent is gokick.ModerationBannedEvent with correct
+// metadata json tag, gokick decodes metadata from "reason" field
+type ModerationBannedEvent struct {
+	Broadcaster gokick.UserEvent `json:"broadcaster"`
+	Moderator   gokick.UserEvent `json:"moderator"`
+	BannedUser  gokick.UserEvent `json:"banned_user"`
+	Metadata    struct {
+		Reason    string  `json:"reason"`
+		CreatedAt string  `json:"created_at"`
+		ExpiresAt *string `json:"expires_at"`
+	} `json:"metadata"`
+}
+
+type ModerationBan struct {
+	BroadcasterID string     `json:"broadcasterId"`
+	UserID        string     `json:"userId"`
+	UserName      string     `json:"userName"`
+	ModeratorID   string     `json:"moderatorId"`
+	ModeratorName string     `json:"moderatorName"`
+	Reason        string     `json:"reason"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	ExpiresAt     *time.Time `json:"expiresAt,omitempty"`
+}
+
+func NewModerationBanFromDB(fromDB db.KickModerationBan) ModerationBan {
+	return ModerationBan{
+		BroadcasterID: fromDB.BroadcasterID,
+		UserID:        fromDB.UserID,
+		UserName:      fromDB.UserName,
+		ModeratorID:   fromDB.ModeratorID,
+		ModeratorName: fromDB.ModeratorName,
+		Reason:        fromDB.Reason,
+		CreatedAt:     fromDB.CreatedAt,
+		ExpiresAt:     fromDB.ExpiresAt,
+	}
+}
+
+type ModerationBanCreate struct {
+	BroadcasterID string
+	UserID        string
+	UserName      string
+	ModeratorID   string
+	ModeratorName string
+	Reason        string
+	CreatedAt     time.Time
+	// ExpiresAt is nil for permanent bans
+	ExpiresAt *time.Time
+}
+
+type ModerationBansGet struct {
+	BroadcasterID string `json:"broadcasterId"`
+}
@@ -0,0 +1,78 @@
+package data
+
+import (
+	"time"
+
+	"github.com/arnokay/arnobot-kick/internal/db"
+)
+
+type StreamSession struct {
+	ID            int32
+	BroadcasterID string
+	Title         string
+	StartedAt     time.Time
+	EndedAt       *time.Time
+}
+
+func NewStreamSessionFromDB(fromDB db.KickStreamSession) StreamSession {
+	return StreamSession{
+		ID:            fromDB.ID,
+		BroadcasterID: fromDB.BroadcasterID,
+		Title:         fromDB.Title,
+		StartedAt:     fromDB.StartedAt,
+		EndedAt:       fromDB.EndedAt,
+	}
+}
+
+type StreamSessionStart struct {
+	BroadcasterID string
+	Title         string
+	StartedAt     time.Time
+}
+
+type StreamSessionEnd struct {
+	BroadcasterID string
+	EndedAt       time.Time
+}
+
+type StreamMetadata struct {
+	Title            string    `json:"title"`
+	Language         string    `json:"language"`
+	CategoryID       string    `json:"categoryId"`
+	CategoryName     string    `json:"categoryName"`
+	HasMatureContent bool      `json:"hasMatureContent"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+func NewStreamMetadataFromDB(fromDB db.KickStreamMetadatum) StreamMetadata {
+	return StreamMetadata{
+		Title:            fromDB.Title,
+		Language:         fromDB.Language,
+		CategoryID:       fromDB.CategoryID,
+		CategoryName:     fromDB.CategoryName,
+		HasMatureContent: fromDB.HasMatureContent,
+		CreatedAt:        fromDB.CreatedAt,
+	}
+}
+
+type StreamMetadataUpdate struct {
+	BroadcasterID    string
+	Title            string
+	Language         string
+	CategoryID       string
+	CategoryName     string
+	HasMatureContent bool
+}
+
+type StreamMetadataChange struct {
+	// Old is nil when it is the first known metadata of the broadcaster
+	Old *StreamMetadata
+	New StreamMetadata
+}
+
+type StreamMetadataHistoryGet struct {
+	BroadcasterID string     `json:"broadcasterId"`
+	From          *time.Time `json:"from,omitempty"`
+	To            *time.Time `json:"to,omitempty"`
+	Limit         int32      `json:"limit,omitempty"`
+}
@@ -0,0 +1,19 @@
+package data
+
+import (
+	"time"
+)
+
+// ParseTime parses timestamps sent by kick, returns zero time if it cannot
+func ParseTime(value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
@@ -0,0 +1,19 @@
+package data
+
+import (
+	"time"
+)
+
+// UserTokenVersion is current version of UserToken stored in KV,
+// entries of other versions are ignored
+const UserTokenVersion = 1
+
+// UserToken is user tokens cached in KV under hm.art.<provider>.<providerUserID>
+type UserToken struct {
+	Version        int       `json:"version"`
+	Provider       string    `json:"provider"`
+	ProviderUserID string    `json:"providerUserID"`
+	AccessToken    string    `json:"accessToken"`
+	RefreshToken   string    `json:"refreshToken"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
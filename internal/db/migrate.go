@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationsLockID is used as postgres advisory lock key so only one
+// replica applies migrations at a time
+const migrationsLockID = 7_351_102
+
+// Migrate applies kick service migrations that were not applied yet.
+// Shared tables (kick.bots, kick.selected_bots, ...) are migrated by arnobot-shared.
+func Migrate(ctx context.Context, pool *pgxpool.Pool) error {
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return fmt.Errorf("cannot list migrations: %w", err)
+	}
+	sort.Strings(names)
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", migrationsLockID)
+	if err != nil {
+		return fmt.Errorf("cannot acquire migrations lock: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS kick.service_migrations (
+    version varchar(255) PRIMARY KEY,
+    applied_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`)
+	if err != nil {
+		return fmt.Errorf("cannot create migrations table: %w", err)
+	}
+
+	for _, name := range names {
+		var applied bool
+		err = tx.QueryRow(
+			ctx,
+			"SELECT EXISTS (SELECT 1 FROM kick.service_migrations WHERE version = $1)",
+			name,
+		).Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("cannot check migration %s: %w", name, err)
+		}
+		if applied {
+			continue
+		}
+
+		b, err := migrations.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("cannot read migration %s: %w", name, err)
+		}
+
+		_, err = tx.Exec(ctx, string(b))
+		if err != nil {
+			return fmt.Errorf("cannot apply migration %s: %w", name, err)
+		}
+
+		_, err = tx.Exec(ctx, "INSERT INTO kick.service_migrations (version) VALUES ($1)", name)
+		if err != nil {
+			return fmt.Errorf("cannot save migration %s: %w", name, err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
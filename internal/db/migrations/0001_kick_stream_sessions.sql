@@ -0,0 +1,13 @@
+CREATE TABLE kick.stream_sessions (
+    id serial PRIMARY KEY,
+    broadcaster_id varchar(100) NOT NULL,
+    title text NOT NULL DEFAULT '',
+    started_at timestamp NOT NULL,
+    ended_at timestamp
+);
+
+CREATE UNIQUE INDEX stream_sessions_active_idx ON kick.stream_sessions (broadcaster_id)
+WHERE
+    ended_at IS NULL;
+
+CREATE INDEX stream_sessions_broadcaster_id_started_at_idx ON kick.stream_sessions (broadcaster_id, started_at);
@@ -0,0 +1,12 @@
+CREATE TABLE kick.stream_metadata (
+    id serial PRIMARY KEY,
+    broadcaster_id varchar(100) NOT NULL,
+    title text NOT NULL DEFAULT '',
+    language varchar(20) NOT NULL DEFAULT '',
+    category_id varchar(100) NOT NULL DEFAULT '',
+    category_name text NOT NULL DEFAULT '',
+    has_mature_content bool NOT NULL DEFAULT FALSE,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX stream_metadata_broadcaster_id_created_at_idx ON kick.stream_metadata (broadcaster_id, created_at);
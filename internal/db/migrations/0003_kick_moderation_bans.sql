@@ -0,0 +1,11 @@
+CREATE TABLE kick.moderation_bans (
+    broadcaster_id varchar(100) NOT NULL,
+    user_id varchar(100) NOT NULL,
+    user_name varchar(100) NOT NULL DEFAULT '',
+    moderator_id varchar(100) NOT NULL DEFAULT '',
+    moderator_name varchar(100) NOT NULL DEFAULT '',
+    reason text NOT NULL DEFAULT '',
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at timestamp,
+    PRIMARY KEY (broadcaster_id, user_id)
+);
@@ -0,0 +1,5 @@
+CREATE TABLE kick.chat_settings (
+    broadcaster_id varchar(100) PRIMARY KEY,
+    send_as varchar(20) NOT NULL DEFAULT 'user',
+    updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,8 @@
+CREATE TABLE kick.webhook_subscriptions (
+    broadcaster_id varchar(100) NOT NULL,
+    event varchar(100) NOT NULL,
+    version integer NOT NULL,
+    subscription_id varchar(100) NOT NULL,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (broadcaster_id, event)
+);
@@ -0,0 +1,12 @@
+-- subscriptions are only visible to and deletable by the token that created
+-- them, all stored subscriptions were created with the broadcaster token
+ALTER TABLE kick.webhook_subscriptions
+    ADD COLUMN owner_id varchar(100);
+
+UPDATE
+    kick.webhook_subscriptions
+SET
+    owner_id = broadcaster_id;
+
+ALTER TABLE kick.webhook_subscriptions
+    ALTER COLUMN owner_id SET NOT NULL;
@@ -0,0 +1,17 @@
+-- name: KickChatSettingsGet :one
+SELECT
+    broadcaster_id, send_as, updated_at
+FROM
+    kick.chat_settings
+WHERE
+    broadcaster_id = $1;
+
+-- name: KickChatSettingsUpsert :one
+INSERT INTO kick.chat_settings (broadcaster_id, send_as, updated_at)
+    VALUES ($1, $2, $3)
+ON CONFLICT (broadcaster_id)
+    DO UPDATE SET
+        send_as = $2,
+        updated_at = $3
+    RETURNING
+        broadcaster_id, send_as, updated_at;
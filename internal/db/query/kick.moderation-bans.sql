@@ -0,0 +1,25 @@
+-- name: KickModerationBanUpsert :one
+INSERT INTO kick.moderation_bans (broadcaster_id, user_id, user_name, moderator_id, moderator_name, reason, created_at, expires_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (broadcaster_id, user_id)
+    DO UPDATE SET
+        user_name = $3,
+        moderator_id = $4,
+        moderator_name = $5,
+        reason = $6,
+        created_at = $7,
+        expires_at = $8
+    RETURNING
+        broadcaster_id, user_id, user_name, moderator_id, moderator_name, reason, created_at, expires_at;
+
+-- name: KickModerationBansGetActive :many
+SELECT
+    broadcaster_id, user_id, user_name, moderator_id, moderator_name, reason, created_at, expires_at
+FROM
+    kick.moderation_bans
+WHERE
+    broadcaster_id = $1
+    AND (expires_at IS NULL
+        OR expires_at > sqlc.arg(now)::timestamp)
+ORDER BY
+    created_at DESC;
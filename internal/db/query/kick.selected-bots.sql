@@ -0,0 +1,7 @@
+-- name: KickSelectedBotsGet :many
+SELECT
+    user_id, broadcaster_id, bot_id, updated_at, enabled
+FROM
+    kick.selected_bots
+ORDER BY
+    broadcaster_id;
@@ -0,0 +1,33 @@
+-- name: KickStreamMetadataCreate :one
+INSERT INTO kick.stream_metadata (broadcaster_id, title, language, category_id, category_name, has_mature_content)
+    VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING
+    id, broadcaster_id, title, language, category_id, category_name, has_mature_content, created_at;
+
+-- name: KickStreamMetadataGetLatest :one
+SELECT
+    id, broadcaster_id, title, language, category_id, category_name, has_mature_content, created_at
+FROM
+    kick.stream_metadata
+WHERE
+    broadcaster_id = $1
+ORDER BY
+    created_at DESC,
+    id DESC
+LIMIT 1;
+
+-- name: KickStreamMetadataHistoryGet :many
+SELECT
+    id, broadcaster_id, title, language, category_id, category_name, has_mature_content, created_at
+FROM
+    kick.stream_metadata
+WHERE
+    broadcaster_id = $1
+    AND (sqlc.narg('from')::timestamp IS NULL
+        OR created_at >= sqlc.narg('from'))
+    AND (sqlc.narg('to')::timestamp IS NULL
+        OR created_at < sqlc.narg('to'))
+ORDER BY
+    created_at DESC,
+    id DESC
+LIMIT sqlc.arg('limit');
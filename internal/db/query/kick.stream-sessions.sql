@@ -0,0 +1,25 @@
+-- name: KickStreamSessionCreate :one
+INSERT INTO kick.stream_sessions (broadcaster_id, title, started_at)
+    VALUES ($1, $2, $3)
+RETURNING
+    id, broadcaster_id, title, started_at, ended_at;
+
+-- name: KickStreamSessionEnd :one
+UPDATE
+    kick.stream_sessions
+SET
+    ended_at = sqlc.arg(ended_at)::timestamp
+WHERE
+    broadcaster_id = $1
+    AND ended_at IS NULL
+RETURNING
+    id, broadcaster_id, title, started_at, ended_at;
+
+-- name: KickStreamSessionGetActive :one
+SELECT
+    id, broadcaster_id, title, started_at, ended_at
+FROM
+    kick.stream_sessions
+WHERE
+    broadcaster_id = $1
+    AND ended_at IS NULL;
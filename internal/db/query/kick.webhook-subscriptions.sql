@@ -0,0 +1,26 @@
+-- name: KickWebhookSubscriptionsGet :many
+SELECT
+    broadcaster_id, event, version, subscription_id, created_at, owner_id
+FROM
+    kick.webhook_subscriptions
+WHERE
+    broadcaster_id = $1
+ORDER BY
+    event;
+
+-- name: KickWebhookSubscriptionUpsert :one
+INSERT INTO kick.webhook_subscriptions (broadcaster_id, event, version, subscription_id, created_at, owner_id)
+    VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (broadcaster_id, event)
+    DO UPDATE SET
+        version = $3,
+        subscription_id = $4,
+        created_at = $5,
+        owner_id = $6
+    RETURNING
+        broadcaster_id, event, version, subscription_id, created_at, owner_id;
+
+-- name: KickWebhookSubscriptionsDelete :exec
+DELETE FROM kick.webhook_subscriptions
+WHERE broadcaster_id = $1
+    AND subscription_id = ANY (sqlc.arg(subscription_ids)::varchar[]);
@@ -0,0 +1,12 @@
+-- Tables of arnobot-shared that kick service queries, they are migrated by
+-- arnobot-shared. This file is only read by sqlc and is never applied.
+CREATE SCHEMA kick;
+
+CREATE TABLE kick.selected_bots (
+    user_id uuid NOT NULL,
+    broadcaster_id varchar(100) NOT NULL,
+    bot_id varchar(100) NOT NULL,
+    updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    enabled boolean NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (user_id)
+);
@@ -0,0 +1,167 @@
+package events
+
+import (
+	"time"
+
+	"github.com/arnokay/arnobot-shared/events"
+
+	"github.com/arnokay/arnobot-kick/internal/data"
+)
+
+type Follow struct {
+	events.EventCommon
+
+	BroadcasterLogin string `json:"broadcasterLogin"`
+	BroadcasterName  string `json:"broadcasterName"`
+
+	FollowerID    string `json:"followerId"`
+	FollowerLogin string `json:"followerLogin"`
+	FollowerName  string `json:"followerName"`
+}
+
+type Subscription struct {
+	events.EventCommon
+
+	BroadcasterLogin string `json:"broadcasterLogin"`
+	BroadcasterName  string `json:"broadcasterName"`
+
+	SubscriberID    string `json:"subscriberId"`
+	SubscriberLogin string `json:"subscriberLogin"`
+	SubscriberName  string `json:"subscriberName"`
+
+	// Renewal is false for a new subscription
+	Renewal   bool      `json:"renewal"`
+	Duration  int       `json:"duration"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type SubscriptionGift struct {
+	events.EventCommon
+
+	BroadcasterLogin string `json:"broadcasterLogin"`
+	BroadcasterName  string `json:"broadcasterName"`
+
+	GifterAnonymous bool   `json:"gifterAnonymous"`
+	GifterID        string `json:"gifterId,omitempty"`
+	GifterLogin     string `json:"gifterLogin,omitempty"`
+	GifterName      string `json:"gifterName,omitempty"`
+
+	Recipients []SubscriptionGiftRecipient `json:"recipients"`
+	CreatedAt  time.Time                   `json:"createdAt"`
+	ExpiresAt  time.Time                   `json:"expiresAt"`
+}
+
+type SubscriptionGiftRecipient struct {
+	RecipientID    string `json:"recipientId"`
+	RecipientLogin string `json:"recipientLogin"`
+	RecipientName  string `json:"recipientName"`
+}
+
+type StreamOnline struct {
+	events.EventCommon
+
+	BroadcasterLogin string `json:"broadcasterLogin"`
+	BroadcasterName  string `json:"broadcasterName"`
+
+	SessionID int32     `json:"sessionId,omitempty"`
+	Title     string    `json:"title"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+type StreamOffline struct {
+	events.EventCommon
+
+	BroadcasterLogin string `json:"broadcasterLogin"`
+	BroadcasterName  string `json:"broadcasterName"`
+
+	SessionID int32     `json:"sessionId,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	EndedAt   time.Time `json:"endedAt"`
+}
+
+type StreamMetadataChange struct {
+	events.EventCommon
+
+	BroadcasterLogin string `json:"broadcasterLogin"`
+	BroadcasterName  string `json:"broadcasterName"`
+
+	// Old is nil when there is no known previous metadata
+	Old *StreamMetadata `json:"old,omitempty"`
+	New StreamMetadata  `json:"new"`
+
+	TitleChanged    bool `json:"titleChanged"`
+	CategoryChanged bool `json:"categoryChanged"`
+	LanguageChanged bool `json:"languageChanged"`
+}
+
+type StreamMetadata struct {
+	Title            string `json:"title"`
+	Language         string `json:"language"`
+	CategoryID       string `json:"categoryId"`
+	CategoryName     string `json:"categoryName"`
+	HasMatureContent bool   `json:"hasMatureContent"`
+}
+
+type ModerationBan struct {
+	events.EventCommon
+
+	BroadcasterLogin string `json:"broadcasterLogin"`
+	BroadcasterName  string `json:"broadcasterName"`
+
+	ModeratorID    string `json:"moderatorId"`
+	ModeratorLogin string `json:"moderatorLogin"`
+	ModeratorName  string `json:"moderatorName"`
+
+	TargetID    string `json:"targetId"`
+	TargetLogin string `json:"targetLogin"`
+	TargetName  string `json:"targetName"`
+
+	Reason string `json:"reason"`
+	// Timeout is true when ban has expiry, ExpiresAt is nil for permanent bans
+	Timeout   bool       `json:"timeout"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// ReauthRequired is sent when bot is disabled because kick rejected tokens of
+// the bot or the broadcaster, user has to log in again with AccountID account
+type ReauthRequired struct {
+	events.EventCommon
+
+	// AccountID is kick user id whose tokens were rejected
+	AccountID string `json:"accountId"`
+	// AccountRole is "bot" or "broadcaster"
+	AccountRole string `json:"accountRole"`
+	Reason      string `json:"reason"`
+}
+
+// Message is shared events.Message with author of the replied message
+type Message struct {
+	events.Message
+
+	ReplyToChatterID    string `json:"replyToChatterId,omitempty"`
+	ReplyToChatterLogin string `json:"replyToChatterLogin,omitempty"`
+	ReplyToChatterName  string `json:"replyToChatterName,omitempty"`
+}
+
+// MessageSend is shared events.MessageSend with kick specific options
+type MessageSend struct {
+	events.MessageSend
+
+	// SendAs overrides how the channel sends messages
+	SendAs data.ChatSendAs `json:"sendAs,omitempty"`
+}
+
+// MessageSent is published after outbound chat message was sent or dropped
+type MessageSent struct {
+	events.EventCommon
+
+	Message    string   `json:"message"`
+	ReplyTo    string   `json:"replyTo,omitempty"`
+	MessageIDs []string `json:"messageIds,omitempty"`
+
+	Success bool                        `json:"success"`
+	Failure data.ChatMessageSendFailure `json:"failure,omitempty"`
+	Error   string                      `json:"error,omitempty"`
+}
@@ -0,0 +1,317 @@
+package kickfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/scorfly/gokick"
+)
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		writeAuthError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	if r.PostForm.Get("client_id") != ClientID || r.PostForm.Get("client_secret") != ClientSecret {
+		writeAuthError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.PostForm.Get("grant_type") {
+	case "client_credentials":
+		s.tokenSeq++
+		token := fmt.Sprintf("app-access-%d", s.tokenSeq)
+		s.appTokens[token] = true
+		writeJSON(w, http.StatusOK, gokick.AppTokenResponse{
+			AccessToken: token,
+			TokenType:   "Bearer",
+			ExpiresIn:   3600,
+		})
+	case "refresh_token":
+		userID, ok := s.refreshToken[r.PostForm.Get("refresh_token")]
+		if !ok {
+			writeAuthError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		// refresh token can be used once, like on kick
+		delete(s.refreshToken, r.PostForm.Get("refresh_token"))
+
+		accessToken, refreshToken := s.issueUserTokens(userID)
+		writeJSON(w, http.StatusOK, gokick.TokenResponse{
+			AccessToken:  accessToken,
+			TokenType:    "Bearer",
+			ExpiresIn:    3600,
+			RefreshToken: refreshToken,
+		})
+	default:
+		writeAuthError(w, http.StatusBadRequest, "unsupported_grant_type")
+	}
+}
+
+func (s *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		writeAuthError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token := r.PostForm.Get("token")
+	if userID, ok := s.userTokens[token]; ok {
+		s.revokeUser(userID)
+	}
+	if userID, ok := s.refreshToken[token]; ok {
+		s.revokeUser(userID)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleChatSend(w http.ResponseWriter, r *http.Request, userID int) {
+	var body struct {
+		BroadcasterUserID int    `json:"broadcaster_user_id"`
+		Content           string `json:"content"`
+		ReplyToMessageID  string `json:"reply_to_message_id"`
+		Type              string `json:"type"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+
+	if body.Content == "" || len([]rune(body.Content)) > 500 {
+		writeError(w, http.StatusBadRequest, "invalid content")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch body.Type {
+	case gokick.MessageTypeUser.String():
+		if userID == 0 || body.BroadcasterUserID == 0 {
+			writeError(w, http.StatusBadRequest, "user message needs user token and broadcaster_user_id")
+			return
+		}
+	case gokick.MessageTypeBot.String():
+		if userID == 0 && body.BroadcasterUserID == 0 {
+			writeError(w, http.StatusBadRequest, "bot message with app token needs broadcaster_user_id")
+			return
+		}
+		if body.BroadcasterUserID == 0 {
+			body.BroadcasterUserID = userID
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "invalid type")
+		return
+	}
+
+	if _, ok := s.channels[body.BroadcasterUserID]; !ok {
+		writeError(w, http.StatusNotFound, "channel not found")
+		return
+	}
+
+	message := ChatMessage{
+		MessageID:         fmt.Sprintf("msg-%d", len(s.messages)+1),
+		BroadcasterUserID: body.BroadcasterUserID,
+		SenderUserID:      userID,
+		Content:           body.Content,
+		ReplyToMessageID:  body.ReplyToMessageID,
+		Type:              body.Type,
+		SentAt:            time.Now(),
+	}
+	s.messages = append(s.messages, message)
+
+	writeData(w, http.StatusOK, gokick.ChatResponse{
+		IsSent:    true,
+		MessageID: message.MessageID,
+	})
+}
+
+func (s *Server) handleSubscriptionsGet(w http.ResponseWriter, r *http.Request, userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subs := make([]gokick.EventResponse, 0)
+	for _, sub := range s.subscriptions {
+		// user token only sees subscriptions created with its own tokens
+		if userID != 0 && sub.CreatedBy != userID {
+			continue
+		}
+		subs = append(subs, sub.EventResponse)
+	}
+
+	writeData(w, http.StatusOK, subs)
+}
+
+func (s *Server) handleSubscriptionsCreate(w http.ResponseWriter, r *http.Request, userID int) {
+	var body struct {
+		Method            string `json:"method"`
+		BroadcasterUserID int    `json:"broadcaster_user_id"`
+		Events            []struct {
+			Name    string `json:"name"`
+			Version int    `json:"version"`
+		} `json:"events"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+
+	broadcasterID := body.BroadcasterUserID
+	if broadcasterID == 0 {
+		broadcasterID = userID
+	}
+	if broadcasterID == 0 {
+		writeError(w, http.StatusBadRequest, "broadcaster_user_id is required with app token")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	result := make([]gokick.CreateSubscriptionResponse, 0, len(body.Events))
+	for _, event := range body.Events {
+		_, err := gokick.NewSubscriptionName(event.Name)
+		if err != nil {
+			result = append(result, gokick.CreateSubscriptionResponse{
+				Name:    event.Name,
+				Version: event.Version,
+				Error:   "unknown event",
+			})
+			continue
+		}
+
+		s.tokenSeq++
+		id := "sub-" + strconv.Itoa(s.tokenSeq)
+		s.subscriptions[id] = Subscription{
+			EventResponse: gokick.EventResponse{
+				AppID:             ClientID,
+				BroadcasterUserID: broadcasterID,
+				CreatedAt:         now,
+				UpdatedAt:         now,
+				Event:             event.Name,
+				ID:                id,
+				Method:            body.Method,
+				Version:           event.Version,
+			},
+			CreatedBy: userID,
+		}
+		result = append(result, gokick.CreateSubscriptionResponse{
+			Name:           event.Name,
+			Version:        event.Version,
+			SubscriptionID: id,
+		})
+	}
+
+	writeData(w, http.StatusOK, result)
+}
+
+func (s *Server) handleSubscriptionsDelete(w http.ResponseWriter, r *http.Request, userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range r.URL.Query()["id"] {
+		sub, ok := s.subscriptions[id]
+		if !ok || (userID != 0 && sub.CreatedBy != userID) {
+			continue
+		}
+		delete(s.subscriptions, id)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleChannelsGet(w http.ResponseWriter, r *http.Request, userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	channels := make([]gokick.ChannelResponse, 0)
+	ids := r.URL.Query()["broadcaster_user_id"]
+	slugs := r.URL.Query()["slug"]
+	if len(ids) == 0 && len(slugs) == 0 && userID != 0 {
+		ids = []string{strconv.Itoa(userID)}
+	}
+	for _, channel := range s.channels {
+		if contains(ids, strconv.Itoa(channel.BroadcasterUserID)) || contains(slugs, channel.Slug) {
+			channels = append(channels, channel)
+		}
+	}
+
+	writeData(w, http.StatusOK, channels)
+}
+
+func (s *Server) handleUsersGet(w http.ResponseWriter, r *http.Request, userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]gokick.UserResponse, 0)
+	ids := r.URL.Query()["id"]
+	if len(ids) == 0 && userID != 0 {
+		ids = []string{strconv.Itoa(userID)}
+	}
+	for _, user := range s.users {
+		if contains(ids, strconv.Itoa(user.UserID)) {
+			users = append(users, user)
+		}
+	}
+
+	writeData(w, http.StatusOK, users)
+}
+
+func (s *Server) handleLivestreamsGet(w http.ResponseWriter, r *http.Request, userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	livestreams := make([]gokick.LivestreamResponse, 0)
+	ids := r.URL.Query()["broadcaster_user_id"]
+	for _, livestream := range s.livestreams {
+		if len(ids) == 0 || contains(ids, strconv.Itoa(livestream.BroadcasterUserID)) {
+			livestreams = append(livestreams, livestream)
+		}
+	}
+
+	writeData(w, http.StatusOK, livestreams)
+}
+
+func (s *Server) handlePublicKey(w http.ResponseWriter, r *http.Request) {
+	writeData(w, http.StatusOK, gokick.PublicKeyResponse{
+		PublicKey: s.PublicKey,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeAuthError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{
+		"error":             code,
+		"error_description": code,
+	})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,362 @@
+// Package kickfake is in-process fake of kick api (api.kick.com and id.kick.com)
+// for tests, point gokick clients to it with config -kick-api-url and
+// -kick-auth-url or KickManagerOptions.
+//
+//	server := kickfake.New()
+//	defer server.Close()
+//	access, refresh := server.AddUser(1, "broadcaster")
+//	server.Fail(http.MethodPost, "/public/v1/chat", http.StatusTooManyRequests, 1)
+package kickfake
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/scorfly/gokick"
+)
+
+const (
+	ClientID     = "kickfake-client-id"
+	ClientSecret = "kickfake-client-secret"
+)
+
+type Server struct {
+	*httptest.Server
+
+	// PublicKey is PEM public key of webhooks signed by WebhookHeader,
+	// it is also served on public key endpoint
+	PublicKey  string
+	privateKey *rsa.PrivateKey
+
+	mu sync.Mutex
+
+	tokenSeq     int
+	appTokens    map[string]bool
+	userTokens   map[string]int
+	refreshToken map[string]int
+
+	users         map[int]gokick.UserResponse
+	channels      map[int]gokick.ChannelResponse
+	livestreams   map[int]gokick.LivestreamResponse
+	subscriptions map[string]Subscription
+	messages      []ChatMessage
+
+	failures []*failure
+	requests []Request
+}
+
+// Subscription is webhook subscription with the owner of the token it was created with
+type Subscription struct {
+	gokick.EventResponse
+
+	// CreatedBy is user id of the token, 0 for app token
+	CreatedBy int
+}
+
+type ChatMessage struct {
+	MessageID         string
+	BroadcasterUserID int
+	SenderUserID      int
+	Content           string
+	ReplyToMessageID  string
+	Type              string
+	SentAt            time.Time
+}
+
+type Request struct {
+	Method string
+	Path   string
+	Status int
+}
+
+type failure struct {
+	method     string
+	path       string
+	status     int
+	times      int
+	retryAfter time.Duration
+}
+
+func New() *Server {
+	privateKey, publicKey := newKeyPair()
+
+	s := &Server{
+		PublicKey:     publicKey,
+		privateKey:    privateKey,
+		appTokens:     make(map[string]bool),
+		userTokens:    make(map[string]int),
+		refreshToken:  make(map[string]int),
+		users:         make(map[int]gokick.UserResponse),
+		channels:      make(map[int]gokick.ChannelResponse),
+		livestreams:   make(map[int]gokick.LivestreamResponse),
+		subscriptions: make(map[string]Subscription),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /oauth/token", s.handleToken)
+	mux.HandleFunc("POST /oauth/revoke", s.handleRevoke)
+	mux.HandleFunc("POST /public/v1/chat", s.authorized(s.handleChatSend))
+	mux.HandleFunc("GET /public/v1/events/subscriptions", s.authorized(s.handleSubscriptionsGet))
+	mux.HandleFunc("POST /public/v1/events/subscriptions", s.authorized(s.handleSubscriptionsCreate))
+	mux.HandleFunc("DELETE /public/v1/events/subscriptions", s.authorized(s.handleSubscriptionsDelete))
+	mux.HandleFunc("GET /public/v1/channels", s.authorized(s.handleChannelsGet))
+	mux.HandleFunc("GET /public/v1/users", s.authorized(s.handleUsersGet))
+	mux.HandleFunc("GET /public/v1/livestreams", s.authorized(s.handleLivestreamsGet))
+	mux.HandleFunc("GET /public/v1/public-key", s.handlePublicKey)
+
+	s.Server = httptest.NewServer(s.record(mux))
+
+	return s
+}
+
+// Options returns gokick client options pointed to the fake server
+func (s *Server) Options() *gokick.ClientOptions {
+	return &gokick.ClientOptions{
+		APIBaseURL:   s.URL,
+		AuthBaseURL:  s.URL,
+		ClientID:     ClientID,
+		ClientSecret: ClientSecret,
+		HTTPClient:   s.Client(),
+	}
+}
+
+// AddUser adds kick user with channel and returns its tokens
+func (s *Server) AddUser(userID int, name string) (accessToken, refreshToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[userID] = gokick.UserResponse{
+		Name:   name,
+		UserID: userID,
+	}
+	s.channels[userID] = gokick.ChannelResponse{
+		BroadcasterUserID: userID,
+		Slug:              name,
+	}
+
+	return s.issueUserTokens(userID)
+}
+
+// SetLive marks channel of the user as live, title empty marks it offline
+func (s *Server) SetLive(userID int, title string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	channel := s.channels[userID]
+	channel.StreamTitle = title
+	channel.Stream.IsLive = title != ""
+	s.channels[userID] = channel
+
+	if title == "" {
+		delete(s.livestreams, userID)
+		return
+	}
+
+	s.livestreams[userID] = gokick.LivestreamResponse{
+		BroadcasterUserID: userID,
+		ChannelID:         userID,
+		Slug:              channel.Slug,
+		StreamTitle:       title,
+		StartedAt:         time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// ExpireAccessToken makes access token invalid, so client has to refresh it
+func (s *Server) ExpireAccessToken(accessToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.userTokens, accessToken)
+	delete(s.appTokens, accessToken)
+}
+
+// RevokeUser invalidates all tokens of the user, like user revoked the app
+func (s *Server) RevokeUser(userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokeUser(userID)
+}
+
+// Fail makes next times requests to method and path fail with status,
+// times < 0 fails until Reset, times 0 does nothing
+func (s *Server) Fail(method, path string, status int, times int) {
+	s.FailWithRetryAfter(method, path, status, times, 0)
+}
+
+// FailWithRetryAfter is Fail that also sets Retry-After header
+func (s *Server) FailWithRetryAfter(method, path string, status int, times int, retryAfter time.Duration) {
+	if times == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, &failure{
+		method:     method,
+		path:       path,
+		status:     status,
+		times:      times,
+		retryAfter: retryAfter,
+	})
+}
+
+// Reset removes scripted failures and recorded requests
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = nil
+	s.requests = nil
+}
+
+func (s *Server) Messages() []ChatMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]ChatMessage(nil), s.messages...)
+}
+
+func (s *Server) Subscriptions() []Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subs := make([]Subscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		subs = append(subs, sub)
+	}
+
+	return subs
+}
+
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) issueUserTokens(userID int) (string, string) {
+	s.tokenSeq++
+	accessToken := fmt.Sprintf("user-access-%d-%d", userID, s.tokenSeq)
+	refreshToken := fmt.Sprintf("user-refresh-%d-%d", userID, s.tokenSeq)
+	s.userTokens[accessToken] = userID
+	s.refreshToken[refreshToken] = userID
+
+	return accessToken, refreshToken
+}
+
+func (s *Server) revokeUser(userID int) {
+	for token, owner := range s.userTokens {
+		if owner == userID {
+			delete(s.userTokens, token)
+		}
+	}
+	for token, owner := range s.refreshToken {
+		if owner == userID {
+			delete(s.refreshToken, token)
+		}
+	}
+}
+
+// record logs requests and applies scripted failures
+func (s *Server) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		if f := s.nextFailure(r); f != nil {
+			if f.retryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(f.retryAfter.Seconds())))
+			}
+			writeError(rec, f.status, http.StatusText(f.status))
+		} else {
+			next.ServeHTTP(rec, r)
+		}
+
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Status: rec.status,
+		})
+		s.mu.Unlock()
+	})
+}
+
+func (s *Server) nextFailure(r *http.Request) *failure {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, f := range s.failures {
+		if f.method != r.Method || f.path != r.URL.Path {
+			continue
+		}
+		if f.times < 0 {
+			return f
+		}
+		f.times--
+		if f.times == 0 {
+			s.failures = append(s.failures[:i], s.failures[i+1:]...)
+		}
+		return f
+	}
+
+	return nil
+}
+
+// authorized passes user id of the token to the handler, 0 for app token
+func (s *Server) authorized(next func(w http.ResponseWriter, r *http.Request, userID int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		if len(token) > len("Bearer ") {
+			token = token[len("Bearer "):]
+		}
+
+		s.mu.Lock()
+		userID, isUser := s.userTokens[token]
+		isApp := s.appTokens[token]
+		s.mu.Unlock()
+
+		if !isUser && !isApp {
+			writeError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		next(w, r, userID)
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func writeData(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "OK",
+		"data":    data,
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": message,
+		"data":    nil,
+	})
+}
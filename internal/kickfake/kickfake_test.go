@@ -0,0 +1,49 @@
+package kickfake
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFail(t *testing.T) {
+	tests := []struct {
+		name  string
+		times int
+		want  []int
+	}{
+		{"zero times does nothing", 0, []int{http.StatusOK, http.StatusOK}},
+		{"fails given times", 2, []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK}},
+		{"negative fails until reset", -1, []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := New()
+			defer server.Close()
+
+			server.Fail(http.MethodGet, "/public/v1/public-key", http.StatusInternalServerError, tt.times)
+
+			for i, want := range tt.want {
+				res, err := server.Client().Get(server.URL + "/public/v1/public-key")
+				if err != nil {
+					t.Fatalf("request %d: %v", i, err)
+				}
+				res.Body.Close()
+
+				if res.StatusCode != want {
+					t.Errorf("request %d: got status %d, want %d", i, res.StatusCode, want)
+				}
+			}
+
+			server.Reset()
+			res, err := server.Client().Get(server.URL + "/public/v1/public-key")
+			if err != nil {
+				t.Fatalf("request after reset: %v", err)
+			}
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("request after reset: got status %d, want %d", res.StatusCode, http.StatusOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package kickfake
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newKeyPair() (*rsa.PrivateKey, string) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		panic(err)
+	}
+
+	return privateKey, string(pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: publicKey,
+	}))
+}
+
+// WebhookHeader returns headers of signed webhook with the server key,
+// server PublicKey has to be used to verify it
+func (s *Server) WebhookHeader(eventType string, body []byte) http.Header {
+	messageID := uuid.NewString()
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+
+	payload := bytes.Join([][]byte{[]byte(messageID), []byte(timestamp), body}, []byte("."))
+	hashed := sha256.Sum256(payload)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		panic(err)
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Kick-Event-Type", eventType)
+	header.Set("Kick-Event-Version", "1")
+	header.Set("Kick-Event-Message-Id", messageID)
+	header.Set("Kick-Event-Message-Timestamp", timestamp)
+	header.Set("Kick-Event-Signature", base64.StdEncoding.EncodeToString(signature))
+
+	return header
+}
@@ -1,24 +1,82 @@
 package controller
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/arnokay/arnobot-shared/apperror"
 	"github.com/arnokay/arnobot-shared/applog"
 	"github.com/arnokay/arnobot-shared/apptype"
-	"github.com/arnokay/arnobot-shared/data"
-	"github.com/arnokay/arnobot-shared/events"
+	sharedData "github.com/arnokay/arnobot-shared/data"
 	"github.com/arnokay/arnobot-shared/pkg/assert"
 	"github.com/arnokay/arnobot-shared/platform"
 	sharedService "github.com/arnokay/arnobot-shared/service"
-	"github.com/arnokay/arnobot-shared/topics"
+	sharedTopics "github.com/arnokay/arnobot-shared/topics"
+	"github.com/arnokay/arnobot-shared/trace"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 
+	"github.com/arnokay/arnobot-kick/internal/data"
+	"github.com/arnokay/arnobot-kick/internal/events"
 	"github.com/arnokay/arnobot-kick/internal/service"
+	"github.com/arnokay/arnobot-kick/internal/topics"
 )
 
+const (
+	chatQueueStream    = "KICK_CHAT_OUTBOUND"
+	chatQueueDLQStream = "KICK_CHAT_OUTBOUND_DLQ"
+	chatQueueConsumer  = "kick-chat-outbound"
+
+	// chatQueueAckWait has to cover one send attempt, including rate limiter wait
+	chatQueueAckWait   = 2 * time.Minute
+	chatQueueDLQMaxAge = 7 * 24 * time.Hour
+
+	chatQueueRetryMin = time.Second
+	chatQueueRetryMax = 15 * time.Second
+
+	// chatSentPartsKeyPrefix is followed by stream sequence of queued message,
+	// it keeps comma separated kick ids of parts sent by failed attempts
+	chatSentPartsKeyPrefix = "chat.sent."
+
+	headerSendError    = "Kick-Send-Error"
+	headerSendAttempts = "Kick-Send-Attempts"
+	// headerSentMessageIDs keeps comma separated kick ids of parts of dead
+	// lettered message that were sent
+	headerSentMessageIDs = "Kick-Sent-Message-Ids"
+	// headerReplyTo keeps reply subject of send request, stream does not store it
+	headerReplyTo = "Kick-Reply-To"
+)
+
+type ChatQueueOptions struct {
+	// Partitions is number of consumers broadcasters are spread across, messages
+	// of one broadcaster are always sent in order by one consumer. Consumer
+	// sends one message at a time, so message that is retried holds back
+	// other broadcasters of its partition, more partitions make it less likely.
+	Partitions int
+	// MaxAttempts is how many times message is sent before it is dead lettered
+	MaxAttempts int
+	// MaxAge is how long message can wait in the queue
+	MaxAge time.Duration
+}
+
 type ChatController struct {
-	kickService *service.KickService
-	authModule  *sharedService.AuthModule
+	kickService    *service.KickService
+	authModule     *sharedService.AuthModule
+	platformModule *service.PlatformModuleOut
+	chatSettings   *service.ChatSettingsService
+	messageCache   *service.ChatMessageCacheService
+	js             jetstream.JetStream
+	// cache keeps ids of sent parts of queued messages
+	cache   jetstream.KeyValue
+	options ChatQueueOptions
+
+	// mb is used to respond to send requests
+	mb         *nats.Conn
+	queueTopic string
 
 	logger applog.Logger
 }
@@ -26,61 +84,502 @@ type ChatController struct {
 func NewChatController(
 	kickService *service.KickService,
 	authModule *sharedService.AuthModule,
+	platformModule *service.PlatformModuleOut,
+	chatSettings *service.ChatSettingsService,
+	messageCache *service.ChatMessageCacheService,
+	js jetstream.JetStream,
+	cache jetstream.KeyValue,
+	options ChatQueueOptions,
 ) *ChatController {
 	logger := applog.NewServiceLogger("mb-chat-controller")
 
+	options.Partitions = max(options.Partitions, 1)
+	options.MaxAttempts = max(options.MaxAttempts, 1)
+
 	return &ChatController{
-		kickService: kickService,
-		authModule:  authModule,
+		kickService:    kickService,
+		authModule:     authModule,
+		platformModule: platformModule,
+		chatSettings:   chatSettings,
+		messageCache:   messageCache,
+		js:             js,
+		cache:          cache,
+		options:        options,
+
+		queueTopic: sharedTopics.
+			TopicBuilder(topics.PlatformChatMessageSendQueue).
+			Platform(platform.Kick).
+			Build(),
 
 		logger: logger,
 	}
 }
 
+// Connect moves published chat messages to the outbound stream and consumes
+// them, one consumer per partition. Messages are acked only after kick
+// accepted them, so they survive kick errors and restarts.
 func (c *ChatController) Connect(conn *nats.Conn) {
-	topic := topics.
-		TopicBuilder(topics.PlatformBroadcasterChatMessageSend).
+	c.mb = conn
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	queueSource := c.queueTopic + "." + sharedTopics.Any
+	dlqTopic := sharedTopics.
+		TopicBuilder(topics.PlatformBroadcasterChatMessageSendDLQ).
 		Platform(platform.Kick).
-		BroadcasterID(topics.Any).
+		BroadcasterID(sharedTopics.Any).
 		Build()
-	_, err := conn.QueueSubscribe(
+
+	_, err := c.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     chatQueueStream,
+		Subjects: []string{queueSource},
+		// messages are stored under queue.<partition>.<broadcasterID>
+		SubjectTransform: &jetstream.SubjectTransformConfig{
+			Source:      queueSource,
+			Destination: fmt.Sprintf("%s.{{partition(%d,1)}}.{{wildcard(1)}}", c.queueTopic, c.options.Partitions),
+		},
+		Retention: jetstream.WorkQueuePolicy,
+		MaxAge:    c.options.MaxAge,
+		Storage:   jetstream.FileStorage,
+	})
+	assert.NoError(err, fmt.Sprintf("MBChatController cannot create stream: %s", chatQueueStream))
+
+	_, err = c.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     chatQueueDLQStream,
+		Subjects: []string{dlqTopic},
+		MaxAge:   chatQueueDLQMaxAge,
+		Storage:  jetstream.FileStorage,
+	})
+	assert.NoError(err, fmt.Sprintf("MBChatController cannot create stream: %s", chatQueueDLQStream))
+
+	for partition := range c.options.Partitions {
+		consumer, err := c.js.CreateOrUpdateConsumer(ctx, chatQueueStream, jetstream.ConsumerConfig{
+			Durable:       fmt.Sprintf("%s-%d", chatQueueConsumer, partition),
+			FilterSubject: fmt.Sprintf("%s.%d.*", c.queueTopic, partition),
+			AckPolicy:     jetstream.AckExplicitPolicy,
+			AckWait:       chatQueueAckWait,
+			// one message in flight keeps messages of a broadcaster in order,
+			// even across replicas. Retry delay of a message holds back the
+			// whole partition, see ChatQueueOptions.Partitions.
+			MaxAckPending: 1,
+			// last delivery only dead letters message which attempt never
+			// finished, e.g. because the pod died while sending it
+			MaxDeliver: c.options.MaxAttempts + 1,
+		})
+		assert.NoError(err, fmt.Sprintf("MBChatController cannot create consumer for partition: %d", partition))
+
+		_, err = consumer.Consume(c.ChatMessageSend)
+		assert.NoError(err, fmt.Sprintf("MBChatController cannot consume partition: %d", partition))
+	}
+
+	topic := sharedTopics.
+		TopicBuilder(sharedTopics.PlatformBroadcasterChatMessageSend).
+		Platform(platform.Kick).
+		BroadcasterID(sharedTopics.Any).
+		Build()
+	_, err = conn.QueueSubscribe(
 		topic,
 		topic,
-		c.ChatMessageSend,
+		c.ChatMessageEnqueue,
 	)
 	assert.NoError(err, fmt.Sprintf("MBChatController cannot subscribe to the topic: %s", topic))
+
+	topic = sharedTopics.TopicBuilder(topics.PlatformChatSettingsGet).Platform(platform.Kick).Build()
+	_, err = conn.QueueSubscribe(topic, topic, c.ChatSettingsGet)
+	assert.NoError(err, "cannot subscribe to: "+topic)
+
+	topic = sharedTopics.TopicBuilder(topics.PlatformChatSettingsUpdate).Platform(platform.Kick).Build()
+	_, err = conn.QueueSubscribe(topic, topic, c.ChatSettingsUpdate)
+	assert.NoError(err, "cannot subscribe to: "+topic)
+}
+
+func (c *ChatController) ChatSettingsGet(msg *nats.Msg) {
+	handleRequest(msg, c.chatSettings.ChatSettingsGet)
+}
+
+func (c *ChatController) ChatSettingsUpdate(msg *nats.Msg) {
+	handleRequest(msg, c.chatSettings.ChatSettingsUpdate)
+}
+
+// ChatMessageEnqueue stores published message in the outbound stream, reply
+// subject of request is kept to respond after message is sent
+func (c *ChatController) ChatMessageEnqueue(msg *nats.Msg) {
+	queueMsg := nats.NewMsg(c.queueTopic + "." + subjectBroadcasterID(msg.Subject))
+	queueMsg.Data = msg.Data
+	if msg.Reply != "" {
+		queueMsg.Header.Set(headerReplyTo, msg.Reply)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := c.js.PublishMsg(ctx, queueMsg)
+	if err != nil {
+		c.logger.ErrorContext(ctx, "cannot enqueue chat message", "err", err, "subject", msg.Subject)
+
+		if msg.Reply != "" {
+			var response apptype.Response[data.ChatMessageSendResult]
+			response.ToFailErr(apperror.New(apperror.CodeInternal, "cannot enqueue chat message", err))
+			response.Data.Failure = data.ChatMessageSendFailed
+			b, _ := response.Encode()
+			msg.Respond(b)
+		}
+	}
 }
 
-func (c *ChatController) ChatMessageSend(msg *nats.Msg) {
+// ChatMessageSend sends queued message, failed attempts are redelivered with
+// backoff. Ids of parts sent by failed attempt are kept in KV under stream
+// sequence of the message, so it keeps its place in the queue and redelivery
+// sends only the rest. Message is dead lettered if it cannot be sent. Sender
+// is notified about the result either way.
+func (c *ChatController) ChatMessageSend(msg jetstream.Msg) {
 	var payload apptype.Request[events.MessageSend]
 
-	payload.Decode(msg.Data)
+	err := payload.Decode(msg.Data())
+	if err != nil {
+		err = apperror.New(apperror.CodeInvalidInput, "cannot decode payload", err)
+		if c.deadLetter(context.Background(), msg, err, 0, nil) {
+			c.respond(msg, payload.TraceID, nil, err)
+		}
+		return
+	}
+
+	// retries can take longer than controller context, every attempt has its own
+	ctx := trace.Context(context.Background(), payload.TraceID)
+
+	metadata, err := msg.Metadata()
+	if err != nil {
+		err = apperror.New(apperror.CodeInternal, "cannot get message metadata", err)
+		if c.deadLetter(ctx, msg, err, 0, nil) {
+			c.respond(msg, payload.TraceID, nil, err)
+		}
+		return
+	}
+
+	// every delivery is an attempt
+	attempt := int(metadata.NumDelivered)
+	seq := metadata.Sequence.Stream
+
+	sentIDs, err := c.sentParts(ctx, seq)
+	if err != nil && attempt <= c.options.MaxAttempts {
+		retry := chatRetryDelay(attempt)
+		c.logger.ErrorContext(ctx, "cannot get sent parts of message, retrying", "err", err, "retryIn", retry)
+		c.nak(ctx, msg, retry)
+		return
+	}
+
+	// message delivered more times than allowed was never acked, most likely
+	// sending it kills the pod, so it is not sent again
+	if attempt > c.options.MaxAttempts {
+		err = apperror.New(apperror.CodeInternal, "message was delivered too many times", nil)
+		if c.deadLetter(ctx, msg, err, attempt-1, sentIDs) {
+			c.forgetSentParts(ctx, seq, sentIDs)
+			c.sent(ctx, msg, payload, sentIDs, err)
+		}
+		return
+	}
 
+	messageIDs, err := c.chatMessageSend(payload, len(sentIDs))
+	messageIDs = append(sentIDs, messageIDs...)
+	if err == nil {
+		err = msg.Ack()
+		if err != nil {
+			c.logger.ErrorContext(ctx, "cannot ack sent message", "err", err)
+		}
+		c.forgetSentParts(ctx, seq, sentIDs)
+		c.sent(ctx, msg, payload, messageIDs, nil)
+		return
+	}
+
+	if isPermanentSendError(err) || attempt >= c.options.MaxAttempts {
+		if c.deadLetter(ctx, msg, err, attempt, messageIDs) {
+			c.forgetSentParts(ctx, seq, sentIDs)
+			c.sent(ctx, msg, payload, messageIDs, err)
+		}
+		return
+	}
+
+	// redelivered message would send parts of this attempt again
+	if len(messageIDs) > len(sentIDs) {
+		c.keepSentParts(ctx, seq, messageIDs)
+	}
+
+	retry := chatRetryDelay(attempt)
+	c.logger.WarnContext(
+		ctx,
+		"cannot send message to channel, retrying",
+		"err", err,
+		"attempt", attempt,
+		"retryIn", retry,
+		"sentParts", len(messageIDs),
+		"broadcasterID", payload.Data.BroadcasterID,
+	)
+
+	c.nak(ctx, msg, retry)
+}
+
+func (c *ChatController) nak(ctx context.Context, msg jetstream.Msg, retry time.Duration) {
+	err := msg.NakWithDelay(retry)
+	if err != nil {
+		c.logger.ErrorContext(ctx, "cannot nak message, it is redelivered after ack wait", "err", err)
+	}
+}
+
+// chatMessageSend sends parts of message that were not sent yet
+func (c *ChatController) chatMessageSend(payload apptype.Request[events.MessageSend], sent int) ([]string, error) {
 	ctx, cancel := newControllerContext(payload.TraceID)
 	defer cancel()
 
-	botProvider, err := c.authModule.AuthProviderGet(ctx, data.AuthProviderGet{
+	botProvider, err := c.authModule.AuthProviderGet(ctx, sharedData.AuthProviderGet{
 		ProviderUserID: &payload.Data.BotID,
 		Provider:       platform.Kick.String(),
 	})
 	if err != nil {
 		c.logger.ErrorContext(ctx, "cant access auth module")
-		return
+		return nil, err
+	}
+
+	sendAs := payload.Data.SendAs
+	if sendAs == "" {
+		settings, err := c.chatSettings.ChatSettingsGet(ctx, data.ChatSettingsGet{
+			BroadcasterID: payload.Data.BroadcasterID,
+		})
+		if err != nil {
+			c.logger.ErrorContext(ctx, "cannot get chat settings, sending as user", "err", err)
+		}
+		sendAs = settings.SendAs
 	}
 
-	err = c.kickService.AppSendChannelMessage(
+	// kick rejects replies to unknown messages, message is sent without reply
+	// instead. It is kept if cache cannot be checked.
+	replyTo := payload.Data.ReplyTo
+	if replyTo != "" {
+		exists, err := c.messageCache.Exists(ctx, payload.Data.BroadcasterID, replyTo)
+		if err == nil && !exists {
+			c.logger.WarnContext(ctx, "reply target is not found, sending without reply", "replyTo", replyTo)
+			replyTo = ""
+		}
+	}
+
+	return c.kickService.AppSendChannelMessageFrom(
 		ctx,
 		*botProvider,
 		payload.Data.BroadcasterID,
 		payload.Data.Message,
-		payload.Data.ReplyTo,
+		replyTo,
+		sendAs,
+		sent,
 	)
+}
+
+// sentParts returns ids of parts sent by earlier attempts of the message
+func (c *ChatController) sentParts(ctx context.Context, seq uint64) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	entry, err := c.cache.Get(ctx, chatSentPartsKey(seq))
+	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if len(entry.Value()) == 0 {
+		return nil, nil
+	}
+
+	return strings.Split(string(entry.Value()), ","), nil
+}
+
+// keepSentParts stores ids of sent parts for the next attempt. If they cannot
+// be stored, the parts are sent again.
+func (c *ChatController) keepSentParts(ctx context.Context, seq uint64, messageIDs []string) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := c.cache.Put(ctx, chatSentPartsKey(seq), []byte(strings.Join(messageIDs, ",")))
 	if err != nil {
-		c.logger.ErrorContext(
-			ctx,
-			"cannot send message to channel",
-			"payload", payload,
-		)
+		c.logger.ErrorContext(ctx, "cannot keep sent parts of message, they are sent again", "err", err, "seq", seq)
+	}
+}
+
+// forgetSentParts removes ids of sent parts of message that left the queue
+func (c *ChatController) forgetSentParts(ctx context.Context, seq uint64, sentIDs []string) {
+	if len(sentIDs) == 0 {
 		return
 	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	err := c.cache.Purge(ctx, chatSentPartsKey(seq))
+	if err != nil {
+		c.logger.WarnContext(ctx, "cannot remove sent parts of message", "err", err, "seq", seq)
+	}
+}
+
+// sent responds to send request and publishes message sent event
+func (c *ChatController) sent(
+	ctx context.Context,
+	msg jetstream.Msg,
+	payload apptype.Request[events.MessageSend],
+	messageIDs []string,
+	err error,
+) {
+	c.respond(msg, payload.TraceID, messageIDs, err)
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	// follow-up messages can reply to sent messages
+	for _, messageID := range messageIDs {
+		c.messageCache.Remember(ctx, payload.Data.BroadcasterID, messageID, payload.Data.BotID)
+	}
+
+	event := events.MessageSent{
+		EventCommon: payload.Data.EventCommon,
+		Message:     payload.Data.Message,
+		ReplyTo:     payload.Data.ReplyTo,
+		MessageIDs:  messageIDs,
+		Success:     err == nil,
+	}
+	if err != nil {
+		event.Failure = chatSendFailure(err)
+		event.Error = err.Error()
+	}
+
+	c.platformModule.ChatMessageSentNotify(ctx, event)
+}
+
+// respond responds to send request, nothing is sent if message was published
+// without reply subject
+func (c *ChatController) respond(msg jetstream.Msg, traceID string, messageIDs []string, err error) {
+	replyTo := msg.Headers().Get(headerReplyTo)
+	if replyTo == "" {
+		return
+	}
+
+	var response apptype.Response[data.ChatMessageSendResult]
+	response.TraceID = traceID
+
+	result := data.ChatMessageSendResult{
+		MessageIDs: messageIDs,
+	}
+	if len(messageIDs) > 0 {
+		result.MessageID = messageIDs[0]
+	}
+
+	if err != nil {
+		response.ToFailErr(err)
+		result.Failure = chatSendFailure(err)
+		response.Data = result
+	} else {
+		response.ToSuccess(result)
+	}
+
+	b, _ := response.Encode()
+	err = c.mb.Publish(replyTo, b)
+	if err != nil {
+		c.logger.Error("cannot respond to chat message send request", "err", err)
+	}
+}
+
+// deadLetter moves message to the dlq subject of its broadcaster with ids of
+// its sent parts. If it cannot be published there, message is left to be
+// redelivered and false is returned.
+func (c *ChatController) deadLetter(
+	ctx context.Context,
+	msg jetstream.Msg,
+	cause error,
+	attempts int,
+	messageIDs []string,
+) bool {
+	broadcasterID := subjectBroadcasterID(msg.Subject())
+
+	dlqTopic := sharedTopics.
+		TopicBuilder(topics.PlatformBroadcasterChatMessageSendDLQ).
+		Platform(platform.Kick).
+		BroadcasterID(broadcasterID).
+		Build()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	dlqMsg := nats.NewMsg(dlqTopic)
+	dlqMsg.Data = msg.Data()
+	dlqMsg.Header.Set(headerSendError, cause.Error())
+	dlqMsg.Header.Set(headerSendAttempts, strconv.Itoa(attempts))
+	if len(messageIDs) > 0 {
+		dlqMsg.Header.Set(headerSentMessageIDs, strings.Join(messageIDs, ","))
+	}
+
+	_, err := c.js.PublishMsg(ctx, dlqMsg)
+	if err != nil {
+		c.logger.ErrorContext(ctx, "cannot dead letter message", "err", err, "broadcasterID", broadcasterID)
+		msg.Nak()
+		return false
+	}
+
+	c.logger.ErrorContext(
+		ctx,
+		"cannot send message to channel, message is dead lettered",
+		"err", cause,
+		"attempts", attempts,
+		"broadcasterID", broadcasterID,
+	)
+
+	msg.Term()
+
+	return true
+}
+
+// isPermanentSendError reports if sending message again cannot succeed
+func isPermanentSendError(err error) bool {
+	var appErr apperror.AppError
+	if !errors.As(err, &appErr) {
+		return false
+	}
+
+	switch appErr.Code {
+	case apperror.CodeInvalidInput, apperror.CodeNotFound, apperror.CodeUnauthorized, apperror.CodeForbidden:
+		return true
+	default:
+		return false
+	}
+}
+
+func chatSendFailure(err error) data.ChatMessageSendFailure {
+	switch {
+	case errors.Is(err, service.ErrRateLimited):
+		return data.ChatMessageSendRateLimited
+	case errors.Is(err, service.ErrBotBanned):
+		return data.ChatMessageSendBotBanned
+	case errors.Is(err, service.ErrChatForbidden):
+		return data.ChatMessageSendForbidden
+	case errors.Is(err, service.ErrMessageTooLong):
+		return data.ChatMessageSendTooLong
+	default:
+		return data.ChatMessageSendFailed
+	}
+}
+
+func chatSentPartsKey(seq uint64) string {
+	return chatSentPartsKeyPrefix + strconv.FormatUint(seq, 10)
+}
+
+// chatRetryDelay doubles retry delay with every attempt
+func chatRetryDelay(attempt int) time.Duration {
+	retry := chatQueueRetryMin
+	for range attempt - 1 {
+		retry = min(retry*2, chatQueueRetryMax)
+	}
+
+	return retry
+}
+
+// subjectBroadcasterID returns the last token of chat subject
+func subjectBroadcasterID(subject string) string {
+	return subject[strings.LastIndex(subject, ".")+1:]
 }
@@ -0,0 +1,509 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arnokay/arnobot-shared/apptype"
+	sharedData "github.com/arnokay/arnobot-shared/data"
+	sharedEvents "github.com/arnokay/arnobot-shared/events"
+	"github.com/arnokay/arnobot-shared/platform"
+	sharedService "github.com/arnokay/arnobot-shared/service"
+	sharedTopics "github.com/arnokay/arnobot-shared/topics"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/arnokay/arnobot-kick/internal/data"
+	"github.com/arnokay/arnobot-kick/internal/events"
+	"github.com/arnokay/arnobot-kick/internal/kickfake"
+	"github.com/arnokay/arnobot-kick/internal/service"
+	"github.com/arnokay/arnobot-kick/internal/topics"
+)
+
+const testReplyTo = "test.chat.reply"
+
+// chatTestEnv is chat controller wired to kickfake and in-process NATS,
+// queued messages are delivered by tests with fakeMsg
+type chatTestEnv struct {
+	kick        *kickfake.Server
+	mb          *nats.Conn
+	js          jetstream.JetStream
+	cache       jetstream.KeyValue
+	kickManager *service.KickManager
+	controller  *ChatController
+	replies     chan *nats.Msg
+}
+
+func newChatTestEnv(t *testing.T, limits service.ChatLimiterOptions, options ChatQueueOptions) *chatTestEnv {
+	t.Helper()
+
+	kick := kickfake.New()
+	t.Cleanup(kick.Close)
+	accessToken, refreshToken := kick.AddUser(1, "bot")
+	kick.AddUser(2, "broadcaster")
+
+	mb := startNATS(t)
+	js, err := jetstream.New(mb)
+	if err != nil {
+		t.Fatalf("cannot create jetstream: %v", err)
+	}
+
+	answerAuthProvider(t, mb, sharedData.AuthProvider{
+		ID:             1,
+		UserID:         uuid.New(),
+		Provider:       platform.Kick.String(),
+		ProviderUserID: "1",
+		AccessToken:    accessToken,
+		RefreshToken:   refreshToken,
+		UpdatedAt:      time.Now(),
+	})
+
+	cache := newKV(t, js, "kick-test")
+	authModule := sharedService.NewAuthModule(mb)
+	kickManager := service.NewKickManager(cache, authModule, kickfake.ClientID, kickfake.ClientSecret, service.KickManagerOptions{
+		APIBaseURL:  kick.URL,
+		AuthBaseURL: kick.URL,
+		HTTPClient:  kick.Client(),
+	})
+	kickService := service.NewKickService(kickManager, service.NewChatLimiterService(limits), service.KickServiceOptions{
+		MaxMessageLength: 500,
+		MaxMessageParts:  3,
+	})
+
+	controller := NewChatController(
+		kickService,
+		authModule,
+		service.NewPlatformModuleOut(mb),
+		// messages are sent with explicit sendAs, settings are not read
+		service.NewChatSettingsService(nil),
+		service.NewChatMessageCacheService(newKV(t, js, "kick-test-messages")),
+		js,
+		cache,
+		options,
+	)
+	controller.mb = mb
+
+	// consumers are not created, tests deliver messages themselves
+	streams := []jetstream.StreamConfig{
+		{Name: chatQueueStream, Subjects: []string{controller.queueTopic + "." + sharedTopics.Any}},
+		{
+			Name: chatQueueDLQStream,
+			Subjects: []string{sharedTopics.
+				TopicBuilder(topics.PlatformBroadcasterChatMessageSendDLQ).
+				Platform(platform.Kick).
+				BroadcasterID(sharedTopics.Any).
+				Build()},
+		},
+	}
+	for _, stream := range streams {
+		_, err = js.CreateStream(context.Background(), stream)
+		if err != nil {
+			t.Fatalf("cannot create stream %s: %v", stream.Name, err)
+		}
+	}
+
+	replies := make(chan *nats.Msg, 10)
+	_, err = mb.ChanSubscribe(testReplyTo, replies)
+	if err != nil {
+		t.Fatalf("cannot subscribe to replies: %v", err)
+	}
+
+	return &chatTestEnv{
+		kick:        kick,
+		mb:          mb,
+		js:          js,
+		cache:       cache,
+		kickManager: kickManager,
+		controller:  controller,
+		replies:     replies,
+	}
+}
+
+// queued returns message of the stream, test fails if there is none
+func (env *chatTestEnv) queued(t *testing.T, stream string) *jetstream.RawStreamMsg {
+	t.Helper()
+
+	s, err := env.js.Stream(context.Background(), stream)
+	if err != nil {
+		t.Fatalf("cannot get stream %s: %v", stream, err)
+	}
+
+	msg, err := s.GetLastMsgForSubject(context.Background(), ">")
+	if err != nil {
+		t.Fatalf("no message in stream %s: %v", stream, err)
+	}
+
+	return msg
+}
+
+func (env *chatTestEnv) streamMessages(t *testing.T, stream string) uint64 {
+	t.Helper()
+
+	s, err := env.js.Stream(context.Background(), stream)
+	if err != nil {
+		t.Fatalf("cannot get stream %s: %v", stream, err)
+	}
+
+	info, err := s.Info(context.Background())
+	if err != nil {
+		t.Fatalf("cannot get stream %s info: %v", stream, err)
+	}
+
+	return info.State.Msgs
+}
+
+// sentParts returns ids of sent parts kept for queued message
+func (env *chatTestEnv) sentParts(t *testing.T, seq uint64) string {
+	t.Helper()
+
+	entry, err := env.cache.Get(context.Background(), chatSentPartsKey(seq))
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return ""
+	}
+	if err != nil {
+		t.Fatalf("cannot get sent parts: %v", err)
+	}
+
+	return string(entry.Value())
+}
+
+func (env *chatTestEnv) reply(t *testing.T) apptype.Response[data.ChatMessageSendResult] {
+	t.Helper()
+
+	select {
+	case msg := <-env.replies:
+		var response apptype.Response[data.ChatMessageSendResult]
+		err := response.Decode(msg.Data)
+		if err != nil {
+			t.Fatalf("cannot decode reply: %v", err)
+		}
+		return response
+	case <-time.After(5 * time.Second):
+		t.Fatal("send request is not replied")
+	}
+
+	return apptype.Response[data.ChatMessageSendResult]{}
+}
+
+func newChatMessage(t *testing.T, message string) *fakeMsg {
+	t.Helper()
+
+	payload := apptype.Request[events.MessageSend]{
+		Data: events.MessageSend{
+			MessageSend: sharedEvents.MessageSend{
+				EventCommon: sharedEvents.EventCommon{
+					Platform:      platform.Kick,
+					BotID:         "1",
+					BroadcasterID: "2",
+				},
+				Message: message,
+			},
+			SendAs: data.ChatSendAsUser,
+		},
+	}
+	b, err := payload.Encode()
+	if err != nil {
+		t.Fatalf("cannot encode payload: %v", err)
+	}
+
+	msg := &fakeMsg{
+		subject:   "chat.message.send-queue.kick.0.2",
+		data:      b,
+		header:    nats.Header{},
+		seq:       1,
+		delivered: 1,
+	}
+	msg.header.Set(headerReplyTo, testReplyTo)
+
+	return msg
+}
+
+func TestChatMessageSendResumesAtUnsentPart(t *testing.T) {
+	env := newChatTestEnv(t, service.ChatLimiterOptions{
+		// first part is sent, the rest is rate limited
+		Bot:     service.ChatLimit{Rate: 0.01, Burst: 1},
+		MaxWait: 10 * time.Millisecond,
+	}, ChatQueueOptions{MaxAttempts: 5})
+
+	message := strings.TrimSpace(strings.Repeat("word ", 250))
+	msg := newChatMessage(t, message)
+
+	env.controller.ChatMessageSend(msg)
+
+	// message keeps its place in the queue
+	if !msg.nakked || msg.acked {
+		t.Fatalf("message with sent part is not nakked: acked %v, nakked %v", msg.acked, msg.nakked)
+	}
+	if msg.nakDelay != chatQueueRetryMin {
+		t.Errorf("got retry delay %s, want %s", msg.nakDelay, chatQueueRetryMin)
+	}
+	if n := env.streamMessages(t, chatQueueStream); n != 0 {
+		t.Errorf("message is queued again")
+	}
+	messages := env.kick.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	if ids := env.sentParts(t, msg.seq); ids != messages[0].MessageID {
+		t.Errorf("message keeps sent ids %q, want %q", ids, messages[0].MessageID)
+	}
+
+	// rest of message is sent when limit is over
+	env.controller.kickService = service.NewKickService(
+		env.kickManager,
+		service.NewChatLimiterService(service.ChatLimiterOptions{}),
+		service.KickServiceOptions{MaxMessageLength: 500, MaxMessageParts: 3},
+	)
+	redelivered := newChatMessage(t, message)
+	redelivered.delivered = 2
+	env.controller.ChatMessageSend(redelivered)
+
+	if !redelivered.acked {
+		t.Fatal("sent message is not acked")
+	}
+	messages = env.kick.Messages()
+	if len(messages) != 3 {
+		t.Fatalf("got %d messages, want 3", len(messages))
+	}
+
+	var parts, ids []string
+	for _, m := range messages {
+		parts = append(parts, m.Content)
+		ids = append(ids, m.MessageID)
+	}
+	if strings.Join(parts, " ") != message {
+		t.Errorf("parts are sent more than once: %q", parts)
+	}
+
+	response := env.reply(t)
+	if strings.Join(response.Data.MessageIDs, ",") != strings.Join(ids, ",") {
+		t.Errorf("got ids %v, want %v", response.Data.MessageIDs, ids)
+	}
+	if ids := env.sentParts(t, msg.seq); ids != "" {
+		t.Errorf("sent message keeps sent ids %q", ids)
+	}
+}
+
+func TestChatMessageSendReply(t *testing.T) {
+	env := newChatTestEnv(t, service.ChatLimiterOptions{}, ChatQueueOptions{MaxAttempts: 5})
+
+	err := env.controller.messageCache.Remember(context.Background(), "2", "chat-message", "3")
+	if err != nil {
+		t.Fatalf("cannot remember chat message: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		replyTo string
+		want    string
+	}{
+		{
+			name:    "known message",
+			replyTo: "chat-message",
+			want:    "chat-message",
+		},
+		{
+			// kick rejects replies to unknown messages
+			name:    "unknown message",
+			replyTo: "unknown-message",
+			want:    "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := len(env.kick.Messages())
+
+			msg := newChatMessage(t, "hello")
+			var payload apptype.Request[events.MessageSend]
+			err := payload.Decode(msg.data)
+			if err != nil {
+				t.Fatalf("cannot decode payload: %v", err)
+			}
+			payload.Data.ReplyTo = tt.replyTo
+			msg.data, _ = payload.Encode()
+
+			env.controller.ChatMessageSend(msg)
+
+			if !msg.acked {
+				t.Fatal("reply is not sent")
+			}
+			env.reply(t)
+			messages := env.kick.Messages()[sent:]
+			if len(messages) != 1 {
+				t.Fatalf("got %d messages, want 1", len(messages))
+			}
+			if messages[0].ReplyToMessageID != tt.want {
+				t.Errorf("got reply_to_message_id %q, want %q", messages[0].ReplyToMessageID, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatMessageSendRetriesWithDelay(t *testing.T) {
+	env := newChatTestEnv(t, service.ChatLimiterOptions{}, ChatQueueOptions{MaxAttempts: 2})
+
+	env.kick.Fail(http.MethodPost, "/public/v1/chat", http.StatusInternalServerError, -1)
+
+	msg := newChatMessage(t, "hello")
+	env.controller.ChatMessageSend(msg)
+
+	if !msg.nakked || msg.acked || msg.termed {
+		t.Fatalf("failed message is not nakked: %+v", msg)
+	}
+	if msg.nakDelay != chatQueueRetryMin {
+		t.Errorf("got retry delay %s, want %s", msg.nakDelay, chatQueueRetryMin)
+	}
+	if ids := env.sentParts(t, msg.seq); ids != "" {
+		t.Errorf("message without sent parts keeps sent ids %q", ids)
+	}
+
+	// last attempt dead letters message
+	msg = newChatMessage(t, "hello")
+	msg.delivered = 2
+	env.controller.ChatMessageSend(msg)
+
+	if !msg.termed || msg.nakked {
+		t.Fatalf("message is not dead lettered after last attempt: %+v", msg)
+	}
+	dead := env.queued(t, chatQueueDLQStream)
+	if got := dead.Header.Get(headerSendAttempts); got != "2" {
+		t.Errorf("dead lettered message has attempts %q, want 2", got)
+	}
+	if response := env.reply(t); response.Data.Failure != data.ChatMessageSendFailed {
+		t.Errorf("got failure %q, want %q", response.Data.Failure, data.ChatMessageSendFailed)
+	}
+
+	// message which last attempt never finished is not sent again
+	env.kick.Reset()
+	msg = newChatMessage(t, "hello")
+	msg.delivered = 3
+	env.controller.ChatMessageSend(msg)
+
+	if !msg.termed {
+		t.Fatal("message delivered too many times is not dead lettered")
+	}
+	if len(env.kick.Requests()) != 0 {
+		t.Errorf("message delivered too many times is sent")
+	}
+}
+
+func TestChatRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, chatQueueRetryMin},
+		{2, 2 * chatQueueRetryMin},
+		{3, 4 * chatQueueRetryMin},
+		{100, chatQueueRetryMax},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.attempt), func(t *testing.T) {
+			if got := chatRetryDelay(tt.attempt); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeMsg is queued message delivered to the controller, it records how it
+// was acknowledged
+type fakeMsg struct {
+	subject   string
+	data      []byte
+	header    nats.Header
+	seq       uint64
+	delivered uint64
+
+	acked    bool
+	nakked   bool
+	nakDelay time.Duration
+	termed   bool
+}
+
+func (m *fakeMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{
+		Sequence:     jetstream.SequencePair{Stream: m.seq},
+		NumDelivered: m.delivered,
+	}, nil
+}
+
+func (m *fakeMsg) Data() []byte                        { return m.data }
+func (m *fakeMsg) Headers() nats.Header                { return m.header }
+func (m *fakeMsg) Subject() string                     { return m.subject }
+func (m *fakeMsg) Reply() string                       { return "" }
+func (m *fakeMsg) Ack() error                          { m.acked = true; return nil }
+func (m *fakeMsg) DoubleAck(ctx context.Context) error { m.acked = true; return nil }
+func (m *fakeMsg) Nak() error                          { m.nakked = true; return nil }
+func (m *fakeMsg) InProgress() error                   { return nil }
+func (m *fakeMsg) Term() error                         { m.termed = true; return nil }
+func (m *fakeMsg) TermWithReason(reason string) error  { m.termed = true; return nil }
+func (m *fakeMsg) NakWithDelay(delay time.Duration) error {
+	m.nakked = true
+	m.nakDelay = delay
+	return nil
+}
+
+// answerAuthProvider answers auth module requests with the provider
+func answerAuthProvider(t *testing.T, mb *nats.Conn, provider sharedData.AuthProvider) {
+	t.Helper()
+
+	_, err := mb.Subscribe(sharedTopics.AuthProviderTokenGet, func(msg *nats.Msg) {
+		var res apptype.Response[*sharedData.AuthProvider]
+		res.ToSuccess(&provider)
+		b, _ := res.Encode()
+		msg.Respond(b)
+	})
+	if err != nil {
+		t.Fatalf("cannot subscribe to %s: %v", sharedTopics.AuthProviderTokenGet, err)
+	}
+}
+
+func startNATS(t *testing.T) *nats.Conn {
+	t.Helper()
+
+	ns, err := server.NewServer(&server.Options{
+		DontListen: true,
+		JetStream:  true,
+		StoreDir:   t.TempDir(),
+		NoLog:      true,
+		NoSigs:     true,
+	})
+	if err != nil {
+		t.Fatalf("cannot create nats server: %v", err)
+	}
+	ns.Start()
+	t.Cleanup(ns.Shutdown)
+
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server is not ready")
+	}
+
+	mb, err := nats.Connect("", nats.InProcessServer(ns))
+	if err != nil {
+		t.Fatalf("cannot connect to nats server: %v", err)
+	}
+	t.Cleanup(mb.Close)
+
+	return mb
+}
+
+func newKV(t *testing.T, js jetstream.JetStream, bucket string) jetstream.KeyValue {
+	t.Helper()
+
+	kv, err := js.CreateKeyValue(context.Background(), jetstream.KeyValueConfig{Bucket: bucket})
+	if err != nil {
+		t.Fatalf("cannot create kv: %v", err)
+	}
+
+	return kv
+}
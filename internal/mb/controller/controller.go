@@ -12,13 +12,17 @@ import (
 )
 
 type Controllers struct {
-	ChatController controllers.NatsController
-	BotController  controllers.NatsController
+	ChatController       controllers.NatsController
+	BotController        controllers.NatsController
+	StreamController     controllers.NatsController
+	ModerationController controllers.NatsController
 }
 
 func (c *Controllers) Connect(conn *nats.Conn) {
 	c.ChatController.Connect(conn)
 	c.BotController.Connect(conn)
+	c.StreamController.Connect(conn)
+	c.ModerationController.Connect(conn)
 }
 
 func newControllerContext(traceID string) (context.Context, context.CancelFunc) {
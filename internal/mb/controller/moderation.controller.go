@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"github.com/arnokay/arnobot-shared/applog"
+	"github.com/arnokay/arnobot-shared/pkg/assert"
+	"github.com/arnokay/arnobot-shared/platform"
+	sharedTopics "github.com/arnokay/arnobot-shared/topics"
+	"github.com/nats-io/nats.go"
+
+	"github.com/arnokay/arnobot-kick/internal/service"
+	"github.com/arnokay/arnobot-kick/internal/topics"
+)
+
+type ModerationController struct {
+	moderationService *service.ModerationService
+
+	logger applog.Logger
+}
+
+func NewModerationController(
+	moderationService *service.ModerationService,
+) *ModerationController {
+	logger := applog.NewServiceLogger("mb-moderation-controller")
+
+	return &ModerationController{
+		moderationService: moderationService,
+
+		logger: logger,
+	}
+}
+
+func (c *ModerationController) Connect(conn *nats.Conn) {
+	topic := sharedTopics.TopicBuilder(topics.PlatformModerationBansGet).Platform(platform.Kick).Build()
+	_, err := conn.QueueSubscribe(topic, topic, c.ModerationBansGet)
+	assert.NoError(err, "cannot subscribe to: "+topic)
+}
+
+func (c *ModerationController) ModerationBansGet(msg *nats.Msg) {
+	handleRequest(msg, c.moderationService.ModerationBansGet)
+}
@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"github.com/arnokay/arnobot-shared/applog"
+	"github.com/arnokay/arnobot-shared/pkg/assert"
+	"github.com/arnokay/arnobot-shared/platform"
+	sharedTopics "github.com/arnokay/arnobot-shared/topics"
+	"github.com/nats-io/nats.go"
+
+	"github.com/arnokay/arnobot-kick/internal/service"
+	"github.com/arnokay/arnobot-kick/internal/topics"
+)
+
+type StreamController struct {
+	streamService *service.StreamService
+
+	logger applog.Logger
+}
+
+func NewStreamController(
+	streamService *service.StreamService,
+) *StreamController {
+	logger := applog.NewServiceLogger("mb-stream-controller")
+
+	return &StreamController{
+		streamService: streamService,
+
+		logger: logger,
+	}
+}
+
+func (c *StreamController) Connect(conn *nats.Conn) {
+	topic := sharedTopics.TopicBuilder(topics.PlatformStreamMetadataHistoryGet).Platform(platform.Kick).Build()
+	_, err := conn.QueueSubscribe(topic, topic, c.StreamMetadataHistoryGet)
+	assert.NoError(err, "cannot subscribe to: "+topic)
+}
+
+func (c *StreamController) StreamMetadataHistoryGet(msg *nats.Msg) {
+	handleRequest(msg, c.streamService.StreamMetadataHistoryGet)
+}
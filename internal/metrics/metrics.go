@@ -0,0 +1,90 @@
+// Package metrics keeps service counters and gauges in expvar,
+// they are served as json on /debug/vars of internal metrics listener.
+package metrics
+
+import (
+	"expvar"
+)
+
+// KickClients describes gokick user clients cache of KickManager
+var KickClients = expvar.NewMap("kick_clients")
+
+const (
+	// KickClientsSize is current number of cached clients
+	KickClientsSize = "size"
+	// KickClientsMax is configured max number of cached clients, 0 is unlimited
+	KickClientsMax         = "max"
+	KickClientsCreated     = "created"
+	KickClientsEvictedIdle = "evicted_idle"
+	KickClientsEvictedLRU  = "evicted_lru"
+	KickClientsInvalidated = "invalidated"
+)
+
+// ChatLimiter describes outbound chat rate limiter
+var ChatLimiter = expvar.NewMap("chat_limiter")
+
+const (
+	// ChatLimiterWaited is number of messages that waited for their turn
+	ChatLimiterWaited = "waited"
+	// ChatLimiterRejected is number of messages dropped after max wait
+	ChatLimiterRejected = "rejected"
+	// ChatLimiterPaused is number of times kick rate limited a bot
+	ChatLimiterPaused = "paused"
+)
+
+// WebhookReconciler describes background reconciler of webhook subscriptions
+var WebhookReconciler = expvar.NewMap("webhook_reconciler")
+
+const (
+	// WebhookReconcilerLeader is 1 while the replica holds reconciler lease
+	WebhookReconcilerLeader = "leader"
+	WebhookReconcilerRuns   = "runs"
+	// WebhookReconcilerMissing is number of subscriptions enabled bots were missing in the last pass
+	WebhookReconcilerMissing = "missing"
+	// WebhookReconcilerOrphaned is number of subscriptions of channels without enabled bot in the last pass
+	WebhookReconcilerOrphaned = "orphaned"
+	// WebhookReconcilerResubscribed is number of channels subscribed again
+	WebhookReconcilerResubscribed = "resubscribed"
+	WebhookReconcilerDeleted      = "deleted"
+	WebhookReconcilerFailed       = "failed"
+)
+
+func init() {
+	for _, key := range []string{
+		KickClientsSize,
+		KickClientsMax,
+		KickClientsCreated,
+		KickClientsEvictedIdle,
+		KickClientsEvictedLRU,
+		KickClientsInvalidated,
+	} {
+		KickClients.Add(key, 0)
+	}
+
+	for _, key := range []string{
+		ChatLimiterWaited,
+		ChatLimiterRejected,
+		ChatLimiterPaused,
+	} {
+		ChatLimiter.Add(key, 0)
+	}
+
+	for _, key := range []string{
+		WebhookReconcilerLeader,
+		WebhookReconcilerRuns,
+		WebhookReconcilerMissing,
+		WebhookReconcilerOrphaned,
+		WebhookReconcilerResubscribed,
+		WebhookReconcilerDeleted,
+		WebhookReconcilerFailed,
+	} {
+		WebhookReconciler.Add(key, 0)
+	}
+}
+
+// SetInt sets gauge key of m to v
+func SetInt(m *expvar.Map, key string, v int64) {
+	i := new(expvar.Int)
+	i.Set(v)
+	m.Set(key, i)
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"context"
+
+	"github.com/arnokay/arnobot-shared/applog"
+	sharedService "github.com/arnokay/arnobot-shared/service"
+	"github.com/nats-io/nats.go"
+
+	"github.com/arnokay/arnobot-kick/internal/data"
+	"github.com/arnokay/arnobot-kick/internal/topics"
+)
+
+// AuthModule extends shared AuthModule with auth requests
+// that are not (yet) part of the shared module
+type AuthModule struct {
+	*sharedService.AuthModule
+
+	mb     *nats.Conn
+	logger applog.Logger
+}
+
+func NewAuthModule(mb *nats.Conn) *AuthModule {
+	logger := applog.NewServiceLogger("kick-auth-module")
+
+	return &AuthModule{
+		AuthModule: sharedService.NewAuthModule(mb),
+		mb:         mb,
+		logger:     logger,
+	}
+}
+
+func (s *AuthModule) AuthProviderReauthRequired(ctx context.Context, arg data.AuthProviderReauthRequired) error {
+	return sharedService.HandlePublish(ctx, s.mb, s.logger, topics.AuthProviderTokenReauthRequired, arg)
+}
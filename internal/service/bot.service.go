@@ -2,23 +2,33 @@ package service
 
 import (
 	"context"
+	"time"
 
 	"github.com/arnokay/arnobot-shared/apperror"
 	"github.com/arnokay/arnobot-shared/applog"
 	"github.com/arnokay/arnobot-shared/data"
 	"github.com/arnokay/arnobot-shared/db"
+	sharedEvents "github.com/arnokay/arnobot-shared/events"
 	"github.com/arnokay/arnobot-shared/platform"
 	sharedService "github.com/arnokay/arnobot-shared/service"
-	"github.com/arnokay/arnobot-shared/storage"
 	"github.com/google/uuid"
+
+	kickData "github.com/arnokay/arnobot-kick/internal/data"
+	"github.com/arnokay/arnobot-kick/internal/events"
+	"github.com/arnokay/arnobot-kick/internal/storage"
 )
 
+// reauthChannelTimeout bounds disabling of one channel when tokens of its bot
+// or broadcaster are rejected
+const reauthChannelTimeout = 30 * time.Second
+
 type BotService struct {
-	storage     storage.Storager
-	txService   sharedService.ITransactionService
-	authModule  *sharedService.AuthModule
-	whService   *WebhookService
-	kickService *KickService
+	storage        storage.Storager
+	txService      sharedService.ITransactionService
+	authModule     *AuthModule
+	whService      *WebhookService
+	kickService    *KickService
+	platformModule *PlatformModuleOut
 
 	logger applog.Logger
 }
@@ -26,18 +36,20 @@ type BotService struct {
 func NewBotService(
 	store storage.Storager,
 	txService sharedService.ITransactionService,
-	authModule *sharedService.AuthModule,
+	authModule *AuthModule,
 	whService *WebhookService,
 	kickService *KickService,
+	platformModule *PlatformModuleOut,
 ) *BotService {
 	logger := applog.NewServiceLogger("bot-service")
 	return &BotService{
-		storage:     store,
-		txService:   txService,
-		authModule:  authModule,
-		whService:   whService,
-		kickService: kickService,
-		logger:      logger,
+		storage:        store,
+		txService:      txService,
+		authModule:     authModule,
+		whService:      whService,
+		kickService:    kickService,
+		platformModule: platformModule,
+		logger:         logger,
 	}
 }
 
@@ -92,7 +104,7 @@ func (s *BotService) StartBot(ctx context.Context, arg data.PlatformBotToggle) e
 		return err
 	}
 
-	s.kickService.AppSendChannelMessage(ctx, *botProvider, selectedBot.BroadcasterID, "hi!", "")
+	s.kickService.AppSendChannelMessage(ctx, *botProvider, selectedBot.BroadcasterID, "hi!", "", "")
 
 	return nil
 }
@@ -103,15 +115,7 @@ func (s *BotService) StopBot(ctx context.Context, arg data.PlatformBotToggle) er
 		return err
 	}
 
-	botProvider, err := s.authModule.AuthProviderGet(ctx, data.AuthProviderGet{
-		ProviderUserID: &selectedBot.BotID,
-		Provider:       platform.Kick.String(),
-	})
-	if err != nil {
-		s.logger.ErrorContext(ctx, "cannot get bot provider")
-		return err
-	}
-	err = s.whService.UnsubscribeAll(ctx, *botProvider, selectedBot.BroadcasterID)
+	err = s.whService.UnsubscribeAll(ctx, selectedBot.BroadcasterID)
 	if err != nil {
 		s.logger.DebugContext(ctx, "bot cannot unsubscribe")
 		return err
@@ -125,6 +129,104 @@ func (s *BotService) StopBot(ctx context.Context, arg data.PlatformBotToggle) er
 	return nil
 }
 
+// HandleReauthRequired marks the provider whose tokens were rejected by kick
+// as needing reauth in auth module and disables selected bots that depend on
+// it: the bot of its channel and bots of channels where it is the bot.
+// Provider can be bot of many channels, so ctx should have no deadline, every
+// channel gets its own timeout.
+func (s *BotService) HandleReauthRequired(ctx context.Context, provider data.AuthProvider, reason string) {
+	var selectedBots []data.PlatformSelectedBot
+
+	authCtx, cancel := context.WithTimeout(ctx, reauthChannelTimeout)
+	err := s.authModule.AuthProviderReauthRequired(authCtx, kickData.AuthProviderReauthRequired{
+		ID:             provider.ID,
+		Provider:       provider.Provider,
+		ProviderUserID: provider.ProviderUserID,
+		Reason:         reason,
+	})
+	cancel()
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot mark provider as reauth required", "err", err, "providerUserID", provider.ProviderUserID)
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, reauthChannelTimeout)
+	selectedBot, err := s.SelectedBotGetByBroadcasterID(lookupCtx, provider.ProviderUserID)
+	if err == nil {
+		selectedBots = append(selectedBots, selectedBot)
+	}
+
+	bots, err := s.BotsGet(lookupCtx, data.PlatformBotsGet{
+		BotID: &provider.ProviderUserID,
+	})
+	cancel()
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot get bots of provider", "err", err, "providerUserID", provider.ProviderUserID)
+	}
+	for _, bot := range bots {
+		if bot.BroadcasterID == provider.ProviderUserID {
+			continue
+		}
+		lookupCtx, cancel := context.WithTimeout(ctx, reauthChannelTimeout)
+		selectedBot, err := s.SelectedBotGetByBroadcasterID(lookupCtx, bot.BroadcasterID)
+		cancel()
+		if err != nil || selectedBot.BotID != bot.BotID {
+			continue
+		}
+		selectedBots = append(selectedBots, selectedBot)
+	}
+
+	for _, selectedBot := range selectedBots {
+		if !selectedBot.Enabled {
+			continue
+		}
+		channelCtx, cancel := context.WithTimeout(ctx, reauthChannelTimeout)
+		s.disableBot(channelCtx, selectedBot, provider.ProviderUserID, reason)
+		cancel()
+	}
+}
+
+// disableBot disables selected bot because tokens of accountID were rejected.
+// Webhooks are unsubscribed only when the broadcaster account was rejected,
+// its subscriptions cannot be managed anymore. When only the bot was rejected
+// subscriptions of the broadcaster are kept, so the bot can be enabled again,
+// reconciler deletes them if it is not.
+func (s *BotService) disableBot(ctx context.Context, selectedBot data.PlatformSelectedBot, accountID string, reason string) {
+	role := "bot"
+	if accountID == selectedBot.BroadcasterID {
+		role = "broadcaster"
+	}
+
+	if role == "broadcaster" {
+		err := s.whService.UnsubscribeAll(ctx, selectedBot.BroadcasterID)
+		if err != nil {
+			s.logger.WarnContext(ctx, "cannot unsubscribe webhooks of disabled bot", "err", err, "broadcasterID", selectedBot.BroadcasterID)
+		}
+	}
+
+	err := s.SelectedBotChangeStatus(ctx, selectedBot.UserID, false)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot disable bot", "err", err, "broadcasterID", selectedBot.BroadcasterID)
+		return
+	}
+
+	s.logger.InfoContext(ctx, "bot disabled, reauth is required", "broadcasterID", selectedBot.BroadcasterID, "accountID", accountID, "role", role)
+
+	err = s.platformModule.ReauthRequiredNotify(ctx, events.ReauthRequired{
+		EventCommon: sharedEvents.EventCommon{
+			UserID:        selectedBot.UserID,
+			Platform:      platform.Kick,
+			BotID:         selectedBot.BotID,
+			BroadcasterID: selectedBot.BroadcasterID,
+		},
+		AccountID:   accountID,
+		AccountRole: role,
+		Reason:      reason,
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot notify reauth required", "err", err, "broadcasterID", selectedBot.BroadcasterID)
+	}
+}
+
 func (s *BotService) SelectedBotSetDefault(ctx context.Context, userID uuid.UUID) (data.PlatformSelectedBot, error) {
 	var bot data.PlatformBot
 
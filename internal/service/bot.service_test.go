@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/arnokay/arnobot-shared/apptype"
+	sharedData "github.com/arnokay/arnobot-shared/data"
+	"github.com/arnokay/arnobot-shared/platform"
+	"github.com/nats-io/nats.go"
+
+	"github.com/arnokay/arnobot-kick/internal/data"
+	"github.com/arnokay/arnobot-kick/internal/topics"
+)
+
+func TestBotServiceStartStopBot(t *testing.T) {
+	env := newTestEnv(t)
+	env.addUser(1, "bot")
+	broadcaster := env.addUser(2, "broadcaster")
+	env.store.defaultBotID = "1"
+
+	toggle := sharedData.PlatformBotToggle{
+		Platform: platform.Kick,
+		UserID:   broadcaster.UserID,
+	}
+
+	err := env.botService.StartBot(context.Background(), toggle)
+	if err != nil {
+		t.Fatalf("cannot start bot: %v", err)
+	}
+
+	selectedBot, ok := env.store.selectedBot(broadcaster.UserID)
+	if !ok || !selectedBot.Enabled || selectedBot.BotID != "1" || selectedBot.BroadcasterID != "2" {
+		t.Fatalf("default bot is not enabled in the channel: %+v", selectedBot)
+	}
+	if len(env.kick.Subscriptions()) != len(webhookSubscriptions) {
+		t.Errorf("got %d subscriptions, want %d", len(env.kick.Subscriptions()), len(webhookSubscriptions))
+	}
+
+	messages := env.kick.Messages()
+	if len(messages) != 1 || messages[0].SenderUserID != 1 || messages[0].BroadcasterUserID != 2 {
+		t.Errorf("bot did not greet the channel: %+v", messages)
+	}
+
+	err = env.botService.StopBot(context.Background(), toggle)
+	if err != nil {
+		t.Fatalf("cannot stop bot: %v", err)
+	}
+
+	selectedBot, _ = env.store.selectedBot(broadcaster.UserID)
+	if selectedBot.Enabled {
+		t.Errorf("bot is still enabled")
+	}
+	if len(env.kick.Subscriptions()) != 0 {
+		t.Errorf("got %d subscriptions after stop, want 0", len(env.kick.Subscriptions()))
+	}
+	if len(env.store.storedSubscriptions("2")) != 0 {
+		t.Errorf("subscriptions are still stored after stop")
+	}
+
+	// bot can be started again
+	err = env.botService.StartBot(context.Background(), toggle)
+	if err != nil {
+		t.Fatalf("cannot start bot again: %v", err)
+	}
+	if len(env.kick.Subscriptions()) != len(webhookSubscriptions) {
+		t.Errorf("got %d subscriptions, want %d", len(env.kick.Subscriptions()), len(webhookSubscriptions))
+	}
+}
+
+func TestBotServiceDisablesBotsOfRevokedBot(t *testing.T) {
+	env := newTestEnv(t)
+	bot := env.addUser(1, "bot")
+	env.store.defaultBotID = "1"
+
+	notified := make(chan *nats.Msg, 10)
+	_, err := env.mb.ChanSubscribe("bot.reauth-required.notify.>", notified)
+	if err != nil {
+		t.Fatalf("cannot subscribe to reauth notifications: %v", err)
+	}
+	marked := make(chan *nats.Msg, 10)
+	_, err = env.mb.ChanSubscribe(topics.AuthProviderTokenReauthRequired, marked)
+	if err != nil {
+		t.Fatalf("cannot subscribe to auth reauth requests: %v", err)
+	}
+
+	var broadcasters []sharedData.AuthProvider
+	for _, id := range []int{2, 3} {
+		broadcaster := env.addUser(id, "broadcaster")
+		err := env.botService.StartBot(context.Background(), sharedData.PlatformBotToggle{
+			Platform: platform.Kick,
+			UserID:   broadcaster.UserID,
+		})
+		if err != nil {
+			t.Fatalf("cannot start bot: %v", err)
+		}
+		broadcasters = append(broadcasters, broadcaster)
+	}
+	subs := env.kick.Subscriptions()
+
+	env.kick.RevokeUser(1)
+	_, err = env.kickService.AppSendChannelMessage(context.Background(), bot, "2", "hello", "", data.ChatSendAsUser)
+	if err == nil {
+		t.Fatal("message is sent with revoked token")
+	}
+
+	for _, broadcaster := range broadcasters {
+		eventually(t, func() bool {
+			selectedBot, _ := env.store.selectedBot(broadcaster.UserID)
+			return !selectedBot.Enabled
+		}, "bot of revoked bot account is not disabled")
+	}
+	for range broadcasters {
+		select {
+		case <-notified:
+		case <-time.After(5 * time.Second):
+			t.Fatal("reauth required is not notified")
+		}
+	}
+
+	// provider is marked once, not per channel
+	select {
+	case msg := <-marked:
+		var request apptype.Request[data.AuthProviderReauthRequired]
+		err := json.Unmarshal(msg.Data, &request)
+		if err != nil {
+			t.Fatalf("cannot decode auth reauth request: %v", err)
+		}
+		if request.Data.ID != bot.ID || request.Data.ProviderUserID != "1" || request.Data.Reason == "" {
+			t.Errorf("wrong provider is marked as reauth required: %+v", request.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("provider is not marked as reauth required in auth module")
+	}
+	select {
+	case msg := <-marked:
+		t.Errorf("provider is marked again: %s", msg.Data)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// only the bot is dead, webhooks of broadcasters are kept
+	if !sameSubscriptions(subs, env.kick.Subscriptions()) {
+		t.Errorf("subscriptions of broadcasters are deleted")
+	}
+}
+
+func TestBotServiceDisablesBotOfRevokedBroadcaster(t *testing.T) {
+	env := newTestEnv(t)
+	env.addUser(1, "bot")
+	env.store.defaultBotID = "1"
+
+	var broadcasters []sharedData.AuthProvider
+	for _, id := range []int{2, 3} {
+		broadcaster := env.addUser(id, "broadcaster")
+		err := env.botService.StartBot(context.Background(), sharedData.PlatformBotToggle{
+			Platform: platform.Kick,
+			UserID:   broadcaster.UserID,
+		})
+		if err != nil {
+			t.Fatalf("cannot start bot: %v", err)
+		}
+		broadcasters = append(broadcasters, broadcaster)
+	}
+	revoked, other := broadcasters[0], broadcasters[1]
+
+	env.kick.RevokeUser(2)
+	err := env.whService.Subscribe(context.Background(), revoked)
+	if err == nil {
+		t.Fatal("revoked broadcaster is subscribed")
+	}
+
+	eventually(t, func() bool {
+		selectedBot, _ := env.store.selectedBot(revoked.UserID)
+		return !selectedBot.Enabled
+	}, "bot of revoked broadcaster is not disabled")
+	eventually(t, func() bool {
+		return len(env.store.storedSubscriptions("2")) == 0
+	}, "subscriptions of revoked broadcaster are still stored")
+
+	for _, sub := range env.kick.Subscriptions() {
+		if sub.BroadcasterUserID == 2 {
+			t.Errorf("subscription of revoked broadcaster is left: %+v", sub)
+		}
+	}
+
+	selectedBot, _ := env.store.selectedBot(other.UserID)
+	if !selectedBot.Enabled {
+		t.Errorf("bot of other broadcaster is disabled")
+	}
+	if len(env.store.storedSubscriptions("3")) != len(webhookSubscriptions) {
+		t.Errorf("subscriptions of other broadcaster are deleted")
+	}
+}
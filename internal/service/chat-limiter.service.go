@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/arnokay/arnobot-shared/apperror"
+	"github.com/arnokay/arnobot-shared/applog"
+	"golang.org/x/time/rate"
+
+	"github.com/arnokay/arnobot-kick/internal/metrics"
+)
+
+// ErrRateLimited is returned when message cannot be sent within max wait
+var ErrRateLimited = apperror.New(apperror.CodeExternal, "chat rate limit is reached, message is dropped", nil)
+
+type ChatLimit struct {
+	// Rate is messages per second
+	Rate  float64
+	Burst int
+}
+
+type ChatLimiterOptions struct {
+	// Bot limits messages of one bot in all channels
+	Bot ChatLimit
+	// Channel limits messages of all bots in one channel
+	Channel ChatLimit
+	// BotOverrides replaces Bot limit for specific bot ids
+	BotOverrides map[string]ChatLimit
+	// MaxWait is how long message can wait for its turn
+	MaxWait time.Duration
+	// IdleTimeout is how long unused limiters are kept
+	IdleTimeout time.Duration
+}
+
+type chatLimiterEntry struct {
+	limiter *rate.Limiter
+	// pausedUntil is set when kick responded with 429 or no remaining requests
+	pausedUntil time.Time
+	lastUsed    time.Time
+}
+
+// ChatLimiterService is token bucket limiter of outbound chat messages keyed
+// by bot and by broadcaster. Messages wait for both buckets, up to MaxWait.
+type ChatLimiterService struct {
+	options ChatLimiterOptions
+
+	bots     map[string]*chatLimiterEntry
+	channels map[string]*chatLimiterEntry
+	mu       sync.Mutex
+
+	logger applog.Logger
+}
+
+func NewChatLimiterService(options ChatLimiterOptions) *ChatLimiterService {
+	logger := applog.NewServiceLogger("chat-limiter-service")
+
+	return &ChatLimiterService{
+		options:  options,
+		bots:     make(map[string]*chatLimiterEntry),
+		channels: make(map[string]*chatLimiterEntry),
+		logger:   logger,
+	}
+}
+
+// Wait blocks until bot can send message to the channel. ErrRateLimited is
+// returned without waiting if the turn comes later than MaxWait.
+func (s *ChatLimiterService) Wait(ctx context.Context, botID, broadcasterID string) error {
+	if s.options.MaxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.options.MaxWait)
+		defer cancel()
+	}
+
+	deadline, hasDeadline := ctx.Deadline()
+
+	now := time.Now()
+
+	s.mu.Lock()
+	bot := s.entry(s.bots, botID, s.botLimit(botID), now)
+	channel := s.entry(s.channels, broadcasterID, s.options.Channel, now)
+
+	pausedUntil := bot.pausedUntil
+	if channel.pausedUntil.After(pausedUntil) {
+		pausedUntil = channel.pausedUntil
+	}
+	start := now
+	if pausedUntil.After(start) {
+		start = pausedUntil
+	}
+
+	botReservation := bot.limiter.ReserveN(start, 1)
+	channelReservation := channel.limiter.ReserveN(start, 1)
+	s.mu.Unlock()
+
+	delay := start.Sub(now) + max(botReservation.DelayFrom(start), channelReservation.DelayFrom(start))
+
+	if !botReservation.OK() || !channelReservation.OK() || (hasDeadline && now.Add(delay).After(deadline)) {
+		botReservation.CancelAt(now)
+		channelReservation.CancelAt(now)
+		metrics.ChatLimiter.Add(metrics.ChatLimiterRejected, 1)
+		s.logger.WarnContext(ctx, "chat rate limit is reached", "botID", botID, "broadcasterID", broadcasterID, "delay", delay)
+		return ErrRateLimited
+	}
+
+	if delay <= 0 {
+		return nil
+	}
+
+	metrics.ChatLimiter.Add(metrics.ChatLimiterWaited, 1)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		botReservation.Cancel()
+		channelReservation.Cancel()
+		metrics.ChatLimiter.Add(metrics.ChatLimiterRejected, 1)
+		return ErrRateLimited
+	}
+}
+
+// PauseBot stops messages of the bot until given time, it is called when
+// kick responds with 429 or reports no remaining requests
+func (s *ChatLimiterService) PauseBot(botID string, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bot := s.entry(s.bots, botID, s.botLimit(botID), time.Now())
+	if until.After(bot.pausedUntil) {
+		bot.pausedUntil = until
+		metrics.ChatLimiter.Add(metrics.ChatLimiterPaused, 1)
+		s.logger.Warn("bot is rate limited by kick", "botID", botID, "until", until)
+	}
+}
+
+// Janitor removes idle limiters until ctx is done
+func (s *ChatLimiterService) Janitor(ctx context.Context) {
+	if s.options.IdleTimeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.options.IdleTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.cleanup()
+		}
+	}
+}
+
+func (s *ChatLimiterService) cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deadline := time.Now().Add(-s.options.IdleTimeout)
+	for _, entries := range []map[string]*chatLimiterEntry{s.bots, s.channels} {
+		for key, entry := range entries {
+			if entry.lastUsed.Before(deadline) && entry.pausedUntil.Before(deadline) {
+				delete(entries, key)
+			}
+		}
+	}
+}
+
+func (s *ChatLimiterService) botLimit(botID string) ChatLimit {
+	if limit, ok := s.options.BotOverrides[botID]; ok {
+		return limit
+	}
+
+	return s.options.Bot
+}
+
+// entry must be called with mu held
+func (s *ChatLimiterService) entry(entries map[string]*chatLimiterEntry, key string, limit ChatLimit, now time.Time) *chatLimiterEntry {
+	entry, ok := entries[key]
+	if !ok {
+		// not positive rate is unlimited
+		limiter := rate.NewLimiter(rate.Inf, 0)
+		if limit.Rate > 0 {
+			limiter = rate.NewLimiter(rate.Limit(limit.Rate), max(limit.Burst, 1))
+		}
+		entry = &chatLimiterEntry{
+			limiter: limiter,
+		}
+		entries[key] = entry
+	}
+	entry.lastUsed = now
+
+	return entry
+}
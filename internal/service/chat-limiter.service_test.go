@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChatLimiterWaitRejectsOverMaxWait(t *testing.T) {
+	limiter := NewChatLimiterService(ChatLimiterOptions{
+		Bot:     ChatLimit{Rate: 1, Burst: 1},
+		MaxWait: 100 * time.Millisecond,
+	})
+
+	err := limiter.Wait(context.Background(), "bot", "channel")
+	if err != nil {
+		t.Fatalf("first message is limited: %v", err)
+	}
+
+	start := time.Now()
+	err = limiter.Wait(context.Background(), "bot", "channel")
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("got %v, want ErrRateLimited", err)
+	}
+	// turn comes later than max wait, so message is rejected without waiting
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("rejected message waited %s", elapsed)
+	}
+}
+
+func TestChatLimiterWaitWaitsForTurn(t *testing.T) {
+	limiter := NewChatLimiterService(ChatLimiterOptions{
+		Bot:     ChatLimit{Rate: 10, Burst: 1},
+		MaxWait: time.Second,
+	})
+
+	start := time.Now()
+	for range 3 {
+		err := limiter.Wait(context.Background(), "bot", "channel")
+		if err != nil {
+			t.Fatalf("message within max wait is limited: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("3 messages at 10/s are sent in %s", elapsed)
+	}
+}
+
+func TestChatLimiterWaitLimitsChannel(t *testing.T) {
+	limiter := NewChatLimiterService(ChatLimiterOptions{
+		Channel: ChatLimit{Rate: 1, Burst: 1},
+		MaxWait: 100 * time.Millisecond,
+	})
+
+	err := limiter.Wait(context.Background(), "bot-1", "channel")
+	if err != nil {
+		t.Fatalf("first message is limited: %v", err)
+	}
+
+	err = limiter.Wait(context.Background(), "bot-2", "channel")
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("other bot in the same channel: got %v, want ErrRateLimited", err)
+	}
+
+	err = limiter.Wait(context.Background(), "bot-1", "other-channel")
+	if err != nil {
+		t.Errorf("message to other channel is limited: %v", err)
+	}
+}
+
+func TestChatLimiterWaitRejectionKeepsTokens(t *testing.T) {
+	limiter := NewChatLimiterService(ChatLimiterOptions{
+		Bot:     ChatLimit{Rate: 1, Burst: 1},
+		Channel: ChatLimit{Rate: 1, Burst: 1},
+		MaxWait: 100 * time.Millisecond,
+	})
+
+	err := limiter.Wait(context.Background(), "bot-1", "channel-1")
+	if err != nil {
+		t.Fatalf("first message is limited: %v", err)
+	}
+
+	// rejected by bot limit, its channel reservation is cancelled
+	err = limiter.Wait(context.Background(), "bot-1", "channel-2")
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("got %v, want ErrRateLimited", err)
+	}
+
+	err = limiter.Wait(context.Background(), "bot-2", "channel-2")
+	if err != nil {
+		t.Errorf("rejected message used channel token: %v", err)
+	}
+}
+
+func TestChatLimiterPauseBot(t *testing.T) {
+	limiter := NewChatLimiterService(ChatLimiterOptions{
+		MaxWait: time.Second,
+	})
+
+	limiter.PauseBot("bot", time.Now().Add(200*time.Millisecond))
+
+	start := time.Now()
+	err := limiter.Wait(context.Background(), "bot", "channel")
+	if err != nil {
+		t.Fatalf("paused bot is rejected within max wait: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("paused bot waited %s, want until pause ends", elapsed)
+	}
+
+	err = limiter.Wait(context.Background(), "other-bot", "channel")
+	if err != nil {
+		t.Errorf("other bot is limited: %v", err)
+	}
+
+	// pause longer than max wait rejects messages
+	limiter.PauseBot("bot", time.Now().Add(time.Minute))
+	err = limiter.Wait(context.Background(), "bot", "channel")
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("got %v, want ErrRateLimited", err)
+	}
+
+	// earlier pause does not shorten current one
+	limiter.PauseBot("bot", time.Now())
+	err = limiter.Wait(context.Background(), "bot", "channel")
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("pause is shortened: got %v, want ErrRateLimited", err)
+	}
+}
+
+func TestChatLimiterBotOverrides(t *testing.T) {
+	limiter := NewChatLimiterService(ChatLimiterOptions{
+		Bot: ChatLimit{Rate: 1, Burst: 1},
+		BotOverrides: map[string]ChatLimit{
+			"unlimited": {Rate: 0},
+			"burst":     {Rate: 1, Burst: 3},
+		},
+		MaxWait: 100 * time.Millisecond,
+	})
+
+	tests := []struct {
+		botID string
+		// allowed is number of messages sent at once
+		allowed int
+	}{
+		{"default", 1},
+		{"burst", 3},
+		{"unlimited", 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.botID, func(t *testing.T) {
+			for i := range tt.allowed {
+				err := limiter.Wait(context.Background(), tt.botID, tt.botID)
+				if err != nil {
+					t.Fatalf("message %d is limited: %v", i, err)
+				}
+			}
+
+			if tt.botID == "unlimited" {
+				return
+			}
+			err := limiter.Wait(context.Background(), tt.botID, tt.botID)
+			if !errors.Is(err, ErrRateLimited) {
+				t.Errorf("message over burst: got %v, want ErrRateLimited", err)
+			}
+		})
+	}
+}
+
+func TestChatLimiterWaitCancelled(t *testing.T) {
+	limiter := NewChatLimiterService(ChatLimiterOptions{
+		Bot: ChatLimit{Rate: 1, Burst: 1},
+	})
+
+	err := limiter.Wait(context.Background(), "bot", "channel")
+	if err != nil {
+		t.Fatalf("first message is limited: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// ctx deadline acts as max wait
+	err = limiter.Wait(ctx, "bot", "channel")
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("got %v, want ErrRateLimited", err)
+	}
+}
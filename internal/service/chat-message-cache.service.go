@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+
+	"github.com/arnokay/arnobot-shared/apperror"
+	"github.com/arnokay/arnobot-shared/applog"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var chatMessageKeyReplacer = regexp.MustCompile(`[^a-zA-Z0-9_=\-]`)
+
+type chatMessageEntry struct {
+	ChatterID string `json:"chatterId"`
+}
+
+// ChatMessageCacheService remembers recent chat messages of channels, so
+// replies are only sent to messages that exist. Messages are kept in KV
+// bucket with TTL, which is shared by all replicas.
+type ChatMessageCacheService struct {
+	cache jetstream.KeyValue
+
+	logger applog.Logger
+}
+
+func NewChatMessageCacheService(
+	cache jetstream.KeyValue,
+) *ChatMessageCacheService {
+	logger := applog.NewServiceLogger("chat-message-cache-service")
+
+	return &ChatMessageCacheService{
+		cache:  cache,
+		logger: logger,
+	}
+}
+
+// Remember stores message of the channel until bucket TTL
+func (s *ChatMessageCacheService) Remember(ctx context.Context, broadcasterID, messageID, chatterID string) error {
+	if messageID == "" {
+		return nil
+	}
+
+	value, _ := json.Marshal(chatMessageEntry{ChatterID: chatterID})
+
+	_, err := s.cache.Put(ctx, s.key(broadcasterID, messageID), value)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot remember chat message", "err", err, "broadcasterID", broadcasterID, "messageID", messageID)
+		return apperror.ErrInternal
+	}
+
+	return nil
+}
+
+// Exists reports if message was sent to the channel recently
+func (s *ChatMessageCacheService) Exists(ctx context.Context, broadcasterID, messageID string) (bool, error) {
+	_, err := s.cache.Get(ctx, s.key(broadcasterID, messageID))
+	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return false, nil
+		}
+		s.logger.ErrorContext(ctx, "cannot get chat message", "err", err, "broadcasterID", broadcasterID, "messageID", messageID)
+		return false, apperror.ErrInternal
+	}
+
+	return true, nil
+}
+
+func (s *ChatMessageCacheService) key(broadcasterID, messageID string) string {
+	return chatMessageKeyReplacer.ReplaceAllString(broadcasterID, "_") + "." +
+		chatMessageKeyReplacer.ReplaceAllString(messageID, "_")
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/arnokay/arnobot-shared/apperror"
+	"github.com/arnokay/arnobot-shared/applog"
+
+	"github.com/arnokay/arnobot-kick/internal/data"
+	"github.com/arnokay/arnobot-kick/internal/db"
+	"github.com/arnokay/arnobot-kick/internal/storage"
+)
+
+type ChatSettingsService struct {
+	storage storage.Storager
+
+	logger applog.Logger
+}
+
+func NewChatSettingsService(
+	store storage.Storager,
+) *ChatSettingsService {
+	logger := applog.NewServiceLogger("chat-settings-service")
+
+	return &ChatSettingsService{
+		storage: store,
+		logger:  logger,
+	}
+}
+
+// ChatSettingsGet returns chat settings of the channel, channels without
+// stored settings send as user
+func (s *ChatSettingsService) ChatSettingsGet(ctx context.Context, arg data.ChatSettingsGet) (data.ChatSettings, error) {
+	fromDB, err := s.storage.KickQuery(ctx).KickChatSettingsGet(ctx, arg.BroadcasterID)
+	if err != nil {
+		err = s.storage.HandleErr(ctx, err)
+		if errors.Is(err, apperror.ErrNotFound) {
+			return data.ChatSettings{
+				BroadcasterID: arg.BroadcasterID,
+				SendAs:        data.ChatSendAsUser,
+			}, nil
+		}
+		s.logger.DebugContext(ctx, "cannot get chat settings", "err", err, "broadcasterID", arg.BroadcasterID)
+		return data.ChatSettings{}, err
+	}
+
+	return data.NewChatSettingsFromDB(fromDB), nil
+}
+
+func (s *ChatSettingsService) ChatSettingsUpdate(ctx context.Context, arg data.ChatSettingsUpdate) (data.ChatSettings, error) {
+	if arg.BroadcasterID == "" || !arg.SendAs.Valid() {
+		return data.ChatSettings{}, apperror.ErrInvalidInput
+	}
+
+	fromDB, err := s.storage.KickQuery(ctx).KickChatSettingsUpsert(ctx, db.KickChatSettingsUpsertParams{
+		BroadcasterID: arg.BroadcasterID,
+		SendAs:        string(arg.SendAs),
+		UpdatedAt:     time.Now().UTC(),
+	})
+	if err != nil {
+		s.logger.DebugContext(ctx, "cannot update chat settings", "err", err, "broadcasterID", arg.BroadcasterID)
+		return data.ChatSettings{}, s.storage.HandleErr(ctx, err)
+	}
+
+	return data.NewChatSettingsFromDB(fromDB), nil
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/arnokay/arnobot-shared/apperror"
+	"github.com/rivo/uniseg"
+)
+
+// ErrMessageTooLong is returned when message does not fit into max parts
+var ErrMessageTooLong = apperror.New(apperror.CodeInvalidInput, "chat message is too long", nil)
+
+var emotePattern = regexp.MustCompile(`\[emote:\d+:[^\]\s]*\]`)
+
+// chatAtom is part of message that cannot be split, a grapheme or an emote.
+// Its length is in runes.
+type chatAtom struct {
+	text   string
+	length int
+	space  bool
+}
+
+// splitChatMessage splits message into parts of at most maxLength runes, kick
+// counts length in code points. Parts are split after whitespace when
+// possible, otherwise between graphemes, emotes are never split. Whitespace
+// around parts is trimmed.
+func splitChatMessage(message string, maxLength, maxParts int) ([]string, error) {
+	message = strings.TrimSpace(message)
+	if maxLength <= 0 || utf8.RuneCountInString(message) <= maxLength {
+		return []string{message}, nil
+	}
+
+	atoms := chatAtoms(message)
+
+	var parts []string
+	for start := 0; start < len(atoms); {
+		// skip whitespace the previous part was split on
+		for start < len(atoms) && atoms[start].space {
+			start++
+		}
+		if start == len(atoms) {
+			break
+		}
+
+		end, length, lastBreak := start, 0, -1
+		for end < len(atoms) && length+atoms[end].length <= maxLength {
+			if atoms[end].space {
+				lastBreak = end
+			}
+			length += atoms[end].length
+			end++
+		}
+		// emote or grapheme longer than the limit is sent alone and rejected by kick
+		if end == start {
+			end++
+		}
+		// whole words only, unless one word fills the part
+		if end < len(atoms) && !atoms[end].space && lastBreak > start {
+			end = lastBreak
+		}
+
+		var part strings.Builder
+		for _, atom := range atoms[start:end] {
+			part.WriteString(atom.text)
+		}
+		parts = append(parts, strings.TrimRightFunc(part.String(), unicode.IsSpace))
+
+		if maxParts > 0 && len(parts) > maxParts {
+			return nil, ErrMessageTooLong
+		}
+
+		start = end
+	}
+
+	return parts, nil
+}
+
+// chatAtoms splits message into graphemes, emotes are kept whole
+func chatAtoms(message string) []chatAtom {
+	var atoms []chatAtom
+
+	appendGraphemes := func(text string) {
+		graphemes := uniseg.NewGraphemes(text)
+		for graphemes.Next() {
+			runes := graphemes.Runes()
+			atoms = append(atoms, chatAtom{
+				text:   graphemes.Str(),
+				length: len(runes),
+				space:  len(runes) == 1 && unicode.IsSpace(runes[0]),
+			})
+		}
+	}
+
+	last := 0
+	for _, loc := range emotePattern.FindAllStringIndex(message, -1) {
+		appendGraphemes(message[last:loc[0]])
+		atoms = append(atoms, chatAtom{
+			text:   message[loc[0]:loc[1]],
+			length: utf8.RuneCountInString(message[loc[0]:loc[1]]),
+		})
+		last = loc[1]
+	}
+	appendGraphemes(message[last:])
+
+	return atoms
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitChatMessage(t *testing.T) {
+	// family is one grapheme of 5 runes
+	family := "👨‍👩‍👧"
+	// accented is one grapheme of 2 runes
+	accented := "é"
+
+	tests := []struct {
+		name      string
+		message   string
+		maxLength int
+		maxParts  int
+		want      []string
+		wantErr   error
+	}{
+		{
+			name:      "short message",
+			message:   "  hello world ",
+			maxLength: 20,
+			want:      []string{"hello world"},
+		},
+		{
+			name:      "unlimited length",
+			message:   strings.Repeat("a", 1000),
+			maxLength: 0,
+			want:      []string{strings.Repeat("a", 1000)},
+		},
+		{
+			name:      "split on whitespace",
+			message:   "aaa bbb ccc",
+			maxLength: 7,
+			want:      []string{"aaa bbb", "ccc"},
+		},
+		{
+			name:      "long word",
+			message:   "abcdefghij",
+			maxLength: 4,
+			want:      []string{"abcd", "efgh", "ij"},
+		},
+		{
+			name:      "long word after short one",
+			message:   "ab cdefghij",
+			maxLength: 4,
+			want:      []string{"ab", "cdef", "ghij"},
+		},
+		{
+			name:      "emote between words",
+			message:   "hello [emote:1:a] world",
+			maxLength: 20,
+			want:      []string{"hello [emote:1:a]", "world"},
+		},
+		{
+			name:      "emote is not split",
+			message:   "ab[emote:1:xyz]",
+			maxLength: 14,
+			want:      []string{"ab", "[emote:1:xyz]"},
+		},
+		{
+			name:      "emote over limit is sent alone",
+			message:   "hi [emote:123:wave]",
+			maxLength: 10,
+			want:      []string{"hi", "[emote:123:wave]"},
+		},
+		{
+			name:      "multi-rune graphemes count runes",
+			message:   strings.Repeat(family, 3),
+			maxLength: 10,
+			want:      []string{family + family, family},
+		},
+		{
+			name:      "multi-rune graphemes are not split",
+			message:   strings.Repeat(accented, 6),
+			maxLength: 5,
+			want:      []string{strings.Repeat(accented, 2), strings.Repeat(accented, 2), strings.Repeat(accented, 2)},
+		},
+		{
+			name:      "graphemes fitting in grapheme count",
+			message:   family + " " + family,
+			maxLength: 5,
+			want:      []string{family, family},
+		},
+		{
+			name:      "within parts limit",
+			message:   "aaaa bbbb cccc",
+			maxLength: 4,
+			maxParts:  3,
+			want:      []string{"aaaa", "bbbb", "cccc"},
+		},
+		{
+			name:      "over parts limit",
+			message:   "aaaa bbbb cccc",
+			maxLength: 4,
+			maxParts:  2,
+			wantErr:   ErrMessageTooLong,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, err := splitChatMessage(tt.message, tt.maxLength, tt.maxParts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if !slices.Equal(parts, tt.want) {
+				t.Errorf("got parts %q, want %q", parts, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitChatMessageFitsKickLimit(t *testing.T) {
+	message := strings.Repeat("👨‍👩‍👧 word [emote:37226:KEKW] ", 60)
+
+	parts, err := splitChatMessage(message, 500, 0)
+	if err != nil {
+		t.Fatalf("cannot split message: %v", err)
+	}
+
+	for i, part := range parts {
+		if n := utf8.RuneCountInString(part); n > 500 {
+			t.Errorf("part %d has %d runes, kick rejects over 500", i, n)
+		}
+	}
+	if strings.Join(parts, " ") != strings.TrimSpace(message) {
+		t.Errorf("parts do not make up the message")
+	}
+}
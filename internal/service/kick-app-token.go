@@ -0,0 +1,302 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/arnokay/arnobot-shared/apperror"
+	"github.com/arnokay/arnobot-shared/applog"
+	"github.com/scorfly/gokick"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// appTokenRefreshMargin is how long before expiry app token is refreshed
+	appTokenRefreshMargin = 5 * time.Minute
+	// appTokenDefaultLifetime is used when kick does not send expires_in
+	appTokenDefaultLifetime = time.Hour
+
+	// appTokenRequestTimeout bounds shared token request, it outlives
+	// the caller that started it
+	appTokenRequestTimeout = 10 * time.Second
+
+	appTokenRetryMin = time.Second
+	appTokenRetryMax = time.Minute
+)
+
+// ErrKickUnavailable is returned by kick app operations until app token is acquired
+var ErrKickUnavailable = apperror.New(apperror.CodeExternal, "kick is unavailable, app token is not acquired", nil)
+
+// appTokenSource holds kick app access token. It is acquired lazily, refreshed
+// before it expires and when kick responds with 401, refresh is shared by all
+// goroutines waiting for it. Failed attempts are retried with backoff, until
+// then callers get ErrKickUnavailable instead of waiting for kick.
+type appTokenSource struct {
+	logger applog.Logger
+	// client is used only to request tokens
+	client *gokick.Client
+	// group runs one token request at a time, kick is called without mu held
+	group singleflight.Group
+	// ready is read by readiness probe without waiting for mu
+	ready atomic.Bool
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	// nextAttempt is when token can be requested again after failed attempt
+	nextAttempt time.Time
+	retry       time.Duration
+}
+
+func newAppTokenSource(clientID, clientSecret string, options KickManagerOptions) *appTokenSource {
+	client, _ := gokick.NewClient(&gokick.ClientOptions{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		APIBaseURL:   options.APIBaseURL,
+		AuthBaseURL:  options.AuthBaseURL,
+		HTTPClient:   options.HTTPClient,
+	})
+
+	return &appTokenSource{
+		logger: applog.NewServiceLogger("kick-app-token"),
+		client: client,
+		retry:  appTokenRetryMin,
+	}
+}
+
+// Ready reports if app token is acquired. Token that expired is reported
+// after the next failed refresh attempt.
+func (s *appTokenSource) Ready() bool {
+	return s.ready.Load()
+}
+
+// Token returns current app token, it is refreshed first if it is about to expire.
+// Token that is about to expire is still returned if refresh fails.
+func (s *appTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	if s.token != "" && time.Until(s.expiresAt) > appTokenRefreshMargin {
+		defer s.mu.Unlock()
+		return s.token, nil
+	}
+	s.mu.Unlock()
+
+	return s.tryRefresh(ctx)
+}
+
+// Refresh replaces rejected token. If it was already replaced by other
+// goroutine, the new token is returned without requesting another one.
+func (s *appTokenSource) Refresh(ctx context.Context, rejected string) (string, error) {
+	s.mu.Lock()
+	if s.token != "" && s.token != rejected {
+		defer s.mu.Unlock()
+		return s.token, nil
+	}
+	// rejected token should not be handed out again
+	s.token = ""
+	s.ready.Store(false)
+	s.mu.Unlock()
+
+	return s.tryRefresh(ctx)
+}
+
+// tryRefresh refreshes token unless last attempt failed recently
+func (s *appTokenSource) tryRefresh(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	if time.Now().Before(s.nextAttempt) {
+		defer s.mu.Unlock()
+		if s.valid() {
+			return s.token, nil
+		}
+		return "", ErrKickUnavailable
+	}
+	s.mu.Unlock()
+
+	err := s.refresh(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		if s.valid() {
+			return s.token, nil
+		}
+		return "", apperror.New(apperror.CodeExternal, ErrKickUnavailable.Message, err)
+	}
+	if s.token == "" {
+		// token was rejected again right after refresh
+		return "", ErrKickUnavailable
+	}
+
+	return s.token, nil
+}
+
+// refresh requests new token, concurrent calls share one request. Request is
+// not canceled with ctx of the caller, other callers may wait for it.
+func (s *appTokenSource) refresh(ctx context.Context) error {
+	result := s.group.DoChan("app-token", func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), appTokenRequestTimeout)
+		defer cancel()
+
+		return nil, s.request(ctx)
+	})
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case r := <-result:
+		return r.Err
+	}
+}
+
+// request calls kick without mu held, mu is held only to store the result
+func (s *appTokenSource) request(ctx context.Context) error {
+	response, err := s.client.GetAppAccessToken(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		s.nextAttempt = time.Now().Add(s.retry)
+		s.logger.ErrorContext(ctx, "cannot get app access token", "err", err, "retryIn", s.retry)
+		s.retry = min(s.retry*2, appTokenRetryMax)
+		s.ready.Store(s.valid())
+		return err
+	}
+
+	lifetime := time.Duration(response.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = appTokenDefaultLifetime
+	}
+
+	s.token = response.AccessToken
+	s.expiresAt = time.Now().Add(lifetime)
+	s.nextAttempt = time.Time{}
+	s.retry = appTokenRetryMin
+	s.ready.Store(true)
+
+	s.logger.InfoContext(ctx, "app access token refreshed", "expiresAt", s.expiresAt)
+
+	return nil
+}
+
+// valid must be called with mu held
+func (s *appTokenSource) valid() bool {
+	return s.token != "" && time.Now().Before(s.expiresAt)
+}
+
+// nextRun returns when Run should refresh the token
+func (s *appTokenSource) nextRun() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.nextAttempt.IsZero() {
+		return s.nextAttempt
+	}
+
+	return s.expiresAt.Add(-appTokenRefreshMargin)
+}
+
+// Run acquires the token and refreshes it before it expires until ctx is done,
+// failed attempts are retried with backoff
+func (s *appTokenSource) Run(ctx context.Context) {
+	timer := time.NewTimer(time.Until(s.nextRun()))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		s.mu.Lock()
+		due := s.token == "" || time.Until(s.expiresAt) <= appTokenRefreshMargin
+		s.mu.Unlock()
+
+		if due {
+			s.refresh(ctx)
+		}
+
+		timer.Reset(time.Until(s.nextRun()))
+	}
+}
+
+// appTokenTransport authorizes kick api requests with app token and retries
+// request once with refreshed token if kick responds with 401
+type appTokenTransport struct {
+	base   http.RoundTripper
+	tokens *appTokenSource
+	// observe is called with every api response
+	observe func(*http.Response)
+}
+
+func (t *appTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasPrefix(req.URL.Path, "/oauth/") {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+
+	token, err := t.tokens.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	if req.Body != nil {
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response, err := t.base.RoundTrip(authorizeRequest(req, token, body))
+	if err != nil {
+		return nil, err
+	}
+	t.observeResponse(response)
+	if response.StatusCode != http.StatusUnauthorized {
+		return response, nil
+	}
+
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+
+	token, err = t.tokens.Refresh(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err = t.base.RoundTrip(authorizeRequest(req, token, body))
+	if err != nil {
+		return nil, err
+	}
+	t.observeResponse(response)
+
+	return response, nil
+}
+
+func (t *appTokenTransport) observeResponse(response *http.Response) {
+	if t.observe != nil {
+		t.observe(response)
+	}
+}
+
+// authorizeRequest returns copy of req with bearer token and buffered body
+func authorizeRequest(req *http.Request, token string, body []byte) *http.Request {
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+	}
+
+	return r
+}
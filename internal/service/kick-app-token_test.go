@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arnokay/arnobot-kick/internal/kickfake"
+)
+
+// heldTokenTransport holds app token requests until release is closed
+type heldTokenTransport struct {
+	base     http.RoundTripper
+	release  chan struct{}
+	requests atomic.Int32
+}
+
+func (t *heldTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Path == "/oauth/token" {
+		t.requests.Add(1)
+		<-t.release
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+func newHeldTokenKickManager(t *testing.T) (*KickManager, *heldTokenTransport) {
+	t.Helper()
+
+	kick := kickfake.New()
+	t.Cleanup(kick.Close)
+
+	transport := &heldTokenTransport{
+		base:    kick.Client().Transport,
+		release: make(chan struct{}),
+	}
+	hm := NewKickManager(newKV(t, startNATS(t), "default-kick"), nil, kickfake.ClientID, kickfake.ClientSecret, KickManagerOptions{
+		APIBaseURL:  kick.URL,
+		AuthBaseURL: kick.URL,
+		HTTPClient:  &http.Client{Transport: transport},
+	})
+
+	return hm, transport
+}
+
+func TestAppTokenRequestIsShared(t *testing.T) {
+	hm, transport := newHeldTokenKickManager(t)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := hm.GetApp(context.Background())
+			if err != nil {
+				t.Errorf("cannot get app client: %v", err)
+			}
+		}()
+	}
+
+	eventually(t, func() bool {
+		return transport.requests.Load() > 0
+	}, "app token is not requested")
+
+	// readiness probe does not wait for kick
+	if hm.Ready() {
+		t.Error("ready before app token is acquired")
+	}
+
+	// caller does not wait for kick longer than its ctx allows
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := hm.GetApp(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("caller with expired ctx: got %v, want deadline exceeded", err)
+	}
+
+	close(transport.release)
+	wg.Wait()
+
+	if n := transport.requests.Load(); n != 1 {
+		t.Errorf("app token is requested %d times, want once", n)
+	}
+	if !hm.Ready() {
+		t.Error("not ready after app token is acquired")
+	}
+}
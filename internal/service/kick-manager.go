@@ -1,124 +1,340 @@
 package service
 
 import (
-	"bytes"
 	"context"
+	"fmt"
+	"net/http"
+	"os"
 	"sync"
 	"time"
 
+	"github.com/arnokay/arnobot-kick/internal/metrics"
 	"github.com/arnokay/arnobot-shared/apperror"
 	"github.com/arnokay/arnobot-shared/applog"
-	"github.com/arnokay/arnobot-shared/data"
-	"github.com/arnokay/arnobot-shared/pkg/assert"
+	sharedData "github.com/arnokay/arnobot-shared/data"
 	sharedService "github.com/arnokay/arnobot-shared/service"
-	"github.com/arnokay/arnobot-shared/trace"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/scorfly/gokick"
 )
 
-// TODO: right now there is no cleanup for clients
+type KickManagerOptions struct {
+	// APIBaseURL and AuthBaseURL point clients to other kick api, e.g. kickfake
+	APIBaseURL  string
+	AuthBaseURL string
+	HTTPClient  *http.Client
+
+	// MaxClients limits number of cached user clients, least recently used
+	// client is evicted when limit is reached. 0 is unlimited
+	MaxClients int
+	// ClientIdleTimeout is how long unused user client is kept. 0 keeps forever
+	ClientIdleTimeout time.Duration
+}
+
+type kickClient struct {
+	client   *gokick.Client
+	provider sharedData.AuthProvider
+	// refreshMu serializes token refresh of the client
+	refreshMu sync.Mutex
+
+	// tokens client currently holds, they are compared with provider tokens
+	// to find out if client is outdated
+	accessToken  string
+	refreshToken string
+	// updatedAt is when tokens were set or refreshed
+	updatedAt time.Time
+	lastUsed  time.Time
+	// revoked is set when kick rejected tokens, client fails without calling
+	// kick until provider gets new tokens
+	revoked bool
+}
+
+// KickManager caches gokick user clients by kick user id.
+// Clients are evicted after ClientIdleTimeout of inactivity or when
+// MaxClients is reached, and replaced when provider tokens change.
 type KickManager struct {
 	logger       applog.Logger
 	clientID     string
 	clientSecret string
+	options      KickManagerOptions
 
 	appClient *gokick.Client
+	appToken  *appTokenSource
+	// authClient is used to refresh user tokens
+	authClient *gokick.Client
+	// instanceID identifies the replica in user token locks
+	instanceID string
 
-	clients map[string]*gokick.Client
-	mu      sync.RWMutex
+	clients map[string]*kickClient
+	mu      sync.Mutex
 
 	cache      jetstream.KeyValue
 	authModule *sharedService.AuthModule
+
+	onReauthRequired func(ctx context.Context, provider sharedData.AuthProvider, reason string)
+	onRateLimited    func(providerUserID string, until time.Time)
 }
 
 func NewKickManager(
 	cache jetstream.KeyValue,
 	authModule *sharedService.AuthModule,
 	clientID, clientSecret string,
+	options KickManagerOptions,
 ) *KickManager {
 	logger := applog.NewServiceLogger("kick-manager")
 
-	appClient, err := gokick.NewClient(&gokick.ClientOptions{
+	appToken := newAppTokenSource(clientID, clientSecret, options)
+
+	// app token is set by transport, so it is always fresh
+	appHTTPClient := &http.Client{}
+	if options.HTTPClient != nil {
+		*appHTTPClient = *options.HTTPClient
+	}
+	base := appHTTPClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	appTransport := &appTokenTransport{base: base, tokens: appToken}
+	appHTTPClient.Transport = appTransport
+
+	// app token is acquired lazily, kick being down must not stop the service
+	appClient, _ := gokick.NewClient(&gokick.ClientOptions{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		APIBaseURL:   options.APIBaseURL,
+		AuthBaseURL:  options.AuthBaseURL,
+		HTTPClient:   appHTTPClient,
+	})
+
+	authClient, _ := gokick.NewClient(&gokick.ClientOptions{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
+		APIBaseURL:   options.APIBaseURL,
+		AuthBaseURL:  options.AuthBaseURL,
+		HTTPClient:   options.HTTPClient,
 	})
-	assert.NoError(err, "gokick client needs to be initialized")
 
-	token, err := appClient.GetAppAccessToken(context.TODO())
-	assert.NoError(err, "cannot get access tokens for app client")
+	hostname, _ := os.Hostname()
 
-	appClient.SetAppAccessToken(token.AccessToken)
+	metrics.SetInt(metrics.KickClients, metrics.KickClientsMax, int64(options.MaxClients))
 
-	return &KickManager{
+	hm := &KickManager{
 		logger:       logger,
 		clientID:     clientID,
 		clientSecret: clientSecret,
+		options:      options,
 		appClient:    appClient,
-		clients:      make(map[string]*gokick.Client),
+		appToken:     appToken,
+		authClient:   authClient,
+		instanceID:   fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		clients:      make(map[string]*kickClient),
 		cache:        cache,
 		authModule:   authModule,
 	}
+	appTransport.observe = hm.observeAppRateLimit
+
+	return hm
+}
+
+// OnReauthRequired sets callback that is called once when kick rejects
+// tokens of the provider and they cannot be refreshed
+func (hm *KickManager) OnReauthRequired(callback func(ctx context.Context, provider sharedData.AuthProvider, reason string)) {
+	hm.onReauthRequired = callback
+}
+
+// OnRateLimited sets callback that is called when kick responds to user
+// client with 429 or reports that no requests remain until reset
+func (hm *KickManager) OnRateLimited(callback func(providerUserID string, until time.Time)) {
+	hm.onRateLimited = callback
+}
+
+// observeAppRateLimit reports rate limits of app requests under app chat limiter key
+func (hm *KickManager) observeAppRateLimit(response *http.Response) {
+	if hm.onRateLimited == nil {
+		return
+	}
+
+	until, limited := rateLimitedUntil(response, time.Now())
+	if limited {
+		hm.onRateLimited(appChatLimiterKey, until)
+	}
+}
+
+// GetApp returns app client, ErrKickUnavailable is returned until app token
+// is acquired
+func (hm *KickManager) GetApp(ctx context.Context) (*gokick.Client, error) {
+	_, err := hm.appToken.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return hm.appClient, nil
 }
 
-func (hm *KickManager) GetApp(ctx context.Context) *gokick.Client {
-	return hm.appClient
+// Ready reports if app token is acquired, user clients do not depend on it
+func (hm *KickManager) Ready() bool {
+	return hm.appToken.Ready()
+}
+
+// RunAppTokenRefresh acquires app token and refreshes it before it expires
+// until ctx is done
+func (hm *KickManager) RunAppTokenRefresh(ctx context.Context) {
+	hm.appToken.Run(ctx)
 }
 
 func (hm *KickManager) GetByID(ctx context.Context, kickID string) (*gokick.Client, error) {
-	hm.mu.RLock()
-	client, exists := hm.clients[kickID]
-	hm.mu.RUnlock()
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
 
-	if exists {
-		return client, nil
+	entry, exists := hm.clients[kickID]
+	if !exists {
+		return nil, apperror.New(apperror.CodeNotFound, "gokick client is not found", nil)
 	}
 
-	return nil, apperror.New(apperror.CodeNotFound, "gokick client is not found", nil)
+	entry.lastUsed = time.Now()
+
+	return entry.client, nil
 }
 
-func (hm *KickManager) GetByProvider(ctx context.Context, provider data.AuthProvider) *gokick.Client {
-	client, err := hm.GetByID(ctx, provider.ProviderUserID)
+// GetByProvider returns cached client of the provider. Client is recreated
+// if provider has other tokens that are newer than tokens of the client.
+// New clients are built from tokens cached in KV if they are newer than
+// tokens of the provider.
+func (hm *KickManager) GetByProvider(ctx context.Context, provider sharedData.AuthProvider) *gokick.Client {
+	hm.mu.Lock()
+	client := hm.lookup(ctx, provider)
+	hm.mu.Unlock()
 
-	if err == nil {
+	if client != nil {
 		return client
 	}
 
+	token := hm.userToken(ctx, provider)
+
 	hm.mu.Lock()
 	defer hm.mu.Unlock()
 
-	if client, exists := hm.clients[provider.ProviderUserID]; exists {
+	if client := hm.lookup(ctx, provider); client != nil {
 		return client
 	}
 
+	if hm.options.MaxClients > 0 && len(hm.clients) >= hm.options.MaxClients {
+		hm.evictLRU(ctx)
+	}
+
+	entry := &kickClient{
+		provider:     provider,
+		accessToken:  token.AccessToken,
+		refreshToken: token.RefreshToken,
+		updatedAt:    token.UpdatedAt,
+		lastUsed:     time.Now(),
+	}
+
+	// tokens are set and refreshed by transport, gokick gets no refresh token
+	// so it does not refresh it on its own
 	client, _ = gokick.NewClient(&gokick.ClientOptions{
-		ClientID:         hm.clientID,
-		ClientSecret:     hm.clientSecret,
-		UserAccessToken:  provider.AccessToken,
-		UserRefreshToken: provider.RefreshToken,
+		ClientID:        hm.clientID,
+		ClientSecret:    hm.clientSecret,
+		UserAccessToken: token.AccessToken,
+		APIBaseURL:      hm.options.APIBaseURL,
+		AuthBaseURL:     hm.options.AuthBaseURL,
+		HTTPClient:      hm.userHTTPClient(entry),
 	})
+	entry.client = client
+
+	hm.clients[provider.ProviderUserID] = entry
+	metrics.KickClients.Add(metrics.KickClientsSize, 1)
+	metrics.KickClients.Add(metrics.KickClientsCreated, 1)
+
+	return client
+}
+
+// lookup returns cached client if it is still valid for the provider,
+// outdated client is removed. Must be called with mu held.
+func (hm *KickManager) lookup(ctx context.Context, provider sharedData.AuthProvider) *gokick.Client {
+	entry, exists := hm.clients[provider.ProviderUserID]
+	if !exists {
+		return nil
+	}
+
+	sameTokens := entry.accessToken == provider.AccessToken && entry.refreshToken == provider.RefreshToken
+	// provider could be fetched before refreshed tokens were saved by auth module
+	if sameTokens || !provider.UpdatedAt.After(entry.updatedAt) {
+		entry.lastUsed = time.Now()
+		return entry.client
+	}
 
-	client.OnUserAccessTokenRefreshed(func(newAccessToken, newRefreshToken string) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		ctx = trace.Context(ctx, trace.New())
-		defer cancel()
-		// COMBAK: maybe set ttl?
-		hm.cache.Put(
-			ctx,
-			"hm.art."+provider.Provider+"."+provider.ProviderUserID,
-			bytes.Join([][]byte{[]byte(newAccessToken), []byte(newRefreshToken)}, []byte("...")),
-		)
-		hm.logger.InfoContext(ctx, "token refreshed", "providerUserID", provider.ProviderUserID)
-		err := hm.authModule.AuthProviderUpdateTokens(ctx, data.AuthProviderUpdateTokens{
-			ID:           provider.ID,
-			AccessToken:  newAccessToken,
-			RefreshToken: newRefreshToken,
-		})
-		if err != nil {
-			hm.logger.ErrorContext(ctx, "failed to update tokens", "providerID", provider.ID, "providerUserID", provider.ProviderUserID)
+	hm.logger.DebugContext(ctx, "provider tokens changed, replacing client", "providerUserID", provider.ProviderUserID)
+	hm.remove(provider.ProviderUserID)
+	metrics.KickClients.Add(metrics.KickClientsInvalidated, 1)
+
+	return nil
+}
+
+// Janitor evicts idle clients until ctx is done. It does nothing if
+// ClientIdleTimeout is not set.
+func (hm *KickManager) Janitor(ctx context.Context) {
+	if hm.options.ClientIdleTimeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(max(hm.options.ClientIdleTimeout/2, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			hm.evictIdle(ctx)
 		}
-	})
+	}
+}
 
-	hm.clients[provider.ProviderUserID] = client
+func (hm *KickManager) evictIdle(ctx context.Context) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
 
-	return client
+	deadline := time.Now().Add(-hm.options.ClientIdleTimeout)
+
+	var evicted int
+	for kickID, entry := range hm.clients {
+		if entry.lastUsed.Before(deadline) {
+			hm.remove(kickID)
+			evicted++
+		}
+	}
+
+	metrics.KickClients.Add(metrics.KickClientsEvictedIdle, int64(evicted))
+
+	if evicted > 0 {
+		hm.logger.DebugContext(ctx, "idle clients evicted", "evicted", evicted, "clients", len(hm.clients))
+	}
+}
+
+// evictLRU must be called with mu held
+func (hm *KickManager) evictLRU(ctx context.Context) {
+	var (
+		oldestID string
+		oldest   time.Time
+	)
+	for kickID, entry := range hm.clients {
+		if oldestID == "" || entry.lastUsed.Before(oldest) {
+			oldestID = kickID
+			oldest = entry.lastUsed
+		}
+	}
+
+	if oldestID == "" {
+		return
+	}
+
+	hm.remove(oldestID)
+	metrics.KickClients.Add(metrics.KickClientsEvictedLRU, 1)
+	hm.logger.DebugContext(ctx, "client evicted, max clients reached", "providerUserID", oldestID, "lastUsed", oldest)
+}
+
+// remove must be called with mu held
+func (hm *KickManager) remove(kickID string) {
+	delete(hm.clients, kickID)
+	metrics.KickClients.Add(metrics.KickClientsSize, -1)
 }
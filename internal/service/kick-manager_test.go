@@ -0,0 +1,288 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	sharedData "github.com/arnokay/arnobot-shared/data"
+	"github.com/arnokay/arnobot-shared/platform"
+	sharedService "github.com/arnokay/arnobot-shared/service"
+	"github.com/scorfly/gokick"
+
+	"github.com/arnokay/arnobot-kick/internal/data"
+	"github.com/arnokay/arnobot-kick/internal/kickfake"
+)
+
+func TestKickManagerRefreshesRejectedToken(t *testing.T) {
+	env := newTestEnv(t)
+	bot := env.addUser(1, "bot")
+	env.addUser(2, "broadcaster")
+
+	env.kick.ExpireAccessToken(bot.AccessToken)
+
+	_, err := env.kickService.AppSendChannelMessage(context.Background(), bot, "2", "hello", "", data.ChatSendAsUser)
+	if err != nil {
+		t.Fatalf("cannot send message with expired token: %v", err)
+	}
+	if len(env.kick.Messages()) != 1 {
+		t.Fatalf("got %d messages, want 1", len(env.kick.Messages()))
+	}
+
+	var refreshed int
+	for _, r := range env.kick.Requests() {
+		if r.Method == http.MethodPost && r.Path == "/oauth/token" && r.Status == http.StatusOK {
+			refreshed++
+		}
+	}
+	if refreshed != 1 {
+		t.Errorf("token is refreshed %d times, want 1", refreshed)
+	}
+
+	eventually(t, func() bool {
+		return env.auth.get("1").AccessToken != bot.AccessToken
+	}, "refreshed tokens are not sent to auth module")
+
+	// client built from outdated provider uses token cached in KV
+	env.kickManager.mu.Lock()
+	env.kickManager.remove("1")
+	env.kickManager.mu.Unlock()
+	_, err = env.kickService.AppSendChannelMessage(context.Background(), bot, "2", "hello", "", data.ChatSendAsUser)
+	if err != nil {
+		t.Fatalf("cannot send message with outdated provider: %v", err)
+	}
+}
+
+func TestKickManagerRequiresReauthOfRevokedUser(t *testing.T) {
+	env := newTestEnv(t)
+	bot := env.addUser(1, "bot")
+	env.addUser(2, "broadcaster")
+
+	env.kick.RevokeUser(1)
+
+	_, err := env.kickService.AppSendChannelMessage(context.Background(), bot, "2", "hello", "", data.ChatSendAsUser)
+	if err == nil {
+		t.Fatal("message is sent with revoked token")
+	}
+
+	eventually(t, func() bool {
+		return len(env.reauthRequired()) == 1
+	}, "reauth of revoked user is not requested")
+
+	// revoked client does not call kick until it gets new tokens
+	env.kick.Reset()
+	_, err = env.kickService.AppSendChannelMessage(context.Background(), bot, "2", "hello", "", data.ChatSendAsUser)
+	if err == nil {
+		t.Fatal("message is sent with revoked token")
+	}
+	if len(env.kick.Requests()) != 0 {
+		t.Errorf("revoked client called kick: %+v", env.kick.Requests())
+	}
+	var kickErr gokick.Error
+	if !errors.As(err, &kickErr) || kickErr.Code() != http.StatusUnauthorized {
+		t.Errorf("revoked client error is not kick 401: %v", err)
+	}
+	if len(env.reauthRequired()) != 1 {
+		t.Errorf("reauth is requested %d times, want 1", len(env.reauthRequired()))
+	}
+}
+
+func TestKickManagerReplacesClientWithNewTokens(t *testing.T) {
+	env := newTestEnv(t)
+	bot := env.addUser(1, "bot")
+	env.addUser(2, "broadcaster")
+
+	env.kick.RevokeUser(1)
+	_, err := env.kickService.AppSendChannelMessage(context.Background(), bot, "2", "hello", "", data.ChatSendAsUser)
+	if err == nil {
+		t.Fatal("message is sent with revoked token")
+	}
+
+	// user authorized again
+	reauthorized := env.addUser(1, "bot")
+
+	_, err = env.kickService.AppSendChannelMessage(context.Background(), reauthorized, "2", "hello", "", data.ChatSendAsUser)
+	if err != nil {
+		t.Fatalf("cannot send message with new tokens: %v", err)
+	}
+}
+
+func TestKickManagerKeepsTokenOnRefreshFailure(t *testing.T) {
+	env := newTestEnv(t)
+	bot := env.addUser(1, "bot")
+	env.addUser(2, "broadcaster")
+
+	env.kick.ExpireAccessToken(bot.AccessToken)
+	// only invalid_grant means the refresh token is dead
+	env.kick.Fail(http.MethodPost, "/oauth/token", http.StatusBadRequest, 1)
+
+	_, err := env.kickService.AppSendChannelMessage(context.Background(), bot, "2", "hello", "", data.ChatSendAsUser)
+	if err == nil {
+		t.Fatal("message is sent although token cannot be refreshed")
+	}
+
+	_, err = env.kickService.AppSendChannelMessage(context.Background(), bot, "2", "hello", "", data.ChatSendAsUser)
+	if err != nil {
+		t.Fatalf("client is revoked after failed refresh: %v", err)
+	}
+	if len(env.reauthRequired()) != 0 {
+		t.Errorf("reauth is requested after failed refresh")
+	}
+}
+
+func newCachingKickManager(t *testing.T, options KickManagerOptions) *KickManager {
+	t.Helper()
+
+	return NewKickManager(newKV(t, startNATS(t), "default-kick"), nil, kickfake.ClientID, kickfake.ClientSecret, options)
+}
+
+func kickProvider(providerUserID string) sharedData.AuthProvider {
+	return sharedData.AuthProvider{
+		Provider:       platform.Kick.String(),
+		ProviderUserID: providerUserID,
+		AccessToken:    "access-" + providerUserID,
+		RefreshToken:   "refresh-" + providerUserID,
+		UpdatedAt:      time.Now(),
+	}
+}
+
+func (hm *KickManager) cached(kickID string) bool {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	_, exists := hm.clients[kickID]
+	return exists
+}
+
+func TestKickManagerEvictsLeastRecentlyUsedClient(t *testing.T) {
+	ctx := context.Background()
+	hm := newCachingKickManager(t, KickManagerOptions{MaxClients: 2})
+
+	first := hm.GetByProvider(ctx, kickProvider("1"))
+	hm.GetByProvider(ctx, kickProvider("2"))
+	if hm.GetByProvider(ctx, kickProvider("1")) != first {
+		t.Fatal("cached client is not reused")
+	}
+
+	hm.GetByProvider(ctx, kickProvider("3"))
+
+	if hm.cached("2") {
+		t.Error("least recently used client is not evicted")
+	}
+	for _, kickID := range []string{"1", "3"} {
+		if !hm.cached(kickID) {
+			t.Errorf("client %s is evicted", kickID)
+		}
+	}
+}
+
+func TestKickManagerEvictsIdleClients(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	hm := newCachingKickManager(t, KickManagerOptions{ClientIdleTimeout: 500 * time.Millisecond})
+
+	hm.GetByProvider(ctx, kickProvider("idle"))
+	hm.GetByProvider(ctx, kickProvider("used"))
+
+	go hm.Janitor(ctx)
+
+	// janitor ticks every second at least
+	deadline := time.Now().Add(3 * time.Second)
+	for hm.cached("idle") && time.Now().Before(deadline) {
+		_, err := hm.GetByID(ctx, "used")
+		if err != nil {
+			t.Fatalf("used client is evicted: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if hm.cached("idle") {
+		t.Error("idle client is not evicted")
+	}
+	if !hm.cached("used") {
+		t.Error("used client is evicted")
+	}
+}
+
+// replica returns kick manager of other replica that shares KV and kick
+// with the env
+func (env *testEnv) replica(t *testing.T) (*KickManager, *KickService) {
+	t.Helper()
+
+	mb := connectNATS(t, env.ns)
+	kickManager := NewKickManager(newKV(t, mb, "kick-test"), sharedService.NewAuthModule(mb), kickfake.ClientID, kickfake.ClientSecret, KickManagerOptions{
+		APIBaseURL:  env.kick.URL,
+		AuthBaseURL: env.kick.URL,
+		HTTPClient:  env.kick.Client(),
+	})
+	kickService := NewKickService(kickManager, NewChatLimiterService(ChatLimiterOptions{}), KickServiceOptions{
+		MaxMessageLength: 500,
+		MaxMessageParts:  3,
+	})
+
+	return kickManager, kickService
+}
+
+func TestKickManagerReplicasRefreshTokenOnce(t *testing.T) {
+	env := newTestEnv(t)
+	bot := env.addUser(1, "bot")
+	env.addUser(2, "broadcaster")
+	replicaManager, replicaService := env.replica(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go env.kickManager.WatchUserTokens(ctx)
+	go replicaManager.WatchUserTokens(ctx)
+
+	// both replicas cache client with the token before it expires
+	for _, kickService := range []*KickService{env.kickService, replicaService} {
+		_, err := kickService.AppSendChannelMessage(ctx, bot, "2", "hello", "", data.ChatSendAsUser)
+		if err != nil {
+			t.Fatalf("cannot send message: %v", err)
+		}
+	}
+
+	env.kick.ExpireAccessToken(bot.AccessToken)
+	env.kick.Reset()
+
+	var wg sync.WaitGroup
+	for _, kickService := range []*KickService{env.kickService, replicaService} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := kickService.AppSendChannelMessage(ctx, bot, "2", "hello", "", data.ChatSendAsUser)
+			if err != nil {
+				t.Errorf("cannot send message with expired token: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var refreshed int
+	for _, r := range env.kick.Requests() {
+		if r.Method == http.MethodPost && r.Path == "/oauth/token" {
+			refreshed++
+		}
+	}
+	if refreshed != 1 {
+		t.Errorf("token is refreshed %d times, want once", refreshed)
+	}
+
+	clientToken := func(hm *KickManager) string {
+		hm.mu.Lock()
+		defer hm.mu.Unlock()
+
+		return hm.clients["1"].accessToken
+	}
+	token := clientToken(env.kickManager)
+	if token == bot.AccessToken {
+		t.Fatal("client keeps expired token")
+	}
+	if clientToken(replicaManager) != token {
+		t.Error("replicas use different tokens")
+	}
+}
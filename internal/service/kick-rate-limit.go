@@ -0,0 +1,69 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// rateLimitDefaultPause is used when kick responds with 429 without telling
+// when to retry
+const rateLimitDefaultPause = 5 * time.Second
+
+// rateLimitedUntil reports if response says that no more requests are allowed
+// and until when. It reads 429 status, Retry-After and X-RateLimit-* headers.
+func rateLimitedUntil(response *http.Response, now time.Time) (time.Time, bool) {
+	reset, hasReset := parseRateLimitReset(response.Header.Get("X-RateLimit-Reset"), now)
+
+	if response.StatusCode == http.StatusTooManyRequests {
+		if until, ok := parseRetryAfter(response.Header.Get("Retry-After"), now); ok {
+			return until, true
+		}
+		if hasReset {
+			return reset, true
+		}
+		return now.Add(rateLimitDefaultPause), true
+	}
+
+	if response.Header.Get("X-RateLimit-Remaining") == "0" && hasReset {
+		return reset, true
+	}
+
+	return time.Time{}, false
+}
+
+// parseRetryAfter parses seconds or HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return now.Add(time.Duration(seconds) * time.Second), true
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		return t, true
+	}
+
+	return time.Time{}, false
+}
+
+// parseRateLimitReset parses unix timestamp or seconds until reset
+func parseRateLimitReset(value string, now time.Time) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	reset, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || reset < 0 {
+		return time.Time{}, false
+	}
+
+	// values that big are timestamps, not durations
+	if reset > 1_000_000_000 {
+		return time.Unix(reset, 0), true
+	}
+
+	return now.Add(time.Duration(reset) * time.Second), true
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedUntil(t *testing.T) {
+	now := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	reset := now.Add(time.Minute)
+
+	tests := []struct {
+		name        string
+		status      int
+		header      map[string]string
+		wantLimited bool
+		wantUntil   time.Time
+	}{
+		{
+			name:   "ok response",
+			status: http.StatusOK,
+		},
+		{
+			name:        "retry after seconds",
+			status:      http.StatusTooManyRequests,
+			header:      map[string]string{"Retry-After": "3"},
+			wantLimited: true,
+			wantUntil:   now.Add(3 * time.Second),
+		},
+		{
+			name:        "retry after date",
+			status:      http.StatusTooManyRequests,
+			header:      map[string]string{"Retry-After": reset.Format(http.TimeFormat)},
+			wantLimited: true,
+			wantUntil:   reset,
+		},
+		{
+			name:        "retry after wins over reset",
+			status:      http.StatusTooManyRequests,
+			header:      map[string]string{"Retry-After": "3", "X-RateLimit-Reset": "10"},
+			wantLimited: true,
+			wantUntil:   now.Add(3 * time.Second),
+		},
+		{
+			name:        "invalid retry after falls back to reset",
+			status:      http.StatusTooManyRequests,
+			header:      map[string]string{"Retry-After": "soon", "X-RateLimit-Reset": "10"},
+			wantLimited: true,
+			wantUntil:   now.Add(10 * time.Second),
+		},
+		{
+			name:        "reset seconds",
+			status:      http.StatusTooManyRequests,
+			header:      map[string]string{"X-RateLimit-Reset": "10"},
+			wantLimited: true,
+			wantUntil:   now.Add(10 * time.Second),
+		},
+		{
+			name:        "reset timestamp",
+			status:      http.StatusTooManyRequests,
+			header:      map[string]string{"X-RateLimit-Reset": "1751371260"},
+			wantLimited: true,
+			wantUntil:   time.Unix(1751371260, 0),
+		},
+		{
+			name:        "too many requests without headers",
+			status:      http.StatusTooManyRequests,
+			wantLimited: true,
+			wantUntil:   now.Add(rateLimitDefaultPause),
+		},
+		{
+			name:        "no remaining requests",
+			status:      http.StatusOK,
+			header:      map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "10"},
+			wantLimited: true,
+			wantUntil:   now.Add(10 * time.Second),
+		},
+		{
+			name:   "remaining requests",
+			status: http.StatusOK,
+			header: map[string]string{"X-RateLimit-Remaining": "1", "X-RateLimit-Reset": "10"},
+		},
+		{
+			name:   "no remaining requests without reset",
+			status: http.StatusOK,
+			header: map[string]string{"X-RateLimit-Remaining": "0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := &http.Response{
+				StatusCode: tt.status,
+				Header:     make(http.Header),
+			}
+			for key, value := range tt.header {
+				response.Header.Set(key, value)
+			}
+
+			until, limited := rateLimitedUntil(response, now)
+			if limited != tt.wantLimited {
+				t.Fatalf("got limited %v, want %v", limited, tt.wantLimited)
+			}
+			if !until.Equal(tt.wantUntil) {
+				t.Errorf("got until %s, want %s", until, tt.wantUntil)
+			}
+		})
+	}
+}
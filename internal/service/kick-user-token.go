@@ -0,0 +1,482 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/arnokay/arnobot-kick/internal/data"
+	"github.com/arnokay/arnobot-shared/apperror"
+	sharedData "github.com/arnokay/arnobot-shared/data"
+	"github.com/arnokay/arnobot-shared/trace"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/scorfly/gokick"
+)
+
+const (
+	userTokenKeyPrefix     = "hm.art."
+	userTokenLockKeyPrefix = "hm.arl."
+
+	// userTokenLockTTL is how long lock is valid, lock of crashed replica
+	// can be taken after it
+	userTokenLockTTL = 30 * time.Second
+	// userTokenLockWait is how long replica waits for the lock
+	userTokenLockWait = 15 * time.Second
+	userTokenLockPoll = 200 * time.Millisecond
+)
+
+// ErrTokenRevoked is returned when kick rejected tokens of the user, further
+// requests of the user get 401 response with its message without calling kick
+var ErrTokenRevoked = apperror.New(apperror.CodeUnauthorized, "kick user token is revoked, reauth is required", nil)
+
+// userTokenLock is stored in KV under hm.arl.<provider>.<providerUserID>
+// while replica refreshes user token
+type userTokenLock struct {
+	Owner     string    `json:"owner"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func userTokenKey(provider, providerUserID string) string {
+	return userTokenKeyPrefix + provider + "." + providerUserID
+}
+
+func userTokenLockKey(provider, providerUserID string) string {
+	return userTokenLockKeyPrefix + provider + "." + providerUserID
+}
+
+// userToken returns the newest of provider tokens and tokens cached in KV.
+// If KV tokens are newer, auth module is updated with them.
+func (hm *KickManager) userToken(ctx context.Context, provider sharedData.AuthProvider) data.UserToken {
+	token := data.UserToken{
+		Version:        data.UserTokenVersion,
+		Provider:       provider.Provider,
+		ProviderUserID: provider.ProviderUserID,
+		AccessToken:    provider.AccessToken,
+		RefreshToken:   provider.RefreshToken,
+		UpdatedAt:      provider.UpdatedAt,
+	}
+
+	cached, _, err := hm.loadUserToken(ctx, provider.Provider, provider.ProviderUserID)
+	if err != nil {
+		if !errors.Is(err, apperror.ErrNotFound) {
+			hm.logger.WarnContext(ctx, "cannot load cached user token", "err", err, "providerUserID", provider.ProviderUserID)
+		}
+		return token
+	}
+
+	sameTokens := cached.AccessToken == token.AccessToken && cached.RefreshToken == token.RefreshToken
+	if sameTokens || !cached.UpdatedAt.After(token.UpdatedAt) {
+		return token
+	}
+
+	hm.logger.DebugContext(ctx, "using cached user token, it is newer than provider token", "providerUserID", provider.ProviderUserID)
+
+	err = hm.authModule.AuthProviderUpdateTokens(ctx, sharedData.AuthProviderUpdateTokens{
+		ID:           provider.ID,
+		AccessToken:  cached.AccessToken,
+		RefreshToken: cached.RefreshToken,
+	})
+	if err != nil {
+		hm.logger.ErrorContext(ctx, "failed to update tokens", "providerID", provider.ID, "providerUserID", provider.ProviderUserID)
+	}
+
+	return *cached
+}
+
+// loadUserToken returns token cached in KV and revision of its entry.
+// apperror.ErrNotFound is returned if there is no token or it is stored in
+// unknown format, revision is still returned for the latter.
+func (hm *KickManager) loadUserToken(ctx context.Context, provider, providerUserID string) (*data.UserToken, uint64, error) {
+	entry, err := hm.cache.Get(ctx, userTokenKey(provider, providerUserID))
+	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return nil, 0, apperror.ErrNotFound
+		}
+		return nil, 0, err
+	}
+
+	token, ok := decodeUserToken(entry.Value())
+	if !ok {
+		return nil, entry.Revision(), apperror.ErrNotFound
+	}
+
+	return token, entry.Revision(), nil
+}
+
+// old entries are "..." joined tokens without update time, they are ignored
+func decodeUserToken(value []byte) (*data.UserToken, bool) {
+	var token data.UserToken
+	if err := json.Unmarshal(value, &token); err != nil || token.Version != data.UserTokenVersion {
+		return nil, false
+	}
+
+	return &token, true
+}
+
+// saveUserToken writes token to KV if entry was not changed since revision,
+// 0 revision means there was no entry
+func (hm *KickManager) saveUserToken(ctx context.Context, token data.UserToken, revision uint64) error {
+	value, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	key := userTokenKey(token.Provider, token.ProviderUserID)
+	if revision == 0 {
+		_, err = hm.cache.Create(ctx, key, value)
+	} else {
+		_, err = hm.cache.Update(ctx, key, value, revision)
+	}
+
+	return err
+}
+
+// lockUserToken takes KV lock of the user token, so only one replica
+// refreshes it. Returned func releases the lock.
+func (hm *KickManager) lockUserToken(ctx context.Context, provider, providerUserID string) (func(), error) {
+	key := userTokenLockKey(provider, providerUserID)
+
+	ctx, cancel := context.WithTimeout(ctx, userTokenLockWait)
+	defer cancel()
+
+	for {
+		value, _ := json.Marshal(userTokenLock{
+			Owner:     hm.instanceID,
+			ExpiresAt: time.Now().Add(userTokenLockTTL),
+		})
+
+		revision, err := hm.cache.Create(ctx, key, value)
+		if err != nil && errors.Is(err, jetstream.ErrKeyExists) {
+			revision, err = hm.takeExpiredLock(ctx, key, value)
+		}
+		if err == nil {
+			return func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				err := hm.cache.Delete(ctx, key, jetstream.LastRevision(revision))
+				if err != nil {
+					hm.logger.WarnContext(ctx, "cannot release user token lock", "err", err, "key", key)
+				}
+			}, nil
+		}
+		if !errors.Is(err, jetstream.ErrKeyExists) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, apperror.New(apperror.CodeInternal, "user token is locked by other replica", ctx.Err())
+		case <-time.After(userTokenLockPoll):
+		}
+	}
+}
+
+// takeExpiredLock replaces lock of crashed replica, jetstream.ErrKeyExists
+// is returned if lock is still valid
+func (hm *KickManager) takeExpiredLock(ctx context.Context, key string, value []byte) (uint64, error) {
+	entry, err := hm.cache.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return hm.cache.Create(ctx, key, value)
+		}
+		return 0, err
+	}
+
+	var lock userTokenLock
+	if err := json.Unmarshal(entry.Value(), &lock); err == nil && time.Now().Before(lock.ExpiresAt) {
+		return 0, jetstream.ErrKeyExists
+	}
+
+	revision, err := hm.cache.Update(ctx, key, value, entry.Revision())
+	if err != nil {
+		// other replica took it first
+		return 0, jetstream.ErrKeyExists
+	}
+
+	return revision, nil
+}
+
+// refreshUserToken replaces access token rejected by kick. Token is taken from
+// KV if other replica already refreshed it, otherwise it is refreshed under
+// KV lock and written with compare-and-swap, so refresh token is used once.
+func (hm *KickManager) refreshUserToken(ctx context.Context, entry *kickClient, rejected string) (string, error) {
+	entry.refreshMu.Lock()
+	defer entry.refreshMu.Unlock()
+
+	provider := entry.provider
+
+	hm.mu.Lock()
+	accessToken, refreshToken, updatedAt := entry.accessToken, entry.refreshToken, entry.updatedAt
+	hm.mu.Unlock()
+
+	if accessToken != rejected {
+		return accessToken, nil
+	}
+
+	cached, _, err := hm.loadUserToken(ctx, provider.Provider, provider.ProviderUserID)
+	if err == nil && cached.AccessToken != rejected && cached.UpdatedAt.After(updatedAt) {
+		hm.adoptUserToken(entry, *cached)
+		return cached.AccessToken, nil
+	}
+
+	unlock, err := hm.lockUserToken(ctx, provider.Provider, provider.ProviderUserID)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	// other replica could refresh it while we were waiting for the lock
+	cached, revision, err := hm.loadUserToken(ctx, provider.Provider, provider.ProviderUserID)
+	if err == nil && cached.AccessToken != rejected && cached.UpdatedAt.After(updatedAt) {
+		hm.adoptUserToken(entry, *cached)
+		return cached.AccessToken, nil
+	}
+	if err != nil && !errors.Is(err, apperror.ErrNotFound) {
+		return "", err
+	}
+
+	response, err := hm.authClient.RefreshToken(ctx, refreshToken)
+	if err != nil {
+		hm.logger.ErrorContext(ctx, "cannot refresh user token", "err", err, "providerUserID", provider.ProviderUserID)
+		if isTokenRejected(err) {
+			hm.revokeUserToken(ctx, entry, "refresh token is rejected")
+			return "", ErrTokenRevoked
+		}
+		return "", err
+	}
+
+	token := data.UserToken{
+		Version:        data.UserTokenVersion,
+		Provider:       provider.Provider,
+		ProviderUserID: provider.ProviderUserID,
+		AccessToken:    response.AccessToken,
+		RefreshToken:   response.RefreshToken,
+		UpdatedAt:      time.Now(),
+	}
+
+	err = hm.saveUserToken(ctx, token, revision)
+	if err != nil {
+		hm.logger.ErrorContext(ctx, "cannot cache user token", "err", err, "providerUserID", provider.ProviderUserID)
+	}
+
+	hm.adoptUserToken(entry, token)
+	hm.logger.InfoContext(ctx, "token refreshed", "providerUserID", provider.ProviderUserID)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx = trace.Context(ctx, trace.New())
+		defer cancel()
+
+		err := hm.authModule.AuthProviderUpdateTokens(ctx, sharedData.AuthProviderUpdateTokens{
+			ID:           provider.ID,
+			AccessToken:  token.AccessToken,
+			RefreshToken: token.RefreshToken,
+		})
+		if err != nil {
+			hm.logger.ErrorContext(ctx, "failed to update tokens", "providerID", provider.ID, "providerUserID", provider.ProviderUserID)
+		}
+	}()
+
+	return token.AccessToken, nil
+}
+
+// isTokenRejected reports if kick auth rejected the refresh token. Only
+// invalid_grant means the token is dead, other errors (invalid_client,
+// invalid_request, network, 5xx) are our or kick problems and must not
+// disable bots.
+func isTokenRejected(err error) bool {
+	var kickErr gokick.Error
+	if !errors.As(err, &kickErr) {
+		return false
+	}
+
+	return kickErr.Code() == http.StatusBadRequest && kickErr.Message() == "invalid_grant"
+}
+
+// revokeUserToken marks client as revoked and calls reauth callback, it is
+// done once per client. Callback runs in background without deadline, it may
+// disable bots of many channels.
+func (hm *KickManager) revokeUserToken(ctx context.Context, entry *kickClient, reason string) {
+	hm.mu.Lock()
+	if entry.revoked {
+		hm.mu.Unlock()
+		return
+	}
+	entry.revoked = true
+	provider := entry.provider
+	hm.mu.Unlock()
+
+	hm.logger.WarnContext(ctx, "user token is revoked, reauth is required", "providerUserID", provider.ProviderUserID, "reason", reason)
+
+	if hm.onReauthRequired == nil {
+		return
+	}
+
+	go hm.onReauthRequired(trace.Context(context.Background(), trace.New()), provider, reason)
+}
+
+func (hm *KickManager) adoptUserToken(entry *kickClient, token data.UserToken) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	entry.accessToken = token.AccessToken
+	entry.refreshToken = token.RefreshToken
+	entry.updatedAt = token.UpdatedAt
+}
+
+// WatchUserTokens applies tokens refreshed by other replicas to cached
+// clients until ctx is done
+func (hm *KickManager) WatchUserTokens(ctx context.Context) {
+	watcher, err := hm.cache.Watch(ctx, userTokenKeyPrefix+">", jetstream.UpdatesOnly())
+	if err != nil {
+		hm.logger.ErrorContext(ctx, "cannot watch user tokens", "err", err)
+		return
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case kv, ok := <-watcher.Updates():
+			if !ok {
+				return
+			}
+			if kv == nil || kv.Operation() != jetstream.KeyValuePut {
+				continue
+			}
+
+			token, ok := decodeUserToken(kv.Value())
+			if !ok {
+				continue
+			}
+
+			hm.mu.Lock()
+			entry, exists := hm.clients[token.ProviderUserID]
+			if exists && entry.provider.Provider == token.Provider && token.UpdatedAt.After(entry.updatedAt) {
+				entry.accessToken = token.AccessToken
+				entry.refreshToken = token.RefreshToken
+				entry.updatedAt = token.UpdatedAt
+				hm.logger.DebugContext(ctx, "token updated by other replica", "providerUserID", token.ProviderUserID)
+			}
+			hm.mu.Unlock()
+		}
+	}
+}
+
+// userHTTPClient returns http client that authorizes requests of the client
+// with its current user token
+func (hm *KickManager) userHTTPClient(entry *kickClient) *http.Client {
+	client := &http.Client{}
+	if hm.options.HTTPClient != nil {
+		*client = *hm.options.HTTPClient
+	}
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = &userTokenTransport{base: base, hm: hm, entry: entry}
+
+	return client
+}
+
+// userTokenTransport authorizes kick api requests with user token and retries
+// request once with refreshed token if kick responds with 401
+type userTokenTransport struct {
+	base  http.RoundTripper
+	hm    *KickManager
+	entry *kickClient
+}
+
+func (t *userTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasPrefix(req.URL.Path, "/oauth/") {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+
+	t.hm.mu.Lock()
+	token, revoked := t.entry.accessToken, t.entry.revoked
+	t.hm.mu.Unlock()
+
+	if revoked {
+		return revokedResponse(req), nil
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response, err := t.base.RoundTrip(authorizeRequest(req, token, body))
+	if err != nil {
+		return response, err
+	}
+	t.observeRateLimit(response)
+	if response.StatusCode != http.StatusUnauthorized {
+		return response, nil
+	}
+
+	responseBody, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+
+	token, err = t.hm.refreshUserToken(ctx, t.entry, token)
+	if err != nil {
+		// kick response is returned, so caller sees 401
+		response.Body = io.NopCloser(bytes.NewReader(responseBody))
+		return response, nil
+	}
+
+	response, err = t.base.RoundTrip(authorizeRequest(req, token, body))
+	if err != nil {
+		return response, err
+	}
+	t.observeRateLimit(response)
+	if response.StatusCode == http.StatusUnauthorized {
+		t.hm.revokeUserToken(ctx, t.entry, "access token is rejected after refresh")
+	}
+
+	return response, nil
+}
+
+func (t *userTokenTransport) observeRateLimit(response *http.Response) {
+	if t.hm.onRateLimited == nil {
+		return
+	}
+
+	until, limited := rateLimitedUntil(response, time.Now())
+	if limited {
+		t.hm.onRateLimited(t.entry.provider.ProviderUserID, until)
+	}
+}
+
+// revokedResponse is returned instead of calling kick with revoked token,
+// gokick turns it into gokick.Error with 401 code like kick response
+func revokedResponse(req *http.Request) *http.Response {
+	body, _ := json.Marshal(map[string]any{
+		"message": ErrTokenRevoked.Message,
+		"data":    nil,
+	})
+
+	return &http.Response{
+		Status:        "401 Unauthorized",
+		StatusCode:    http.StatusUnauthorized,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
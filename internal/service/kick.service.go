@@ -2,57 +2,228 @@ package service
 
 import (
 	"context"
+	"errors"
+	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/arnokay/arnobot-shared/apperror"
 	"github.com/arnokay/arnobot-shared/applog"
-	"github.com/arnokay/arnobot-shared/data"
+	sharedData "github.com/arnokay/arnobot-shared/data"
 	"github.com/scorfly/gokick"
+
+	"github.com/arnokay/arnobot-kick/internal/data"
+)
+
+var (
+	ErrChatForbidden = apperror.New(apperror.CodeForbidden, "bot cannot send messages to the channel", nil)
+	ErrBotBanned     = apperror.New(apperror.CodeForbidden, "bot is banned in the channel", nil)
 )
 
+// appChatLimiterKey is bot limiter key of messages sent by our app, its
+// limit can be overridden like limit of any bot
+const appChatLimiterKey = "app"
+
+type KickServiceOptions struct {
+	// MaxMessageLength is max length of chat message, longer messages are split
+	MaxMessageLength int
+	// MaxMessageParts is max number of parts of split message, 0 is unlimited
+	MaxMessageParts int
+}
+
 type KickService struct {
 	kickManager *KickManager
+	chatLimiter *ChatLimiterService
+	options     KickServiceOptions
 	logger      applog.Logger
 }
 
 func NewKickService(
 	kickManager *KickManager,
+	chatLimiter *ChatLimiterService,
+	options KickServiceOptions,
 ) *KickService {
 	logger := applog.NewServiceLogger("kick-service")
 
 	return &KickService{
 		kickManager: kickManager,
+		chatLimiter: chatLimiter,
+		options:     options,
 		logger:      logger,
 	}
 }
 
+// AppSendChannelMessage sends message to the channel, long message is split
+// into parts. Messages sent as bot come from our app with app token, user
+// token of the bot is used if app cannot send them or sendAs is empty. Kick
+// ids of sent parts are returned.
 func (s *KickService) AppSendChannelMessage(
 	ctx context.Context,
-	botProvider data.AuthProvider,
+	botProvider sharedData.AuthProvider,
 	broadcasterID string,
 	message string,
 	replyTo string,
-) error {
-	client := s.kickManager.GetByProvider(ctx, botProvider)
+	sendAs data.ChatSendAs,
+) ([]string, error) {
+	return s.AppSendChannelMessageFrom(ctx, botProvider, broadcasterID, message, replyTo, sendAs, 0)
+}
+
+// AppSendChannelMessageFrom is AppSendChannelMessage that skips first sent
+// parts of split message, it continues sending that failed part way. Kick ids
+// of parts sent by this call are returned.
+func (s *KickService) AppSendChannelMessageFrom(
+	ctx context.Context,
+	botProvider sharedData.AuthProvider,
+	broadcasterID string,
+	message string,
+	replyTo string,
+	sendAs data.ChatSendAs,
+	sent int,
+) ([]string, error) {
 	bID, err := strconv.Atoi(broadcasterID)
 	if err != nil {
 		s.logger.ErrorContext(ctx, "cannot convert broadcasterID to int", "broadcaster_id", broadcasterID)
-		return apperror.ErrInvalidInput
+		return nil, apperror.ErrInvalidInput
 	}
 
-	_, err = client.SendChatMessage(ctx, &bID, message, &replyTo, gokick.MessageTypeUser)
+	parts, err := splitChatMessage(message, s.options.MaxMessageLength, s.options.MaxMessageParts)
 	if err != nil {
-		s.logger.ErrorContext(
+		s.logger.ErrorContext(ctx, "chat message is too long", "broadcasterID", broadcasterID, "length", len(message))
+		return nil, err
+	}
+	if sent >= len(parts) {
+		return nil, nil
+	}
+	parts = parts[sent:]
+
+	if sendAs == data.ChatSendAsBot {
+		messageIDs, err := s.sendAsBot(ctx, bID, parts, replyTo)
+		// parts that were sent already are not sent again from user account,
+		// nor is the rest of message sent from other account
+		if err == nil || len(messageIDs) > 0 || sent > 0 {
+			return messageIDs, err
+		}
+		s.logger.WarnContext(
 			ctx,
-			"cannot send message to chat",
+			"cannot send message as bot, sending as user",
 			"err", err,
 			"broadcasterID", broadcasterID,
 			"botID", botProvider.ProviderUserID,
-			"message", message,
-			"replyTo", replyTo,
 		)
-		return apperror.ErrExternal
 	}
 
-	return nil
+	client := s.kickManager.GetByProvider(ctx, botProvider)
+
+	return s.sendChatMessages(ctx, client, botProvider.ProviderUserID, bID, parts, replyTo, gokick.MessageTypeUser)
+}
+
+// sendAsBot sends parts with app client, app messages are limited as one bot
+func (s *KickService) sendAsBot(ctx context.Context, bID int, parts []string, replyTo string) ([]string, error) {
+	client, err := s.kickManager.GetApp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.sendChatMessages(ctx, client, appChatLimiterKey, bID, parts, replyTo, gokick.MessageTypeBot)
+}
+
+// sendChatMessages sends parts in order, each one waits for its turn
+func (s *KickService) sendChatMessages(
+	ctx context.Context,
+	client *gokick.Client,
+	limiterKey string,
+	bID int,
+	parts []string,
+	replyTo string,
+	messageType gokick.MessageType,
+) ([]string, error) {
+	messageIDs := make([]string, 0, len(parts))
+	for _, part := range parts {
+		messageID, err := s.sendChatMessage(ctx, client, limiterKey, bID, part, replyTo, messageType)
+		if err != nil {
+			s.logger.ErrorContext(
+				ctx,
+				"cannot send message to chat",
+				"err", err,
+				"broadcasterID", bID,
+				"botID", limiterKey,
+				"messageType", messageType,
+				"message", part,
+				"replyTo", replyTo,
+			)
+			return messageIDs, err
+		}
+		messageIDs = append(messageIDs, messageID)
+	}
+
+	return messageIDs, nil
+}
+
+// sendChatMessage sends one message respecting rate limits, kick message id is returned
+func (s *KickService) sendChatMessage(
+	ctx context.Context,
+	client *gokick.Client,
+	limiterKey string,
+	bID int,
+	message string,
+	replyTo string,
+	messageType gokick.MessageType,
+) (string, error) {
+	broadcasterID := strconv.Itoa(bID)
+
+	err := s.chatLimiter.Wait(ctx, limiterKey, broadcasterID)
+	if err != nil {
+		return "", err
+	}
+
+	// message is not a reply without reply pointer
+	var replyToMessageID *string
+	if replyTo != "" {
+		replyToMessageID = &replyTo
+	}
+
+	response, err := client.SendChatMessage(ctx, &bID, message, replyToMessageID, messageType)
+	if isKickRateLimited(err) {
+		// limiter is paused by kick manager, so message waits for the reset
+		err = s.chatLimiter.Wait(ctx, limiterKey, broadcasterID)
+		if err != nil {
+			return "", err
+		}
+		response, err = client.SendChatMessage(ctx, &bID, message, replyToMessageID, messageType)
+	}
+	if err != nil {
+		return "", chatSendError(err)
+	}
+
+	return response.Result.MessageID, nil
+}
+
+// chatSendError converts kick error to typed error
+func chatSendError(err error) error {
+	var kickErr gokick.Error
+	if !errors.As(err, &kickErr) {
+		return apperror.New(apperror.CodeExternal, apperror.ErrExternal.Message, err)
+	}
+
+	switch kickErr.Code() {
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusForbidden:
+		reason := strings.ToLower(kickErr.Message() + " " + kickErr.Description())
+		if strings.Contains(reason, "ban") {
+			return ErrBotBanned
+		}
+		return ErrChatForbidden
+	default:
+		return apperror.New(apperror.CodeExternal, apperror.ErrExternal.Message, err)
+	}
+}
+
+func isKickRateLimited(err error) bool {
+	var kickErr gokick.Error
+	if !errors.As(err, &kickErr) {
+		return false
+	}
+
+	return kickErr.Code() == http.StatusTooManyRequests
 }
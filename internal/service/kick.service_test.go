@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scorfly/gokick"
+
+	"github.com/arnokay/arnobot-kick/internal/data"
+)
+
+func TestKickServiceSendsMessageAsUser(t *testing.T) {
+	env := newTestEnv(t)
+	bot := env.addUser(1, "bot")
+	env.addUser(2, "broadcaster")
+
+	ids, err := env.kickService.AppSendChannelMessage(context.Background(), bot, "2", "hello", "msg-0", data.ChatSendAsUser)
+	if err != nil {
+		t.Fatalf("cannot send message: %v", err)
+	}
+
+	messages := env.kick.Messages()
+	if len(messages) != 1 || len(ids) != 1 {
+		t.Fatalf("got %d messages and %d ids, want 1", len(messages), len(ids))
+	}
+
+	message := messages[0]
+	if message.MessageID != ids[0] {
+		t.Errorf("returned id %q, kick message id %q", ids[0], message.MessageID)
+	}
+	if message.Content != "hello" || message.BroadcasterUserID != 2 || message.SenderUserID != 1 {
+		t.Errorf("unexpected message %+v", message)
+	}
+	if message.Type != gokick.MessageTypeUser.String() || message.ReplyToMessageID != "msg-0" {
+		t.Errorf("unexpected message %+v", message)
+	}
+}
+
+func TestKickServiceSendsMessageAsBot(t *testing.T) {
+	env := newTestEnv(t)
+	bot := env.addUser(1, "bot")
+	env.addUser(2, "broadcaster")
+
+	_, err := env.kickService.AppSendChannelMessage(context.Background(), bot, "2", "hello", "", data.ChatSendAsBot)
+	if err != nil {
+		t.Fatalf("cannot send message: %v", err)
+	}
+
+	messages := env.kick.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	// app token has no user
+	if messages[0].SenderUserID != 0 || messages[0].Type != gokick.MessageTypeBot.String() {
+		t.Errorf("message is not sent with app token: %+v", messages[0])
+	}
+}
+
+func TestKickServiceSplitsLongMessage(t *testing.T) {
+	env := newTestEnv(t)
+	bot := env.addUser(1, "bot")
+	env.addUser(2, "broadcaster")
+
+	message := strings.TrimSpace(strings.Repeat("word ", 150))
+
+	ids, err := env.kickService.AppSendChannelMessage(context.Background(), bot, "2", message, "msg-0", data.ChatSendAsUser)
+	if err != nil {
+		t.Fatalf("cannot send message: %v", err)
+	}
+
+	messages := env.kick.Messages()
+	if len(messages) != 2 || len(ids) != 2 {
+		t.Fatalf("got %d messages and %d ids, want 2", len(messages), len(ids))
+	}
+
+	var parts []string
+	for i, m := range messages {
+		if m.MessageID != ids[i] {
+			t.Errorf("part %d: returned id %q, kick message id %q", i, ids[i], m.MessageID)
+		}
+		if m.ReplyToMessageID != "msg-0" {
+			t.Errorf("part %d is not a reply", i)
+		}
+		parts = append(parts, m.Content)
+	}
+	if strings.Join(parts, " ") != message {
+		t.Errorf("parts do not make up the message: %q", parts)
+	}
+}
+
+func TestKickServiceRejectsMessageOverPartsLimit(t *testing.T) {
+	env := newTestEnv(t)
+	bot := env.addUser(1, "bot")
+	env.addUser(2, "broadcaster")
+
+	message := strings.Repeat("word ", 400)
+
+	_, err := env.kickService.AppSendChannelMessage(context.Background(), bot, "2", message, "", data.ChatSendAsUser)
+	if err == nil {
+		t.Fatal("message over parts limit is sent")
+	}
+	if len(env.kick.Messages()) != 0 {
+		t.Errorf("parts of message over parts limit are sent")
+	}
+}
+
+func TestKickServiceRetriesRateLimitedMessage(t *testing.T) {
+	env := newTestEnv(t)
+	bot := env.addUser(1, "bot")
+	env.addUser(2, "broadcaster")
+
+	env.kick.FailWithRetryAfter(http.MethodPost, "/public/v1/chat", http.StatusTooManyRequests, 1, time.Second)
+
+	start := time.Now()
+	_, err := env.kickService.AppSendChannelMessage(context.Background(), bot, "2", "hello", "", data.ChatSendAsUser)
+	if err != nil {
+		t.Fatalf("rate limited message is not retried: %v", err)
+	}
+	// bot is paused until Retry-After
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("message is retried after %s, before Retry-After", elapsed)
+	}
+	if len(env.kick.Messages()) != 1 {
+		t.Errorf("got %d messages, want 1", len(env.kick.Messages()))
+	}
+}
+
+func TestKickServiceSendsRestOfMessage(t *testing.T) {
+	env := newTestEnv(t)
+	bot := env.addUser(1, "bot")
+	env.addUser(2, "broadcaster")
+
+	message := strings.TrimSpace(strings.Repeat("word ", 250))
+
+	ids, err := env.kickService.AppSendChannelMessageFrom(context.Background(), bot, "2", message, "", data.ChatSendAsUser, 1)
+	if err != nil {
+		t.Fatalf("cannot send rest of message: %v", err)
+	}
+
+	messages := env.kick.Messages()
+	if len(messages) != 2 || len(ids) != 2 {
+		t.Fatalf("got %d messages and %d ids, want 2", len(messages), len(ids))
+	}
+	if !strings.HasSuffix(message, messages[0].Content+" "+messages[1].Content) {
+		t.Errorf("sent parts are not the rest of message: %q, %q", messages[0].Content, messages[1].Content)
+	}
+
+	// message that was sent whole is not sent again
+	ids, err = env.kickService.AppSendChannelMessageFrom(context.Background(), bot, "2", message, "", data.ChatSendAsUser, 3)
+	if err != nil || len(ids) != 0 {
+		t.Fatalf("sent message is sent again: %v, %v", ids, err)
+	}
+	if len(env.kick.Messages()) != 2 {
+		t.Errorf("got %d messages, want 2", len(env.kick.Messages()))
+	}
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/arnokay/arnobot-shared/applog"
+
+	"github.com/arnokay/arnobot-kick/internal/data"
+	"github.com/arnokay/arnobot-kick/internal/db"
+	"github.com/arnokay/arnobot-kick/internal/storage"
+)
+
+type ModerationService struct {
+	storage storage.Storager
+
+	logger applog.Logger
+}
+
+func NewModerationService(
+	store storage.Storager,
+) *ModerationService {
+	logger := applog.NewServiceLogger("moderation-service")
+
+	return &ModerationService{
+		storage: store,
+		logger:  logger,
+	}
+}
+
+func (s *ModerationService) ModerationBanCreate(ctx context.Context, arg data.ModerationBanCreate) (data.ModerationBan, error) {
+	createdAt := arg.CreatedAt.UTC()
+	if arg.CreatedAt.IsZero() {
+		createdAt = time.Now().UTC()
+	}
+	var expiresAt *time.Time
+	if arg.ExpiresAt != nil {
+		t := arg.ExpiresAt.UTC()
+		expiresAt = &t
+	}
+
+	fromDB, err := s.storage.KickQuery(ctx).KickModerationBanUpsert(ctx, db.KickModerationBanUpsertParams{
+		BroadcasterID: arg.BroadcasterID,
+		UserID:        arg.UserID,
+		UserName:      arg.UserName,
+		ModeratorID:   arg.ModeratorID,
+		ModeratorName: arg.ModeratorName,
+		Reason:        arg.Reason,
+		CreatedAt:     createdAt,
+		ExpiresAt:     expiresAt,
+	})
+	if err != nil {
+		s.logger.DebugContext(ctx, "cannot create moderation ban", "err", err, "broadcasterID", arg.BroadcasterID)
+		return data.ModerationBan{}, s.storage.HandleErr(ctx, err)
+	}
+
+	return data.NewModerationBanFromDB(fromDB), nil
+}
+
+// ModerationBansGet returns permanent bans and timeouts that did not expire yet
+func (s *ModerationService) ModerationBansGet(ctx context.Context, arg data.ModerationBansGet) ([]data.ModerationBan, error) {
+	fromDB, err := s.storage.KickQuery(ctx).KickModerationBansGetActive(ctx, db.KickModerationBansGetActiveParams{
+		BroadcasterID: arg.BroadcasterID,
+		Now:           time.Now().UTC(),
+	})
+	if err != nil {
+		s.logger.DebugContext(ctx, "cannot get moderation bans", "err", err, "broadcasterID", arg.BroadcasterID)
+		return nil, s.storage.HandleErr(ctx, err)
+	}
+
+	bans := make([]data.ModerationBan, 0, len(fromDB))
+	for _, ban := range fromDB {
+		bans = append(bans, data.NewModerationBanFromDB(ban))
+	}
+
+	return bans, nil
+}
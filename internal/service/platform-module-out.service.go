@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+
+	"github.com/arnokay/arnobot-shared/applog"
+	sharedService "github.com/arnokay/arnobot-shared/service"
+	sharedTopics "github.com/arnokay/arnobot-shared/topics"
+	"github.com/nats-io/nats.go"
+
+	"github.com/arnokay/arnobot-kick/internal/events"
+	"github.com/arnokay/arnobot-kick/internal/topics"
+)
+
+// PlatformModuleOut extends shared PlatformModuleOut with kick events
+// that are not (yet) part of the shared module
+type PlatformModuleOut struct {
+	*sharedService.PlatformModuleOut
+
+	mb     *nats.Conn
+	logger applog.Logger
+}
+
+func NewPlatformModuleOut(mb *nats.Conn) *PlatformModuleOut {
+	logger := applog.NewServiceLogger("kick-platform-module-out")
+
+	return &PlatformModuleOut{
+		PlatformModuleOut: sharedService.NewPlatformModuleOut(mb),
+		mb:                mb,
+		logger:            logger,
+	}
+}
+
+// ChatMessageNotify replaces shared ChatMessageNotify, message is published
+// to the same topic with reply details
+func (s *PlatformModuleOut) ChatMessageNotify(ctx context.Context, arg events.Message) error {
+	topic := sharedTopics.TopicBuilder(sharedTopics.PlatformBroadcasterChatMessageNotify).
+		Platform(arg.Platform).
+		BroadcasterID(arg.BroadcasterID).
+		Build()
+
+	return sharedService.HandlePublish(ctx, s.mb, s.logger, topic, arg)
+}
+
+func (s *PlatformModuleOut) ChannelFollowNotify(ctx context.Context, arg events.Follow) error {
+	topic := sharedTopics.TopicBuilder(topics.PlatformBroadcasterChannelFollowNotify).
+		Platform(arg.Platform).
+		BroadcasterID(arg.BroadcasterID).
+		Build()
+
+	return sharedService.HandlePublish(ctx, s.mb, s.logger, topic, arg)
+}
+
+func (s *PlatformModuleOut) ChannelSubscriptionNotify(ctx context.Context, arg events.Subscription) error {
+	topic := sharedTopics.TopicBuilder(topics.PlatformBroadcasterChannelSubscriptionNotify).
+		Platform(arg.Platform).
+		BroadcasterID(arg.BroadcasterID).
+		Build()
+
+	return sharedService.HandlePublish(ctx, s.mb, s.logger, topic, arg)
+}
+
+func (s *PlatformModuleOut) ChannelSubscriptionGiftNotify(ctx context.Context, arg events.SubscriptionGift) error {
+	topic := sharedTopics.TopicBuilder(topics.PlatformBroadcasterChannelSubscriptionGiftNotify).
+		Platform(arg.Platform).
+		BroadcasterID(arg.BroadcasterID).
+		Build()
+
+	return sharedService.HandlePublish(ctx, s.mb, s.logger, topic, arg)
+}
+
+func (s *PlatformModuleOut) StreamOnlineNotify(ctx context.Context, arg events.StreamOnline) error {
+	topic := sharedTopics.TopicBuilder(topics.PlatformBroadcasterStreamOnlineNotify).
+		Platform(arg.Platform).
+		BroadcasterID(arg.BroadcasterID).
+		Build()
+
+	return sharedService.HandlePublish(ctx, s.mb, s.logger, topic, arg)
+}
+
+func (s *PlatformModuleOut) StreamOfflineNotify(ctx context.Context, arg events.StreamOffline) error {
+	topic := sharedTopics.TopicBuilder(topics.PlatformBroadcasterStreamOfflineNotify).
+		Platform(arg.Platform).
+		BroadcasterID(arg.BroadcasterID).
+		Build()
+
+	return sharedService.HandlePublish(ctx, s.mb, s.logger, topic, arg)
+}
+
+func (s *PlatformModuleOut) StreamMetadataNotify(ctx context.Context, arg events.StreamMetadataChange) error {
+	topic := sharedTopics.TopicBuilder(topics.PlatformBroadcasterStreamMetadataNotify).
+		Platform(arg.Platform).
+		BroadcasterID(arg.BroadcasterID).
+		Build()
+
+	return sharedService.HandlePublish(ctx, s.mb, s.logger, topic, arg)
+}
+
+func (s *PlatformModuleOut) ModerationBanNotify(ctx context.Context, arg events.ModerationBan) error {
+	topic := sharedTopics.TopicBuilder(topics.PlatformBroadcasterModerationBanNotify).
+		Platform(arg.Platform).
+		BroadcasterID(arg.BroadcasterID).
+		Build()
+
+	return sharedService.HandlePublish(ctx, s.mb, s.logger, topic, arg)
+}
+
+func (s *PlatformModuleOut) ReauthRequiredNotify(ctx context.Context, arg events.ReauthRequired) error {
+	topic := sharedTopics.TopicBuilder(topics.PlatformBroadcasterReauthRequiredNotify).
+		Platform(arg.Platform).
+		BroadcasterID(arg.BroadcasterID).
+		Build()
+
+	return sharedService.HandlePublish(ctx, s.mb, s.logger, topic, arg)
+}
+
+func (s *PlatformModuleOut) ChatMessageSentNotify(ctx context.Context, arg events.MessageSent) error {
+	topic := sharedTopics.TopicBuilder(topics.PlatformBroadcasterChatMessageSentNotify).
+		Platform(arg.Platform).
+		BroadcasterID(arg.BroadcasterID).
+		Build()
+
+	return sharedService.HandlePublish(ctx, s.mb, s.logger, topic, arg)
+}
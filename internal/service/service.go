@@ -5,11 +5,19 @@ import (
 )
 
 type Services struct {
-	AuthModule         *service.AuthModule
-	PlatformModule     *service.PlatformModuleOut
-	KickManager        *KickManager
-	BotService         *BotService
-	WebhookService     *WebhookService
-	KickService      *KickService
-	TransactionService service.ITransactionService
+	AuthModule               *AuthModule
+	PlatformModule           *PlatformModuleOut
+	KickManager              *KickManager
+	BotService               *BotService
+	WebhookService           *WebhookService
+	KickService              *KickService
+	StreamService            *StreamService
+	ModerationService        *ModerationService
+	WebhookDedupService      *WebhookDedupService
+	WebhookKeyService        *WebhookKeyService
+	WebhookReconcilerService *WebhookReconcilerService
+	ChatLimiterService       *ChatLimiterService
+	ChatSettingsService      *ChatSettingsService
+	ChatMessageCacheService  *ChatMessageCacheService
+	TransactionService       service.ITransactionService
 }
@@ -0,0 +1,520 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arnokay/arnobot-shared/apperror"
+	"github.com/arnokay/arnobot-shared/apptype"
+	sharedData "github.com/arnokay/arnobot-shared/data"
+	sharedDB "github.com/arnokay/arnobot-shared/db"
+	"github.com/arnokay/arnobot-shared/platform"
+	sharedTopics "github.com/arnokay/arnobot-shared/topics"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/arnokay/arnobot-kick/internal/config"
+	"github.com/arnokay/arnobot-kick/internal/db"
+	"github.com/arnokay/arnobot-kick/internal/kickfake"
+)
+
+func TestMain(m *testing.M) {
+	// webhook service reads callback url from config
+	config.Load()
+
+	os.Exit(m.Run())
+}
+
+// testEnv is kick module wired to kickfake, in-process NATS and in-memory
+// storage, nothing leaves the test process
+type testEnv struct {
+	kick  *kickfake.Server
+	ns    *server.Server
+	mb    *nats.Conn
+	auth  *fakeAuth
+	store *fakeStore
+
+	authModule  *AuthModule
+	kickManager *KickManager
+	chatLimiter *ChatLimiterService
+	kickService *KickService
+	whService   *WebhookService
+	botService  *BotService
+
+	mu sync.Mutex
+	// reauth are providers kick manager required reauth of
+	reauth []sharedData.AuthProvider
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	kick := kickfake.New()
+	t.Cleanup(kick.Close)
+
+	ns := startNATSServer(t)
+	mb := connectNATS(t, ns)
+	cache := newKV(t, mb, "kick-test")
+
+	env := &testEnv{
+		kick:  kick,
+		ns:    ns,
+		mb:    mb,
+		auth:  newFakeAuth(t, mb),
+		store: newFakeStore(),
+	}
+
+	env.authModule = NewAuthModule(mb)
+	env.kickManager = NewKickManager(cache, env.authModule.AuthModule, kickfake.ClientID, kickfake.ClientSecret, KickManagerOptions{
+		APIBaseURL:  kick.URL,
+		AuthBaseURL: kick.URL,
+		HTTPClient:  kick.Client(),
+	})
+	env.chatLimiter = NewChatLimiterService(ChatLimiterOptions{})
+	env.kickManager.OnRateLimited(env.chatLimiter.PauseBot)
+	env.kickService = NewKickService(env.kickManager, env.chatLimiter, KickServiceOptions{
+		MaxMessageLength: 500,
+		MaxMessageParts:  3,
+	})
+	env.whService = NewWebhookService(env.store, env.authModule.AuthModule, env.kickManager, env.kickService)
+	env.botService = NewBotService(
+		env.store,
+		fakeTx{},
+		env.authModule,
+		env.whService,
+		env.kickService,
+		NewPlatformModuleOut(mb),
+	)
+	env.kickManager.OnReauthRequired(func(ctx context.Context, provider sharedData.AuthProvider, reason string) {
+		env.mu.Lock()
+		env.reauth = append(env.reauth, provider)
+		env.mu.Unlock()
+
+		env.botService.HandleReauthRequired(ctx, provider, reason)
+	})
+
+	return env
+}
+
+// addUser adds kick user to kickfake and its provider to auth module
+func (env *testEnv) addUser(userID int, name string) sharedData.AuthProvider {
+	accessToken, refreshToken := env.kick.AddUser(userID, name)
+
+	provider := sharedData.AuthProvider{
+		ID:             int32(userID),
+		UserID:         uuid.New(),
+		Provider:       platform.Kick.String(),
+		ProviderUserID: strconv.Itoa(userID),
+		AccessToken:    accessToken,
+		RefreshToken:   refreshToken,
+		UpdatedAt:      time.Now(),
+	}
+	env.auth.add(provider)
+
+	return provider
+}
+
+func (env *testEnv) reauthRequired() []sharedData.AuthProvider {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	return slices.Clone(env.reauth)
+}
+
+// eventually fails the test if condition is not met within few seconds,
+// it is used for work kick manager does in background
+func eventually(t *testing.T, condition func() bool, message string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func startNATS(t *testing.T) *nats.Conn {
+	t.Helper()
+
+	return connectNATS(t, startNATSServer(t))
+}
+
+func startNATSServer(t *testing.T) *server.Server {
+	t.Helper()
+
+	ns, err := server.NewServer(&server.Options{
+		DontListen: true,
+		JetStream:  true,
+		StoreDir:   t.TempDir(),
+		NoLog:      true,
+		NoSigs:     true,
+	})
+	if err != nil {
+		t.Fatalf("cannot create nats server: %v", err)
+	}
+	ns.Start()
+	t.Cleanup(ns.Shutdown)
+
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server is not ready")
+	}
+
+	return ns
+}
+
+// connectNATS opens new connection to the server, connections of the same
+// server act as replicas
+func connectNATS(t *testing.T, ns *server.Server) *nats.Conn {
+	t.Helper()
+
+	mb, err := nats.Connect("", nats.InProcessServer(ns))
+	if err != nil {
+		t.Fatalf("cannot connect to nats server: %v", err)
+	}
+	t.Cleanup(mb.Close)
+
+	return mb
+}
+
+func newKV(t *testing.T, mb *nats.Conn, bucket string) jetstream.KeyValue {
+	t.Helper()
+
+	js, err := jetstream.New(mb)
+	if err != nil {
+		t.Fatalf("cannot create jetstream: %v", err)
+	}
+
+	kv, err := js.CreateKeyValue(context.Background(), jetstream.KeyValueConfig{Bucket: bucket})
+	if err != nil {
+		t.Fatalf("cannot create kv: %v", err)
+	}
+
+	return kv
+}
+
+// fakeAuth answers auth module requests from providers it holds
+type fakeAuth struct {
+	mu        sync.Mutex
+	providers map[string]sharedData.AuthProvider
+}
+
+func newFakeAuth(t *testing.T, mb *nats.Conn) *fakeAuth {
+	t.Helper()
+
+	a := &fakeAuth{
+		providers: make(map[string]sharedData.AuthProvider),
+	}
+
+	subs := []struct {
+		topic   string
+		handler nats.MsgHandler
+	}{
+		{sharedTopics.AuthProviderTokenGet, a.handleGet},
+		{sharedTopics.AuthProviderTokenUpdateTokens, a.handleUpdateTokens},
+	}
+	for _, sub := range subs {
+		_, err := mb.Subscribe(sub.topic, sub.handler)
+		if err != nil {
+			t.Fatalf("cannot subscribe to %s: %v", sub.topic, err)
+		}
+	}
+
+	return a
+}
+
+func (a *fakeAuth) add(provider sharedData.AuthProvider) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.providers[provider.ProviderUserID] = provider
+}
+
+func (a *fakeAuth) get(providerUserID string) sharedData.AuthProvider {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.providers[providerUserID]
+}
+
+func (a *fakeAuth) handleGet(msg *nats.Msg) {
+	var req apptype.Request[sharedData.AuthProviderGet]
+	req.Decode(msg.Data)
+
+	var res apptype.Response[*sharedData.AuthProvider]
+	res.ToFail(apperror.CodeNotFound, "provider is not found")
+
+	a.mu.Lock()
+	for _, provider := range a.providers {
+		if req.Data.ProviderUserID != nil && *req.Data.ProviderUserID != provider.ProviderUserID {
+			continue
+		}
+		if req.Data.UserID != nil && *req.Data.UserID != provider.UserID {
+			continue
+		}
+		res.ToSuccess(&provider)
+		break
+	}
+	a.mu.Unlock()
+
+	b, _ := res.Encode()
+	msg.Respond(b)
+}
+
+func (a *fakeAuth) handleUpdateTokens(msg *nats.Msg) {
+	var req apptype.Request[sharedData.AuthProviderUpdateTokens]
+	req.Decode(msg.Data)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for id, provider := range a.providers {
+		if provider.ID != req.Data.ID {
+			continue
+		}
+		provider.AccessToken = req.Data.AccessToken
+		provider.RefreshToken = req.Data.RefreshToken
+		provider.UpdatedAt = time.Now()
+		a.providers[id] = provider
+	}
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Begin(ctx context.Context) (context.Context, error) { return ctx, nil }
+func (fakeTx) Commit(ctx context.Context) error                   { return nil }
+func (fakeTx) Rollback(ctx context.Context) error                 { return nil }
+
+// fakeStore is in-memory storage of bots and webhook subscriptions, queries
+// the tests do not need panic
+type fakeStore struct {
+	mu            sync.Mutex
+	defaultBotID  string
+	bots          []sharedDB.KickBot
+	selectedBots  map[uuid.UUID]sharedDB.KickSelectedBot
+	subscriptions []db.KickWebhookSubscription
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		selectedBots: make(map[uuid.UUID]sharedDB.KickSelectedBot),
+	}
+}
+
+func (s *fakeStore) Query(ctx context.Context) sharedDB.Querier {
+	return &fakeQueries{store: s}
+}
+
+func (s *fakeStore) KickQuery(ctx context.Context) db.Querier {
+	return &fakeKickQueries{store: s}
+}
+
+func (s *fakeStore) Database(ctx context.Context) sharedDB.DBTX {
+	return nil
+}
+
+func (s *fakeStore) HandleErr(ctx context.Context, err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apperror.ErrNotFound
+	}
+
+	return err
+}
+
+func (s *fakeStore) selectedBot(userID uuid.UUID) (sharedDB.KickSelectedBot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bot, ok := s.selectedBots[userID]
+	return bot, ok
+}
+
+func (s *fakeStore) storedSubscriptions(broadcasterID string) []db.KickWebhookSubscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var subs []db.KickWebhookSubscription
+	for _, sub := range s.subscriptions {
+		if sub.BroadcasterID == broadcasterID {
+			subs = append(subs, sub)
+		}
+	}
+
+	return subs
+}
+
+type fakeQueries struct {
+	sharedDB.Querier
+	store *fakeStore
+}
+
+func (q *fakeQueries) KickDefaultBotGet(ctx context.Context) (sharedDB.KickDefaultBot, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	if q.store.defaultBotID == "" {
+		return sharedDB.KickDefaultBot{}, pgx.ErrNoRows
+	}
+
+	return sharedDB.KickDefaultBot{Main: true, BotID: q.store.defaultBotID}, nil
+}
+
+func (q *fakeQueries) KickBotCreate(ctx context.Context, arg sharedDB.KickBotCreateParams) (sharedDB.KickBot, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	bot := sharedDB.KickBot{
+		UserID:        arg.UserID,
+		BroadcasterID: arg.BroadcasterID,
+		BotID:         arg.BotID,
+	}
+	q.store.bots = append(q.store.bots, bot)
+
+	return bot, nil
+}
+
+func (q *fakeQueries) KickBotsGet(ctx context.Context, arg sharedDB.KickBotsGetParams) ([]sharedDB.KickBot, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	var bots []sharedDB.KickBot
+	for _, bot := range q.store.bots {
+		if arg.UserID != nil && *arg.UserID != bot.UserID {
+			continue
+		}
+		if arg.BroadcasterID != nil && *arg.BroadcasterID != bot.BroadcasterID {
+			continue
+		}
+		if arg.BotID != nil && *arg.BotID != bot.BotID {
+			continue
+		}
+		bots = append(bots, bot)
+	}
+
+	return bots, nil
+}
+
+func (q *fakeQueries) KickSelectedBotChange(ctx context.Context, arg sharedDB.KickSelectedBotChangeParams) (sharedDB.KickSelectedBot, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	bot := sharedDB.KickSelectedBot{
+		UserID:        arg.UserID,
+		BroadcasterID: arg.BroadcasterID,
+		BotID:         arg.BotID,
+		Enabled:       arg.Enabled,
+		UpdatedAt:     time.Now(),
+	}
+	q.store.selectedBots[arg.UserID] = bot
+
+	return bot, nil
+}
+
+func (q *fakeQueries) KickSelectedBotGetByUserID(ctx context.Context, userID uuid.UUID) (sharedDB.KickSelectedBot, error) {
+	bot, ok := q.store.selectedBot(userID)
+	if !ok {
+		return sharedDB.KickSelectedBot{}, pgx.ErrNoRows
+	}
+
+	return bot, nil
+}
+
+func (q *fakeQueries) KickSelectedBotGetByBroadcasterID(ctx context.Context, broadcasterID string) (sharedDB.KickSelectedBot, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	for _, bot := range q.store.selectedBots {
+		if bot.BroadcasterID == broadcasterID {
+			return bot, nil
+		}
+	}
+
+	return sharedDB.KickSelectedBot{}, pgx.ErrNoRows
+}
+
+func (q *fakeQueries) KickSelectedBotStatusChange(ctx context.Context, arg sharedDB.KickSelectedBotStatusChangeParams) (sharedDB.KickSelectedBot, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	bot, ok := q.store.selectedBots[arg.UserID]
+	if !ok {
+		return sharedDB.KickSelectedBot{}, pgx.ErrNoRows
+	}
+	bot.Enabled = arg.Enabled
+	bot.UpdatedAt = time.Now()
+	q.store.selectedBots[arg.UserID] = bot
+
+	return bot, nil
+}
+
+type fakeKickQueries struct {
+	db.Querier
+	store *fakeStore
+}
+
+func (q *fakeKickQueries) KickSelectedBotsGet(ctx context.Context) ([]db.KickSelectedBot, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	var bots []db.KickSelectedBot
+	for _, bot := range q.store.selectedBots {
+		bots = append(bots, db.KickSelectedBot{
+			UserID:        bot.UserID,
+			BroadcasterID: bot.BroadcasterID,
+			BotID:         bot.BotID,
+			UpdatedAt:     bot.UpdatedAt,
+			Enabled:       bot.Enabled,
+		})
+	}
+
+	return bots, nil
+}
+
+func (q *fakeKickQueries) KickWebhookSubscriptionsGet(ctx context.Context, broadcasterID string) ([]db.KickWebhookSubscription, error) {
+	return q.store.storedSubscriptions(broadcasterID), nil
+}
+
+func (q *fakeKickQueries) KickWebhookSubscriptionUpsert(ctx context.Context, arg db.KickWebhookSubscriptionUpsertParams) (db.KickWebhookSubscription, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	sub := db.KickWebhookSubscription{
+		BroadcasterID:  arg.BroadcasterID,
+		Event:          arg.Event,
+		Version:        arg.Version,
+		SubscriptionID: arg.SubscriptionID,
+		CreatedAt:      arg.CreatedAt,
+		OwnerID:        arg.OwnerID,
+	}
+
+	for i, stored := range q.store.subscriptions {
+		if stored.BroadcasterID == arg.BroadcasterID && stored.Event == arg.Event {
+			q.store.subscriptions[i] = sub
+			return sub, nil
+		}
+	}
+	q.store.subscriptions = append(q.store.subscriptions, sub)
+
+	return sub, nil
+}
+
+func (q *fakeKickQueries) KickWebhookSubscriptionsDelete(ctx context.Context, arg db.KickWebhookSubscriptionsDeleteParams) error {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	q.store.subscriptions = slices.DeleteFunc(q.store.subscriptions, func(sub db.KickWebhookSubscription) bool {
+		return sub.BroadcasterID == arg.BroadcasterID && slices.Contains(arg.SubscriptionIds, sub.SubscriptionID)
+	})
+
+	return nil
+}
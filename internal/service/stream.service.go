@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/arnokay/arnobot-shared/apperror"
+	"github.com/arnokay/arnobot-shared/applog"
+	sharedService "github.com/arnokay/arnobot-shared/service"
+
+	"github.com/arnokay/arnobot-kick/internal/data"
+	"github.com/arnokay/arnobot-kick/internal/db"
+	"github.com/arnokay/arnobot-kick/internal/storage"
+)
+
+type StreamService struct {
+	storage   storage.Storager
+	txService sharedService.ITransactionService
+
+	logger applog.Logger
+}
+
+func NewStreamService(
+	store storage.Storager,
+	txService sharedService.ITransactionService,
+) *StreamService {
+	logger := applog.NewServiceLogger("stream-service")
+
+	return &StreamService{
+		storage:   store,
+		txService: txService,
+		logger:    logger,
+	}
+}
+
+// StreamSessionStart opens a new stream session for the broadcaster.
+// Session that was left open (e.g. offline webhook was lost) is closed at
+// the start of the new one, the same session is returned on webhook retries.
+func (s *StreamService) StreamSessionStart(ctx context.Context, arg data.StreamSessionStart) (data.StreamSession, error) {
+	startedAt := arg.StartedAt.UTC()
+	if arg.StartedAt.IsZero() {
+		startedAt = time.Now().UTC()
+	}
+
+	txCtx, err := s.txService.Begin(ctx)
+	defer s.txService.Rollback(txCtx)
+	if err != nil {
+		return data.StreamSession{}, err
+	}
+
+	active, err := s.StreamSessionGetActive(txCtx, arg.BroadcasterID)
+	if err == nil {
+		if active.StartedAt.Equal(startedAt) {
+			return active, nil
+		}
+
+		_, err = s.StreamSessionEnd(txCtx, data.StreamSessionEnd{
+			BroadcasterID: arg.BroadcasterID,
+			EndedAt:       startedAt,
+		})
+		if err != nil {
+			return data.StreamSession{}, err
+		}
+	} else if !errors.Is(err, apperror.ErrNotFound) {
+		return data.StreamSession{}, err
+	}
+
+	fromDB, err := s.storage.KickQuery(txCtx).KickStreamSessionCreate(txCtx, db.KickStreamSessionCreateParams{
+		BroadcasterID: arg.BroadcasterID,
+		Title:         arg.Title,
+		StartedAt:     startedAt,
+	})
+	if err != nil {
+		s.logger.DebugContext(ctx, "cannot create stream session", "err", err, "broadcasterID", arg.BroadcasterID)
+		return data.StreamSession{}, s.storage.HandleErr(ctx, err)
+	}
+
+	err = s.txService.Commit(txCtx)
+	if err != nil {
+		return data.StreamSession{}, err
+	}
+
+	return data.NewStreamSessionFromDB(fromDB), nil
+}
+
+func (s *StreamService) StreamSessionEnd(ctx context.Context, arg data.StreamSessionEnd) (data.StreamSession, error) {
+	endedAt := arg.EndedAt.UTC()
+	if arg.EndedAt.IsZero() {
+		endedAt = time.Now().UTC()
+	}
+
+	fromDB, err := s.storage.KickQuery(ctx).KickStreamSessionEnd(ctx, db.KickStreamSessionEndParams{
+		BroadcasterID: arg.BroadcasterID,
+		EndedAt:       endedAt,
+	})
+	if err != nil {
+		s.logger.DebugContext(ctx, "cannot end stream session", "err", err, "broadcasterID", arg.BroadcasterID)
+		return data.StreamSession{}, s.storage.HandleErr(ctx, err)
+	}
+
+	return data.NewStreamSessionFromDB(fromDB), nil
+}
+
+func (s *StreamService) StreamSessionGetActive(ctx context.Context, broadcasterID string) (data.StreamSession, error) {
+	fromDB, err := s.storage.KickQuery(ctx).KickStreamSessionGetActive(ctx, broadcasterID)
+	if err != nil {
+		s.logger.DebugContext(ctx, "cannot get active stream session", "err", err, "broadcasterID", broadcasterID)
+		return data.StreamSession{}, s.storage.HandleErr(ctx, err)
+	}
+
+	return data.NewStreamSessionFromDB(fromDB), nil
+}
+
+// StreamMetadataUpdate appends metadata to the broadcaster history and
+// returns previous metadata with the new one, apperror.ErrNoAction is
+// returned when nothing has changed
+func (s *StreamService) StreamMetadataUpdate(ctx context.Context, arg data.StreamMetadataUpdate) (data.StreamMetadataChange, error) {
+	txCtx, err := s.txService.Begin(ctx)
+	defer s.txService.Rollback(txCtx)
+	if err != nil {
+		return data.StreamMetadataChange{}, err
+	}
+
+	var change data.StreamMetadataChange
+
+	latest, err := s.storage.KickQuery(txCtx).KickStreamMetadataGetLatest(txCtx, arg.BroadcasterID)
+	if err != nil {
+		err = s.storage.HandleErr(ctx, err)
+		if !errors.Is(err, apperror.ErrNotFound) {
+			s.logger.DebugContext(ctx, "cannot get latest stream metadata", "err", err, "broadcasterID", arg.BroadcasterID)
+			return data.StreamMetadataChange{}, err
+		}
+	} else {
+		old := data.NewStreamMetadataFromDB(latest)
+		change.Old = &old
+	}
+
+	if change.Old != nil &&
+		change.Old.Title == arg.Title &&
+		change.Old.Language == arg.Language &&
+		change.Old.CategoryID == arg.CategoryID &&
+		change.Old.HasMatureContent == arg.HasMatureContent {
+		return data.StreamMetadataChange{}, apperror.ErrNoAction
+	}
+
+	fromDB, err := s.storage.KickQuery(txCtx).KickStreamMetadataCreate(txCtx, db.KickStreamMetadataCreateParams{
+		BroadcasterID:    arg.BroadcasterID,
+		Title:            arg.Title,
+		Language:         arg.Language,
+		CategoryID:       arg.CategoryID,
+		CategoryName:     arg.CategoryName,
+		HasMatureContent: arg.HasMatureContent,
+	})
+	if err != nil {
+		s.logger.DebugContext(ctx, "cannot create stream metadata", "err", err, "broadcasterID", arg.BroadcasterID)
+		return data.StreamMetadataChange{}, s.storage.HandleErr(ctx, err)
+	}
+	change.New = data.NewStreamMetadataFromDB(fromDB)
+
+	err = s.txService.Commit(txCtx)
+	if err != nil {
+		return data.StreamMetadataChange{}, err
+	}
+
+	return change, nil
+}
+
+func (s *StreamService) StreamMetadataHistoryGet(ctx context.Context, arg data.StreamMetadataHistoryGet) ([]data.StreamMetadata, error) {
+	limit := arg.Limit
+	if limit <= 0 || limit > 100 {
+		limit = 100
+	}
+
+	fromDB, err := s.storage.KickQuery(ctx).KickStreamMetadataHistoryGet(ctx, db.KickStreamMetadataHistoryGetParams{
+		BroadcasterID: arg.BroadcasterID,
+		From:          arg.From,
+		To:            arg.To,
+		Limit:         limit,
+	})
+	if err != nil {
+		s.logger.DebugContext(ctx, "cannot get stream metadata history", "err", err, "broadcasterID", arg.BroadcasterID)
+		return nil, s.storage.HandleErr(ctx, err)
+	}
+
+	history := make([]data.StreamMetadata, 0, len(fromDB))
+	for _, metadata := range fromDB {
+		history = append(history, data.NewStreamMetadataFromDB(metadata))
+	}
+
+	return history, nil
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/arnokay/arnobot-shared/apperror"
+	"github.com/arnokay/arnobot-shared/applog"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	webhookDedupKeyPrefix = "wh.msg."
+
+	webhookMessageProcessing = "processing"
+	webhookMessageDone       = "done"
+
+	// webhookDedupClaimAttempts bounds claim retries when entry changes
+	// between create and get
+	webhookDedupClaimAttempts = 3
+)
+
+type WebhookDedupOptions struct {
+	// TTL is how long message ids are kept
+	TTL time.Duration
+	// ProcessingTimeout is how long other replica may process the message
+	// before it can be claimed again
+	ProcessingTimeout time.Duration
+}
+
+// webhookDedupMark is last sequence of the bucket stream at the time
+type webhookDedupMark struct {
+	at  time.Time
+	seq uint64
+}
+
+// WebhookDedupService marks kick webhook messages as seen in the KV store,
+// KV create is atomic so it works across replicas.
+// Bucket is shared with other kick data and has no TTL, Janitor purges
+// message ids that are older than TTL.
+type WebhookDedupService struct {
+	js    jetstream.JetStream
+	cache jetstream.KeyValue
+
+	options WebhookDedupOptions
+	// marks are taken by Janitor, oldest first
+	marks []webhookDedupMark
+
+	logger applog.Logger
+}
+
+func NewWebhookDedupService(
+	js jetstream.JetStream,
+	cache jetstream.KeyValue,
+	options WebhookDedupOptions,
+) *WebhookDedupService {
+	logger := applog.NewServiceLogger("webhook-dedup-service")
+
+	if options.ProcessingTimeout <= 0 {
+		options.ProcessingTimeout = 30 * time.Second
+	}
+
+	return &WebhookDedupService{
+		js:      js,
+		cache:   cache,
+		options: options,
+		logger:  logger,
+	}
+}
+
+// Claim returns apperror.ErrAlreadyExists if the message was already
+// processed or is being processed right now. Claim of replica that did not
+// finish processing can be taken after processing timeout.
+func (s *WebhookDedupService) Claim(ctx context.Context, messageID string) error {
+	key := s.key(messageID)
+	value := []byte(webhookMessageProcessing)
+
+	for range webhookDedupClaimAttempts {
+		_, err := s.cache.Create(ctx, key, value)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, jetstream.ErrKeyExists) {
+			s.logger.ErrorContext(ctx, "cannot claim webhook message", "err", err, "messageID", messageID)
+			return apperror.ErrInternal
+		}
+
+		entry, err := s.cache.Get(ctx, key)
+		if err != nil {
+			if errors.Is(err, jetstream.ErrKeyNotFound) {
+				// entry was released or purged in between, create it again
+				continue
+			}
+			s.logger.ErrorContext(ctx, "cannot get webhook message", "err", err, "messageID", messageID)
+			return apperror.ErrInternal
+		}
+
+		if !s.abandoned(entry) {
+			return apperror.ErrAlreadyExists
+		}
+
+		_, err = s.cache.Update(ctx, key, value, entry.Revision())
+		if err != nil {
+			if errors.Is(err, jetstream.ErrKeyExists) {
+				return apperror.ErrAlreadyExists
+			}
+			s.logger.ErrorContext(ctx, "cannot reclaim webhook message", "err", err, "messageID", messageID)
+			return apperror.ErrInternal
+		}
+
+		return nil
+	}
+
+	s.logger.ErrorContext(ctx, "cannot claim webhook message, it keeps changing", "messageID", messageID)
+	return apperror.ErrInternal
+}
+
+// Done marks the message as processed
+func (s *WebhookDedupService) Done(ctx context.Context, messageID string) error {
+	_, err := s.cache.Put(ctx, s.key(messageID), []byte(webhookMessageDone))
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot mark webhook message as done", "err", err, "messageID", messageID)
+		return apperror.ErrInternal
+	}
+
+	return nil
+}
+
+// Release removes claim of the message that failed to be processed
+func (s *WebhookDedupService) Release(ctx context.Context, messageID string) error {
+	err := s.cache.Delete(ctx, s.key(messageID))
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot release webhook message", "err", err, "messageID", messageID)
+		return apperror.ErrInternal
+	}
+
+	return nil
+}
+
+// Janitor purges expired message ids until ctx is done
+func (s *WebhookDedupService) Janitor(ctx context.Context) {
+	if s.options.TTL <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(max(s.options.TTL/10, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.expire(ctx, now)
+		}
+	}
+}
+
+// expire marks current last sequence of the bucket stream and purges
+// message ids stored before the newest mark that is at least TTL old.
+// Purge by sequence does not read the entries and any number of replicas can
+// run it.
+func (s *WebhookDedupService) expire(ctx context.Context, now time.Time) {
+	stream, err := s.js.Stream(ctx, "KV_"+s.cache.Bucket())
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot get webhook dedup stream", "err", err)
+		return
+	}
+
+	info, err := stream.Info(ctx)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot get webhook dedup stream info", "err", err)
+		return
+	}
+	s.marks = append(s.marks, webhookDedupMark{at: now, seq: info.State.LastSeq + 1})
+
+	var before uint64
+	for len(s.marks) > 0 && now.Sub(s.marks[0].at) >= s.options.TTL {
+		before = s.marks[0].seq
+		s.marks = s.marks[1:]
+	}
+	if before == 0 {
+		return
+	}
+
+	err = stream.Purge(
+		ctx,
+		jetstream.WithPurgeSubject("$KV."+s.cache.Bucket()+"."+webhookDedupKeyPrefix+">"),
+		jetstream.WithPurgeSequence(before),
+	)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot purge expired webhook messages", "err", err)
+	}
+}
+
+// abandoned reports if the message is being processed longer than
+// processing timeout, replica processing it probably crashed
+func (s *WebhookDedupService) abandoned(entry jetstream.KeyValueEntry) bool {
+	return string(entry.Value()) == webhookMessageProcessing &&
+		time.Since(entry.Created()) > s.options.ProcessingTimeout
+}
+
+// key encodes message id, kick does not promise it is a valid KV key
+func (s *WebhookDedupService) key(messageID string) string {
+	return webhookDedupKeyPrefix + base64.RawURLEncoding.EncodeToString([]byte(messageID))
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arnokay/arnobot-shared/apperror"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func newWebhookDedupService(t *testing.T, mb *nats.Conn, options WebhookDedupOptions) *WebhookDedupService {
+	t.Helper()
+
+	js, err := jetstream.New(mb)
+	if err != nil {
+		t.Fatalf("cannot create jetstream: %v", err)
+	}
+
+	return NewWebhookDedupService(js, newKV(t, mb, "default-kick"), options)
+}
+
+func TestWebhookDedupClaim(t *testing.T) {
+	ctx := context.Background()
+	dedup := newWebhookDedupService(t, startNATS(t), WebhookDedupOptions{TTL: time.Hour})
+
+	err := dedup.Claim(ctx, "message")
+	if err != nil {
+		t.Fatalf("cannot claim new message: %v", err)
+	}
+
+	err = dedup.Claim(ctx, "message")
+	if !errors.Is(err, apperror.ErrAlreadyExists) {
+		t.Errorf("message being processed: got %v, want ErrAlreadyExists", err)
+	}
+
+	err = dedup.Done(ctx, "message")
+	if err != nil {
+		t.Fatalf("cannot mark message as done: %v", err)
+	}
+
+	err = dedup.Claim(ctx, "message")
+	if !errors.Is(err, apperror.ErrAlreadyExists) {
+		t.Errorf("processed message: got %v, want ErrAlreadyExists", err)
+	}
+
+	err = dedup.Claim(ctx, "other-message")
+	if err != nil {
+		t.Errorf("cannot claim other message: %v", err)
+	}
+}
+
+func TestWebhookDedupReclaimsAbandonedMessage(t *testing.T) {
+	ctx := context.Background()
+	dedup := newWebhookDedupService(t, startNATS(t), WebhookDedupOptions{
+		TTL:               time.Hour,
+		ProcessingTimeout: 100 * time.Millisecond,
+	})
+
+	for _, messageID := range []string{"abandoned", "done"} {
+		err := dedup.Claim(ctx, messageID)
+		if err != nil {
+			t.Fatalf("cannot claim %s: %v", messageID, err)
+		}
+	}
+	err := dedup.Done(ctx, "done")
+	if err != nil {
+		t.Fatalf("cannot mark message as done: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	err = dedup.Claim(ctx, "abandoned")
+	if err != nil {
+		t.Errorf("cannot reclaim abandoned message: %v", err)
+	}
+	err = dedup.Claim(ctx, "abandoned")
+	if !errors.Is(err, apperror.ErrAlreadyExists) {
+		t.Errorf("reclaimed message: got %v, want ErrAlreadyExists", err)
+	}
+
+	// processing timeout does not apply to processed messages
+	err = dedup.Claim(ctx, "done")
+	if !errors.Is(err, apperror.ErrAlreadyExists) {
+		t.Errorf("processed message: got %v, want ErrAlreadyExists", err)
+	}
+}
+
+func TestWebhookDedupRelease(t *testing.T) {
+	ctx := context.Background()
+	dedup := newWebhookDedupService(t, startNATS(t), WebhookDedupOptions{TTL: time.Hour})
+
+	err := dedup.Claim(ctx, "message")
+	if err != nil {
+		t.Fatalf("cannot claim message: %v", err)
+	}
+	err = dedup.Release(ctx, "message")
+	if err != nil {
+		t.Fatalf("cannot release message: %v", err)
+	}
+
+	err = dedup.Claim(ctx, "message")
+	if err != nil {
+		t.Errorf("cannot claim released message: %v", err)
+	}
+}
+
+func TestWebhookDedupKeysDoNotCollide(t *testing.T) {
+	ctx := context.Background()
+	dedup := newWebhookDedupService(t, startNATS(t), WebhookDedupOptions{TTL: time.Hour})
+
+	// none of them is valid KV key, they must not share one
+	for _, messageID := range []string{"a.b", "a:b", "a_b", "a b", "a*b", "a>b", "ąb"} {
+		err := dedup.Claim(ctx, messageID)
+		if err != nil {
+			t.Errorf("cannot claim %q: %v", messageID, err)
+		}
+	}
+}
+
+func TestWebhookDedupClaimRace(t *testing.T) {
+	ns := startNATSServer(t)
+	replicas := []*WebhookDedupService{
+		newWebhookDedupService(t, connectNATS(t, ns), WebhookDedupOptions{TTL: time.Hour}),
+		newWebhookDedupService(t, connectNATS(t, ns), WebhookDedupOptions{TTL: time.Hour}),
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		claimed int
+	)
+	start := make(chan struct{})
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			err := replicas[i%len(replicas)].Claim(context.Background(), "message")
+			if err != nil {
+				if !errors.Is(err, apperror.ErrAlreadyExists) {
+					t.Errorf("claim failed: %v", err)
+				}
+				return
+			}
+			mu.Lock()
+			claimed++
+			mu.Unlock()
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if claimed != 1 {
+		t.Errorf("message is claimed %d times, want once", claimed)
+	}
+}
+
+func TestWebhookDedupExpire(t *testing.T) {
+	ctx := context.Background()
+	mb := startNATS(t)
+	ttl := time.Hour
+	dedup := newWebhookDedupService(t, mb, WebhookDedupOptions{TTL: ttl})
+	// other kick data shares the bucket
+	cache := newKV(t, mb, "default-kick")
+	_, err := cache.Put(ctx, "kick.token.1", []byte("token"))
+	if err != nil {
+		t.Fatalf("cannot put token: %v", err)
+	}
+
+	now := time.Now()
+	err = dedup.Claim(ctx, "old")
+	if err != nil {
+		t.Fatalf("cannot claim old message: %v", err)
+	}
+	dedup.expire(ctx, now)
+
+	err = dedup.Claim(ctx, "new")
+	if err != nil {
+		t.Fatalf("cannot claim new message: %v", err)
+	}
+	dedup.expire(ctx, now.Add(ttl/2))
+
+	// only messages stored before the mark taken ttl ago are expired
+	dedup.expire(ctx, now.Add(ttl))
+
+	err = dedup.Claim(ctx, "old")
+	if err != nil {
+		t.Errorf("expired message is not claimed again: %v", err)
+	}
+	err = dedup.Claim(ctx, "new")
+	if !errors.Is(err, apperror.ErrAlreadyExists) {
+		t.Errorf("message within ttl: got %v, want ErrAlreadyExists", err)
+	}
+
+	_, err = cache.Get(ctx, "kick.token.1")
+	if err != nil {
+		t.Errorf("other data of the bucket is purged: %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/arnokay/arnobot-shared/applog"
+	"github.com/arnokay/arnobot-shared/pkg/assert"
+	"github.com/scorfly/gokick"
+)
+
+const (
+	webhookKeyRetryMin = time.Second
+	webhookKeyRetryMax = time.Minute
+)
+
+type WebhookKeyOptions struct {
+	// PublicKey is PEM encoded public key, used if KeyFile is empty
+	PublicKey string
+	// KeyFile is path to PEM encoded public key
+	KeyFile string
+	// Fetch gets public key from kick public key endpoint
+	// when neither PublicKey nor KeyFile are set
+	Fetch           bool
+	RefreshInterval time.Duration
+}
+
+// WebhookKeyService verifies signatures of kick webhooks against public key
+// from config, file or kick api, falls back to gokick.DefaultEventPublicKey
+type WebhookKeyService struct {
+	kickManager *KickManager
+	options     WebhookKeyOptions
+
+	publicKey *rsa.PublicKey
+	mu        sync.RWMutex
+
+	logger applog.Logger
+}
+
+// NewWebhookKeyService loads public key from config or file, key fetched
+// from kick is loaded by Run, default key is used until then
+func NewWebhookKeyService(
+	kickManager *KickManager,
+	options WebhookKeyOptions,
+) *WebhookKeyService {
+	logger := applog.NewServiceLogger("webhook-key-service")
+
+	s := &WebhookKeyService{
+		kickManager: kickManager,
+		options:     options,
+		logger:      logger,
+	}
+
+	if s.fetches() {
+		publicKey, err := parsePublicKey([]byte(gokick.DefaultEventPublicKey))
+		assert.NoError(err, "cannot parse default webhook public key")
+		s.publicKey = publicKey
+
+		return s
+	}
+
+	err := s.Refresh(context.Background())
+	assert.NoError(err, "cannot load webhook public key")
+
+	return s
+}
+
+// Refresh reloads public key from its source, current key is kept on error
+func (s *WebhookKeyService) Refresh(ctx context.Context) error {
+	raw, err := s.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	publicKey, err := parsePublicKey(raw)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.publicKey = publicKey
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Run fetches public key from kick with backoff until it succeeds, then
+// refreshes public key every refresh interval until ctx is done
+func (s *WebhookKeyService) Run(ctx context.Context) {
+	if s.fetches() && !s.fetchFirst(ctx) {
+		return
+	}
+
+	if s.options.RefreshInterval <= 0 || (s.options.KeyFile == "" && !s.fetches()) {
+		return
+	}
+
+	ticker := time.NewTicker(s.options.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.Refresh(ctx)
+			if err != nil {
+				s.logger.ErrorContext(ctx, "cannot refresh webhook public key", "err", err)
+			}
+		}
+	}
+}
+
+// fetchFirst fetches public key until it succeeds, false is returned if ctx
+// is done first
+func (s *WebhookKeyService) fetchFirst(ctx context.Context) bool {
+	retry := webhookKeyRetryMin
+
+	for {
+		err := s.Refresh(ctx)
+		if err == nil {
+			s.logger.InfoContext(ctx, "webhook public key fetched")
+			return true
+		}
+		// app token is usually not acquired yet when kick is unreachable
+		s.logger.WarnContext(ctx, "cannot fetch webhook public key, using default one", "err", err, "retry", retry)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(retry):
+		}
+
+		retry = min(retry*2, webhookKeyRetryMax)
+	}
+}
+
+// Verify checks Kick-Event-Signature of the webhook, signed payload is
+// "<message id>.<timestamp>.<body>"
+func (s *WebhookKeyService) Verify(header http.Header, body []byte) bool {
+	s.mu.RLock()
+	publicKey := s.publicKey
+	s.mu.RUnlock()
+
+	signature, err := base64.StdEncoding.DecodeString(header.Get("Kick-Event-Signature"))
+	if err != nil {
+		return false
+	}
+
+	payload := bytes.Join([][]byte{
+		[]byte(header.Get("Kick-Event-Message-Id")),
+		[]byte(header.Get("Kick-Event-Message-Timestamp")),
+		body,
+	}, []byte("."))
+	hashed := sha256.Sum256(payload)
+
+	return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed[:], signature) == nil
+}
+
+// fetches reports if public key is fetched from kick
+func (s *WebhookKeyService) fetches() bool {
+	return s.options.Fetch && s.options.KeyFile == "" && s.options.PublicKey == ""
+}
+
+func (s *WebhookKeyService) load(ctx context.Context) ([]byte, error) {
+	switch {
+	case s.options.KeyFile != "":
+		b, err := os.ReadFile(s.options.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read public key file: %w", err)
+		}
+		return b, nil
+	case s.options.PublicKey != "":
+		return []byte(s.options.PublicKey), nil
+	case s.options.Fetch:
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		client, err := s.kickManager.GetApp(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot fetch public key: %w", err)
+		}
+
+		response, err := client.GetPublicKey(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot fetch public key: %w", err)
+		}
+		return []byte(response.Result.PublicKey), nil
+	default:
+		return []byte(gokick.DefaultEventPublicKey), nil
+	}
+}
+
+func parsePublicKey(raw []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("public key is not PEM encoded PUBLIC KEY")
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse public key: %w", err)
+	}
+
+	publicKey, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not RSA key")
+	}
+
+	return publicKey, nil
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/scorfly/gokick"
+)
+
+func newWebhookKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("cannot marshal public key: %v", err)
+	}
+
+	return privateKey, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}))
+}
+
+// signWebhook returns kick headers of the webhook signed with the key
+func signWebhook(t *testing.T, privateKey *rsa.PrivateKey, body []byte) http.Header {
+	t.Helper()
+
+	header := http.Header{}
+	header.Set("Kick-Event-Message-Id", "01JG8K3XJ6N9F3W5E3S3E7P4QZ")
+	header.Set("Kick-Event-Message-Timestamp", time.Now().UTC().Format(time.RFC3339))
+
+	payload := bytes.Join([][]byte{
+		[]byte(header.Get("Kick-Event-Message-Id")),
+		[]byte(header.Get("Kick-Event-Message-Timestamp")),
+		body,
+	}, []byte("."))
+	hashed := sha256.Sum256(payload)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatalf("cannot sign webhook: %v", err)
+	}
+	header.Set("Kick-Event-Signature", base64.StdEncoding.EncodeToString(signature))
+
+	return header
+}
+
+func TestWebhookKeyServiceVerify(t *testing.T) {
+	privateKey, publicKey := newWebhookKey(t)
+	otherKey, _ := newWebhookKey(t)
+	keys := NewWebhookKeyService(nil, WebhookKeyOptions{PublicKey: publicKey})
+	body := []byte(`{"follower":{"user_id":1}}`)
+
+	header := signWebhook(t, privateKey, body)
+	if !keys.Verify(header, body) {
+		t.Error("webhook signed with the key is not verified")
+	}
+
+	if keys.Verify(header, []byte(`{"follower":{"user_id":2}}`)) {
+		t.Error("webhook with changed body is verified")
+	}
+
+	changed := header.Clone()
+	changed.Set("Kick-Event-Message-Id", "other")
+	if keys.Verify(changed, body) {
+		t.Error("webhook with changed message id is verified")
+	}
+
+	if keys.Verify(signWebhook(t, otherKey, body), body) {
+		t.Error("webhook signed with other key is verified")
+	}
+
+	changed = header.Clone()
+	changed.Set("Kick-Event-Signature", "not base64")
+	if keys.Verify(changed, body) {
+		t.Error("webhook with invalid signature is verified")
+	}
+}
+
+func TestWebhookKeyServiceKeyFile(t *testing.T) {
+	privateKey, publicKey := newWebhookKey(t)
+	_, configKey := newWebhookKey(t)
+	body := []byte(`{}`)
+
+	keyFile := filepath.Join(t.TempDir(), "public.pem")
+	err := os.WriteFile(keyFile, []byte(publicKey), 0o600)
+	if err != nil {
+		t.Fatalf("cannot write key file: %v", err)
+	}
+
+	// key file wins over key from config
+	keys := NewWebhookKeyService(nil, WebhookKeyOptions{
+		KeyFile:   keyFile,
+		PublicKey: configKey,
+	})
+	if !keys.Verify(signWebhook(t, privateKey, body), body) {
+		t.Fatal("webhook signed with key from file is not verified")
+	}
+
+	newPrivateKey, newPublicKey := newWebhookKey(t)
+	err = os.WriteFile(keyFile, []byte(newPublicKey), 0o600)
+	if err != nil {
+		t.Fatalf("cannot write key file: %v", err)
+	}
+	err = keys.Refresh(context.Background())
+	if err != nil {
+		t.Fatalf("cannot refresh key: %v", err)
+	}
+	if !keys.Verify(signWebhook(t, newPrivateKey, body), body) {
+		t.Error("webhook signed with rotated key is not verified")
+	}
+
+	// broken file keeps current key
+	err = os.WriteFile(keyFile, []byte("not a key"), 0o600)
+	if err != nil {
+		t.Fatalf("cannot write key file: %v", err)
+	}
+	err = keys.Refresh(context.Background())
+	if err == nil {
+		t.Error("broken key file is refreshed")
+	}
+	if !keys.Verify(signWebhook(t, newPrivateKey, body), body) {
+		t.Error("current key is dropped on failed refresh")
+	}
+}
+
+func TestWebhookKeyServiceDefaultKey(t *testing.T) {
+	defaultKey, err := parsePublicKey([]byte(gokick.DefaultEventPublicKey))
+	if err != nil {
+		t.Fatalf("cannot parse default key: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		options WebhookKeyOptions
+	}{
+		{
+			name: "no key",
+		},
+		{
+			// fetched key replaces it in Run
+			name:    "fetch",
+			options: WebhookKeyOptions{Fetch: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := NewWebhookKeyService(nil, tt.options)
+			if !keys.publicKey.Equal(defaultKey) {
+				t.Error("default kick key is not used")
+			}
+		})
+	}
+}
+
+func TestWebhookKeyServiceFetch(t *testing.T) {
+	env := newTestEnv(t)
+	body := []byte(`{}`)
+
+	// kick is down when the service starts, it does not wait for kick
+	env.kick.Fail(http.MethodGet, "/public/v1/public-key", http.StatusServiceUnavailable, 1)
+	keys := NewWebhookKeyService(env.kickManager, WebhookKeyOptions{
+		Fetch:           true,
+		RefreshInterval: time.Hour,
+	})
+	header := env.kick.WebhookHeader(gokick.SubscriptionNameChannelFollow.String(), body)
+	if keys.Verify(header, body) {
+		t.Fatal("webhook is verified before the key is fetched")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go keys.Run(ctx)
+
+	eventually(t, func() bool {
+		return keys.Verify(header, body)
+	}, "key is not fetched after failed first fetch")
+}
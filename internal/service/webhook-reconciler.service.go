@@ -0,0 +1,319 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/arnokay/arnobot-shared/applog"
+	"github.com/arnokay/arnobot-shared/data"
+	"github.com/arnokay/arnobot-shared/platform"
+	sharedService "github.com/arnokay/arnobot-shared/service"
+	"github.com/arnokay/arnobot-shared/trace"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/scorfly/gokick"
+
+	"github.com/arnokay/arnobot-kick/internal/metrics"
+	"github.com/arnokay/arnobot-kick/internal/storage"
+)
+
+const webhookReconcilerLeaseKey = "wh.reconciler.lease"
+
+type WebhookReconcilerOptions struct {
+	// Interval is how often subscriptions are reconciled, 0 disables reconciler
+	Interval time.Duration
+	// OrphanGrace is min age of subscription of channel without enabled bot
+	// before it is deleted, bot may be starting right now
+	OrphanGrace time.Duration
+}
+
+// webhookReconcilerLease is stored in KV under wh.reconciler.lease by the
+// replica that runs reconciler
+type webhookReconcilerLease struct {
+	Owner     string    `json:"owner"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// WebhookReconcilerService periodically compares webhook subscriptions kick
+// reports with selected bots. Channels of enabled bots that miss events are
+// subscribed again, subscriptions of channels without enabled bot are deleted.
+// Only the replica holding KV lease reconciles.
+type WebhookReconcilerService struct {
+	storage     storage.Storager
+	authModule  *sharedService.AuthModule
+	kickManager *KickManager
+	whService   *WebhookService
+	cache       jetstream.KeyValue
+	options     WebhookReconcilerOptions
+
+	instanceID    string
+	leaseRevision uint64
+
+	logger applog.Logger
+}
+
+func NewWebhookReconcilerService(
+	store storage.Storager,
+	authModule *sharedService.AuthModule,
+	kickManager *KickManager,
+	whService *WebhookService,
+	cache jetstream.KeyValue,
+	options WebhookReconcilerOptions,
+) *WebhookReconcilerService {
+	logger := applog.NewServiceLogger("webhook-reconciler-service")
+
+	hostname, _ := os.Hostname()
+
+	return &WebhookReconcilerService{
+		storage:     store,
+		authModule:  authModule,
+		kickManager: kickManager,
+		whService:   whService,
+		cache:       cache,
+		options:     options,
+		instanceID:  fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		logger:      logger,
+	}
+}
+
+// Run reconciles subscriptions every interval while the replica holds the
+// lease, lease is released when ctx is done
+func (s *WebhookReconcilerService) Run(ctx context.Context) {
+	if s.options.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.options.Interval)
+	defer ticker.Stop()
+	defer s.releaseLease()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			runCtx := trace.Context(ctx, trace.New())
+			if !s.acquireLease(runCtx) {
+				metrics.SetInt(metrics.WebhookReconciler, metrics.WebhookReconcilerLeader, 0)
+				continue
+			}
+			metrics.SetInt(metrics.WebhookReconciler, metrics.WebhookReconcilerLeader, 1)
+
+			err := s.Reconcile(runCtx)
+			if err != nil {
+				s.logger.ErrorContext(runCtx, "cannot reconcile webhook subscriptions", "err", err)
+			}
+		}
+	}
+}
+
+// Reconcile runs one reconciliation pass
+func (s *WebhookReconcilerService) Reconcile(ctx context.Context) error {
+	metrics.WebhookReconciler.Add(metrics.WebhookReconcilerRuns, 1)
+
+	selectedBots, err := s.storage.KickQuery(ctx).KickSelectedBotsGet(ctx)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot get selected bots", "err", err)
+		return s.storage.HandleErr(ctx, err)
+	}
+
+	client, err := s.kickManager.GetApp(ctx)
+	if err != nil {
+		return err
+	}
+
+	// app token lists subscriptions of our app created with any token
+	subs, err := client.GetSubscriptions(ctx)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot get webhook subscriptions", "err", err)
+		return err
+	}
+
+	remote := make(map[string][]gokick.EventResponse)
+	for _, sub := range subs.Result {
+		broadcasterID := strconv.Itoa(sub.BroadcasterUserID)
+		remote[broadcasterID] = append(remote[broadcasterID], sub)
+	}
+
+	var missingTotal, orphanedTotal int
+	enabled := make(map[string]bool)
+	for _, bot := range selectedBots {
+		if !bot.Enabled {
+			continue
+		}
+		enabled[bot.BroadcasterID] = true
+
+		missing := missingSubscriptions(remote[bot.BroadcasterID])
+		if len(missing) == 0 {
+			continue
+		}
+		missingTotal += len(missing)
+
+		s.logger.WarnContext(ctx, "enabled bot is missing webhook subscriptions", "broadcasterID", bot.BroadcasterID, "botID", bot.BotID, "missing", missing)
+		s.resubscribe(ctx, bot.BroadcasterID)
+	}
+
+	orphanedBefore := time.Now().Add(-s.options.OrphanGrace)
+	for broadcasterID, broadcasterSubs := range remote {
+		if enabled[broadcasterID] {
+			continue
+		}
+
+		var orphans []string
+		for _, sub := range broadcasterSubs {
+			createdAt, err := time.Parse(time.RFC3339, sub.CreatedAt)
+			if err != nil {
+				// age is unknown, subscription may belong to bot that is starting
+				s.logger.WarnContext(ctx, "skipping webhook subscription with invalid creation time", "err", err, "broadcasterID", broadcasterID, "subscriptionID", sub.ID, "createdAt", sub.CreatedAt)
+				continue
+			}
+			if createdAt.After(orphanedBefore) {
+				continue
+			}
+			orphans = append(orphans, sub.ID)
+		}
+		if len(orphans) == 0 {
+			continue
+		}
+		orphanedTotal += len(orphans)
+
+		s.logger.WarnContext(ctx, "deleting webhook subscriptions of channel without enabled bot", "broadcasterID", broadcasterID, "count", len(orphans))
+		err := s.whService.UnsubscribeApp(ctx, broadcasterID, orphans)
+		if err != nil {
+			metrics.WebhookReconciler.Add(metrics.WebhookReconcilerFailed, 1)
+			continue
+		}
+		metrics.WebhookReconciler.Add(metrics.WebhookReconcilerDeleted, int64(len(orphans)))
+	}
+
+	metrics.SetInt(metrics.WebhookReconciler, metrics.WebhookReconcilerMissing, int64(missingTotal))
+	metrics.SetInt(metrics.WebhookReconciler, metrics.WebhookReconcilerOrphaned, int64(orphanedTotal))
+
+	s.logger.InfoContext(
+		ctx,
+		"webhook subscriptions reconciled",
+		"subscriptions", len(subs.Result),
+		"enabledBots", len(enabled),
+		"missing", missingTotal,
+		"orphaned", orphanedTotal,
+	)
+
+	return nil
+}
+
+// resubscribe subscribes channel of enabled bot again, failures are counted
+// and retried on the next pass
+func (s *WebhookReconcilerService) resubscribe(ctx context.Context, broadcasterID string) {
+	broadcasterProvider, err := s.authModule.AuthProviderGet(ctx, data.AuthProviderGet{
+		ProviderUserID: &broadcasterID,
+		Provider:       platform.Kick.String(),
+	})
+	if err == nil {
+		err = s.whService.Subscribe(ctx, *broadcasterProvider)
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot resubscribe channel", "err", err, "broadcasterID", broadcasterID)
+		metrics.WebhookReconciler.Add(metrics.WebhookReconcilerFailed, 1)
+		return
+	}
+
+	metrics.WebhookReconciler.Add(metrics.WebhookReconcilerResubscribed, 1)
+}
+
+// missingSubscriptions returns names of events that are not in subs
+func missingSubscriptions(subs []gokick.EventResponse) []string {
+	var missing []string
+	for _, desired := range webhookSubscriptions {
+		found := false
+		for _, sub := range subs {
+			if sub.Event == desired.Name.String() && sub.Version == desired.Version {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, desired.Name.String())
+		}
+	}
+
+	return missing
+}
+
+// acquireLease takes or renews reconciler lease, lease of crashed replica
+// can be taken after it expires
+func (s *WebhookReconcilerService) acquireLease(ctx context.Context) bool {
+	value, _ := json.Marshal(webhookReconcilerLease{
+		Owner:     s.instanceID,
+		ExpiresAt: time.Now().Add(s.leaseTTL()),
+	})
+
+	if s.leaseRevision != 0 {
+		revision, err := s.cache.Update(ctx, webhookReconcilerLeaseKey, value, s.leaseRevision)
+		if err == nil {
+			s.leaseRevision = revision
+			return true
+		}
+		s.logger.WarnContext(ctx, "webhook reconciler lease lost", "err", err)
+		s.leaseRevision = 0
+	}
+
+	revision, err := s.cache.Create(ctx, webhookReconcilerLeaseKey, value)
+	if err == nil {
+		s.leaseRevision = revision
+		return true
+	}
+	if !errors.Is(err, jetstream.ErrKeyExists) {
+		s.logger.ErrorContext(ctx, "cannot create webhook reconciler lease", "err", err)
+		return false
+	}
+
+	entry, err := s.cache.Get(ctx, webhookReconcilerLeaseKey)
+	if err != nil {
+		if !errors.Is(err, jetstream.ErrKeyNotFound) {
+			s.logger.ErrorContext(ctx, "cannot get webhook reconciler lease", "err", err)
+		}
+		return false
+	}
+
+	var lease webhookReconcilerLease
+	err = json.Unmarshal(entry.Value(), &lease)
+	if err == nil && lease.Owner != s.instanceID && time.Now().Before(lease.ExpiresAt) {
+		return false
+	}
+
+	revision, err = s.cache.Update(ctx, webhookReconcilerLeaseKey, value, entry.Revision())
+	if err != nil {
+		// other replica took it first
+		return false
+	}
+	s.leaseRevision = revision
+
+	return true
+}
+
+// releaseLease deletes the lease if the replica holds it, so other replica
+// does not wait for it to expire
+func (s *WebhookReconcilerService) releaseLease() {
+	if s.leaseRevision == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := s.cache.Delete(ctx, webhookReconcilerLeaseKey, jetstream.LastRevision(s.leaseRevision))
+	if err != nil {
+		s.logger.WarnContext(ctx, "cannot release webhook reconciler lease", "err", err)
+	}
+	s.leaseRevision = 0
+	metrics.SetInt(metrics.WebhookReconciler, metrics.WebhookReconcilerLeader, 0)
+}
+
+// leaseTTL outlives one interval, so holder renews lease before it expires
+func (s *WebhookReconcilerService) leaseTTL() time.Duration {
+	return 2 * s.options.Interval
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	sharedData "github.com/arnokay/arnobot-shared/data"
+	"github.com/arnokay/arnobot-shared/platform"
+)
+
+func (env *testEnv) newReconciler(t *testing.T, instanceID string, options WebhookReconcilerOptions) *WebhookReconcilerService {
+	t.Helper()
+
+	// every reconciler is other replica with its own connection
+	mb := connectNATS(t, env.ns)
+	reconciler := NewWebhookReconcilerService(
+		env.store,
+		env.authModule.AuthModule,
+		env.kickManager,
+		env.whService,
+		newKV(t, mb, "kick-test"),
+		options,
+	)
+	reconciler.instanceID = instanceID
+
+	return reconciler
+}
+
+// startBots enables default bot in channels of the broadcasters
+func (env *testEnv) startBots(t *testing.T, broadcasterIDs ...int) []sharedData.AuthProvider {
+	t.Helper()
+
+	env.addUser(1, "bot")
+	env.store.defaultBotID = "1"
+
+	var broadcasters []sharedData.AuthProvider
+	for _, id := range broadcasterIDs {
+		broadcaster := env.addUser(id, "broadcaster")
+		err := env.botService.StartBot(context.Background(), sharedData.PlatformBotToggle{
+			Platform: platform.Kick,
+			UserID:   broadcaster.UserID,
+		})
+		if err != nil {
+			t.Fatalf("cannot start bot: %v", err)
+		}
+		broadcasters = append(broadcasters, broadcaster)
+	}
+
+	return broadcasters
+}
+
+func (env *testEnv) kickSubscriptions(broadcasterID int) int {
+	var n int
+	for _, sub := range env.kick.Subscriptions() {
+		if sub.BroadcasterUserID == broadcasterID {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestWebhookReconcilerResubscribesEnabledBot(t *testing.T) {
+	env := newTestEnv(t)
+	env.startBots(t, 2)
+	reconciler := env.newReconciler(t, "a", WebhookReconcilerOptions{Interval: time.Minute})
+
+	// subscriptions are lost on kick side
+	subs := env.store.storedSubscriptions("2")
+	var ids []string
+	for _, sub := range subs {
+		ids = append(ids, sub.SubscriptionID)
+	}
+	err := env.whService.UnsubscribeApp(context.Background(), "2", ids[:2])
+	if err != nil {
+		t.Fatalf("cannot delete subscriptions: %v", err)
+	}
+	if n := env.kickSubscriptions(2); n != len(webhookSubscriptions)-2 {
+		t.Fatalf("got %d subscriptions before reconcile, want %d", n, len(webhookSubscriptions)-2)
+	}
+
+	err = reconciler.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("cannot reconcile: %v", err)
+	}
+
+	if n := env.kickSubscriptions(2); n != len(webhookSubscriptions) {
+		t.Errorf("got %d subscriptions, want %d", n, len(webhookSubscriptions))
+	}
+}
+
+func TestWebhookReconcilerDeletesOrphanedSubscriptions(t *testing.T) {
+	env := newTestEnv(t)
+	broadcasters := env.startBots(t, 2, 3)
+
+	// bot is disabled without its subscriptions being deleted
+	err := env.botService.SelectedBotChangeStatus(context.Background(), broadcasters[1].UserID, false)
+	if err != nil {
+		t.Fatalf("cannot disable bot: %v", err)
+	}
+
+	// bot may be starting, young subscriptions are kept
+	reconciler := env.newReconciler(t, "a", WebhookReconcilerOptions{
+		Interval:    time.Minute,
+		OrphanGrace: time.Hour,
+	})
+	err = reconciler.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("cannot reconcile: %v", err)
+	}
+	if n := env.kickSubscriptions(3); n != len(webhookSubscriptions) {
+		t.Fatalf("subscriptions within grace are deleted, %d left", n)
+	}
+
+	reconciler = env.newReconciler(t, "b", WebhookReconcilerOptions{Interval: time.Minute})
+	err = reconciler.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("cannot reconcile: %v", err)
+	}
+
+	if n := env.kickSubscriptions(3); n != 0 {
+		t.Errorf("got %d orphaned subscriptions, want 0", n)
+	}
+	if n := len(env.store.storedSubscriptions("3")); n != 0 {
+		t.Errorf("got %d stored orphaned subscriptions, want 0", n)
+	}
+	if n := env.kickSubscriptions(2); n != len(webhookSubscriptions) {
+		t.Errorf("subscriptions of enabled bot are deleted, %d left", n)
+	}
+}
+
+func TestWebhookReconcilerLeaseHandover(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	options := WebhookReconcilerOptions{Interval: 100 * time.Millisecond}
+	first := env.newReconciler(t, "a", options)
+	second := env.newReconciler(t, "b", options)
+
+	if !first.acquireLease(ctx) {
+		t.Fatal("free lease is not acquired")
+	}
+	if second.acquireLease(ctx) {
+		t.Fatal("lease is held by two replicas")
+	}
+	if !first.acquireLease(ctx) {
+		t.Fatal("holder cannot renew lease")
+	}
+
+	// stopped replica hands lease over right away
+	first.releaseLease()
+	if !second.acquireLease(ctx) {
+		t.Fatal("released lease is not acquired")
+	}
+	if first.acquireLease(ctx) {
+		t.Fatal("lease is held by two replicas")
+	}
+
+	// lease of crashed replica is taken after it expires
+	time.Sleep(second.leaseTTL() + 50*time.Millisecond)
+	if !first.acquireLease(ctx) {
+		t.Fatal("expired lease is not acquired")
+	}
+	if second.acquireLease(ctx) {
+		t.Error("replica that lost lease still holds it")
+	}
+}
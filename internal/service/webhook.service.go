@@ -3,16 +3,35 @@ package service
 import (
 	"context"
 	"strconv"
+	"time"
 
 	"github.com/arnokay/arnobot-shared/apperror"
 	"github.com/arnokay/arnobot-shared/applog"
 	"github.com/arnokay/arnobot-shared/data"
+	"github.com/arnokay/arnobot-shared/platform"
+	sharedService "github.com/arnokay/arnobot-shared/service"
 	"github.com/scorfly/gokick"
 
 	"github.com/arnokay/arnobot-kick/internal/config"
+	"github.com/arnokay/arnobot-kick/internal/db"
+	"github.com/arnokay/arnobot-kick/internal/storage"
 )
 
+// webhookSubscriptions are events every channel with enabled bot is subscribed to
+var webhookSubscriptions = []gokick.SubscriptionRequest{
+	{Name: gokick.SubscriptionNameChatMessage, Version: 1},
+	{Name: gokick.SubscriptionNameChannelFollow, Version: 1},
+	{Name: gokick.SubscriptionNameChannelSubscriptionRenewal, Version: 1},
+	{Name: gokick.SubscriptionNameChannelSubscriptionGifts, Version: 1},
+	{Name: gokick.SubscriptionNameChannelSubscriptionCreated, Version: 1},
+	{Name: gokick.SubscriptionNameLivestreamStatusUpdated, Version: 1},
+	{Name: gokick.SubscriptionNameLivestreamMetadataUpdated, Version: 1},
+	{Name: gokick.SubscriptionNameModerationBanned, Version: 1},
+}
+
 type WebhookService struct {
+	storage     storage.Storager
+	authModule  *sharedService.AuthModule
 	kickManager *KickManager
 	kickService *KickService
 
@@ -22,12 +41,16 @@ type WebhookService struct {
 }
 
 func NewWebhookService(
+	store storage.Storager,
+	authModule *sharedService.AuthModule,
 	helixManager *KickManager,
 	kickService *KickService,
 ) *WebhookService {
 	logger := applog.NewServiceLogger("webhook-service")
 
 	return &WebhookService{
+		storage:     store,
+		authModule:  authModule,
 		kickManager: helixManager,
 		kickService: kickService,
 		logger:      logger,
@@ -35,12 +58,18 @@ func NewWebhookService(
 	}
 }
 
+// UnsubscribeMany deletes subscriptions with tokens of the provider, kick
+// ignores subscriptions that were created with other token
 func (s *WebhookService) UnsubscribeMany(
 	ctx context.Context,
-	botProvider data.AuthProvider,
+	ownerProvider data.AuthProvider,
 	subscriptionIds []string,
 ) error {
-	client := s.kickManager.GetByProvider(ctx, botProvider)
+	if len(subscriptionIds) == 0 {
+		return nil
+	}
+
+	client := s.kickManager.GetByProvider(ctx, ownerProvider)
 
 	_, err := client.DeleteSubscriptions(ctx, gokick.NewSubscriptionToDeleteFilter().SetIDs(subscriptionIds))
 	if err != nil {
@@ -57,72 +86,314 @@ func (s *WebhookService) UnsubscribeMany(
 
 func (s *WebhookService) Unsubscribe(
 	ctx context.Context,
-	botProvider data.AuthProvider,
+	ownerProvider data.AuthProvider,
 	subscriptionID string,
 ) error {
-	return s.UnsubscribeMany(ctx, botProvider, []string{subscriptionID})
+	return s.UnsubscribeMany(ctx, ownerProvider, []string{subscriptionID})
 }
 
+// UnsubscribeAll deletes subscriptions of the broadcaster, each one with
+// token of its owner. Subscriptions that were created before their owners
+// were stored are deleted with app token.
 func (s *WebhookService) UnsubscribeAll(
 	ctx context.Context,
-	botProvider data.AuthProvider,
 	broadcasterID string,
 ) error {
-	client := s.kickManager.GetByProvider(ctx, botProvider)
+	stored, err := s.storage.KickQuery(ctx).KickWebhookSubscriptionsGet(ctx, broadcasterID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot get stored subscriptions", "err", err, "broadcasterID", broadcasterID)
+		return s.storage.HandleErr(ctx, err)
+	}
 
-	var subIds []string
+	byOwner := make(map[string][]string)
+	for _, sub := range stored {
+		byOwner[sub.OwnerID] = append(byOwner[sub.OwnerID], sub.SubscriptionID)
+	}
+
+	for ownerID, subIds := range byOwner {
+		err = s.unsubscribeOwned(ctx, broadcasterID, ownerID, subIds)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "failed to unsubscribe", "err", err, "broadcasterID", broadcasterID, "ownerID", ownerID)
+			return err
+		}
+		err = s.forget(ctx, broadcasterID, subIds)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.unsubscribeUnknown(ctx, broadcasterID)
+	if err != nil {
+		s.logger.WarnContext(ctx, "cannot unsubscribe unknown subscriptions", "err", err, "broadcasterID", broadcasterID)
+	}
+
+	return nil
+}
+
+// UnsubscribeApp deletes subscriptions of the broadcaster with app token,
+// it sees every subscription of our app whichever token created it
+func (s *WebhookService) UnsubscribeApp(
+	ctx context.Context,
+	broadcasterID string,
+	subscriptionIds []string,
+) error {
+	err := s.unsubscribeApp(ctx, broadcasterID, subscriptionIds)
+	if err != nil {
+		return err
+	}
+
+	return s.forget(ctx, broadcasterID, subscriptionIds)
+}
+
+// unsubscribeOwned deletes subscriptions with token of their owner, app
+// token is used if owner tokens are not available (e.g. they were revoked)
+func (s *WebhookService) unsubscribeOwned(
+	ctx context.Context,
+	broadcasterID string,
+	ownerID string,
+	subscriptionIds []string,
+) error {
+	ownerProvider, err := s.authModule.AuthProviderGet(ctx, data.AuthProviderGet{
+		ProviderUserID: &ownerID,
+		Provider:       platform.Kick.String(),
+	})
+	if err == nil {
+		err = s.UnsubscribeMany(ctx, *ownerProvider, subscriptionIds)
+		if err == nil {
+			return nil
+		}
+	}
+
+	s.logger.WarnContext(ctx, "cannot unsubscribe with owner token, using app token", "err", err, "broadcasterID", broadcasterID, "ownerID", ownerID)
+
+	return s.unsubscribeApp(ctx, broadcasterID, subscriptionIds)
+}
+
+// unsubscribeUnknown deletes subscriptions of the broadcaster that kick
+// reports to app token but are not stored
+func (s *WebhookService) unsubscribeUnknown(ctx context.Context, broadcasterID string) error {
+	bID, err := strconv.Atoi(broadcasterID)
+	if err != nil {
+		return apperror.ErrInvalidInput
+	}
+
+	client, err := s.kickManager.GetApp(ctx)
+	if err != nil {
+		return err
+	}
 
 	subs, err := client.GetSubscriptions(ctx)
 	if err != nil {
-		s.logger.ErrorContext(ctx, "error getting eventsub subscriptions", "err", err)
 		return apperror.ErrExternal
 	}
 
-  bID, err := strconv.Atoi(broadcasterID)
-  if err != nil {
-    s.logger.ErrorContext(ctx, "cannot convert broadcasterID", "broadcaster_id", broadcasterID)
-    return apperror.ErrInvalidInput
-  }
+	var subIds []string
 	for _, sub := range subs.Result {
 		if sub.BroadcasterUserID == bID {
 			subIds = append(subIds, sub.ID)
 		}
 	}
+	if len(subIds) == 0 {
+		return nil
+	}
+
+	s.logger.InfoContext(ctx, "deleting unknown subscriptions", "broadcasterID", broadcasterID, "count", len(subIds))
 
-	err = s.UnsubscribeMany(ctx, botProvider, subIds)
+	return s.unsubscribeApp(ctx, broadcasterID, subIds)
+}
+
+func (s *WebhookService) unsubscribeApp(
+	ctx context.Context,
+	broadcasterID string,
+	subscriptionIds []string,
+) error {
+	if len(subscriptionIds) == 0 {
+		return nil
+	}
+
+	client, err := s.kickManager.GetApp(ctx)
 	if err != nil {
-		s.logger.ErrorContext(ctx, "failed to unsubscribe", "err", err)
 		return err
 	}
 
+	_, err = client.DeleteSubscriptions(ctx, gokick.NewSubscriptionToDeleteFilter().SetIDs(subscriptionIds))
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot unsubscribe with app token", "err", err, "broadcasterID", broadcasterID)
+		return apperror.ErrExternal
+	}
+
 	return nil
 }
 
+// Subscribe makes sure the broadcaster is subscribed to every webhook event
+// once. Stored subscriptions that kick still reports are kept, subscriptions
+// that kick reports but were not stored are adopted, duplicates are deleted
+// and only missing events are created. Subscriptions are created with tokens
+// of the broadcaster, which becomes their owner.
 func (s *WebhookService) Subscribe(
 	ctx context.Context,
 	broadcasterProvider data.AuthProvider,
 ) error {
+	broadcasterID := broadcasterProvider.ProviderUserID
+	bID, err := strconv.Atoi(broadcasterID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot convert broadcasterID", "broadcaster_id", broadcasterID)
+		return apperror.ErrInvalidInput
+	}
+
 	client := s.kickManager.GetByProvider(ctx, broadcasterProvider)
 
-	_, err := client.CreateSubscriptions(
+	stored, err := s.storage.KickQuery(ctx).KickWebhookSubscriptionsGet(ctx, broadcasterID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot get stored subscriptions", "err", err, "broadcasterID", broadcasterID)
+		return s.storage.HandleErr(ctx, err)
+	}
+	storedIDs := make(map[string]string, len(stored))
+	foreign := make(map[string][]string)
+	for _, sub := range stored {
+		// broadcaster token does not see subscriptions of other owners
+		if sub.OwnerID != broadcasterID {
+			foreign[sub.OwnerID] = append(foreign[sub.OwnerID], sub.SubscriptionID)
+			continue
+		}
+		storedIDs[sub.Event] = sub.SubscriptionID
+	}
+
+	for ownerID, subIds := range foreign {
+		err = s.unsubscribeOwned(ctx, broadcasterID, ownerID, subIds)
+		if err != nil {
+			return err
+		}
+		err = s.forget(ctx, broadcasterID, subIds)
+		if err != nil {
+			return err
+		}
+	}
+
+	subs, err := client.GetSubscriptions(ctx)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error getting eventsub subscriptions", "err", err)
+		return apperror.ErrExternal
+	}
+
+	// subscriptions of the broadcaster kick reports, by event
+	remote := make(map[string][]gokick.EventResponse)
+	for _, sub := range subs.Result {
+		if sub.BroadcasterUserID == bID {
+			remote[sub.Event] = append(remote[sub.Event], sub)
+		}
+	}
+
+	var missing []gokick.SubscriptionRequest
+	var duplicates []string
+	for _, desired := range webhookSubscriptions {
+		event := desired.Name.String()
+
+		var keep *gokick.EventResponse
+		for i, sub := range remote[event] {
+			if sub.Version != desired.Version {
+				continue
+			}
+			if keep == nil || sub.ID == storedIDs[event] {
+				keep = &remote[event][i]
+			}
+		}
+		if keep == nil {
+			missing = append(missing, desired)
+			continue
+		}
+
+		for _, sub := range remote[event] {
+			if sub.ID != keep.ID {
+				duplicates = append(duplicates, sub.ID)
+			}
+		}
+
+		if keep.ID != storedIDs[event] {
+			err = s.remember(ctx, broadcasterID, broadcasterID, event, desired.Version, keep.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(duplicates) > 0 {
+		s.logger.InfoContext(ctx, "deleting duplicate subscriptions", "broadcasterID", broadcasterID, "count", len(duplicates))
+		err = s.UnsubscribeMany(ctx, broadcasterProvider, duplicates)
+		if err != nil {
+			return err
+		}
+		err = s.forget(ctx, broadcasterID, duplicates)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	created, err := client.CreateSubscriptions(
 		ctx,
 		gokick.SubscriptionMethodWebhook,
-		[]gokick.SubscriptionRequest{
-			{Name: gokick.SubscriptionNameChatMessage, Version: 1},
-			{Name: gokick.SubscriptionNameChannelFollow, Version: 1},
-			{Name: gokick.SubscriptionNameChannelSubscriptionRenewal, Version: 1},
-			{Name: gokick.SubscriptionNameChannelSubscriptionGifts, Version: 1},
-			{Name: gokick.SubscriptionNameChannelSubscriptionCreated, Version: 1},
-			{Name: gokick.SubscriptionNameLivestreamStatusUpdated, Version: 1},
-			{Name: gokick.SubscriptionNameLivestreamMetadataUpdated, Version: 1},
-			{Name: gokick.SubscriptionNameModerationBanned, Version: 1},
-		},
+		missing,
 		nil,
 	)
 	if err != nil {
-		s.logger.ErrorContext(ctx, "cannot subscribe to channel", "err", err, "broadcasterID", broadcasterProvider.ProviderUserID)
+		s.logger.ErrorContext(ctx, "cannot subscribe to channel", "err", err, "broadcasterID", broadcasterID)
+		return apperror.ErrExternal
+	}
+
+	var failed bool
+	for _, sub := range created.Result {
+		if sub.Error != "" || sub.SubscriptionID == "" {
+			s.logger.ErrorContext(ctx, "cannot subscribe to event", "err", sub.Error, "event", sub.Name, "broadcasterID", broadcasterID)
+			failed = true
+			continue
+		}
+
+		err = s.remember(ctx, broadcasterID, broadcasterID, sub.Name, sub.Version, sub.SubscriptionID)
+		if err != nil {
+			return err
+		}
+	}
+	if failed {
 		return apperror.ErrExternal
 	}
 
 	return nil
 }
+
+func (s *WebhookService) remember(ctx context.Context, broadcasterID, ownerID, event string, version int, subscriptionID string) error {
+	_, err := s.storage.KickQuery(ctx).KickWebhookSubscriptionUpsert(ctx, db.KickWebhookSubscriptionUpsertParams{
+		BroadcasterID:  broadcasterID,
+		Event:          event,
+		Version:        int32(version),
+		SubscriptionID: subscriptionID,
+		CreatedAt:      time.Now().UTC(),
+		OwnerID:        ownerID,
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot store subscription", "err", err, "broadcasterID", broadcasterID, "event", event)
+		return s.storage.HandleErr(ctx, err)
+	}
+
+	return nil
+}
+
+func (s *WebhookService) forget(ctx context.Context, broadcasterID string, subscriptionIDs []string) error {
+	if len(subscriptionIDs) == 0 {
+		return nil
+	}
+
+	err := s.storage.KickQuery(ctx).KickWebhookSubscriptionsDelete(ctx, db.KickWebhookSubscriptionsDeleteParams{
+		BroadcasterID:   broadcasterID,
+		SubscriptionIds: subscriptionIDs,
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "cannot delete stored subscriptions", "err", err, "broadcasterID", broadcasterID)
+		return s.storage.HandleErr(ctx, err)
+	}
+
+	return nil
+}
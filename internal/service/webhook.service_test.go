@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	sharedData "github.com/arnokay/arnobot-shared/data"
+	"github.com/scorfly/gokick"
+
+	"github.com/arnokay/arnobot-kick/internal/kickfake"
+)
+
+func TestWebhookServiceSubscribe(t *testing.T) {
+	env := newTestEnv(t)
+	broadcaster := env.addUser(2, "broadcaster")
+
+	err := env.whService.Subscribe(context.Background(), broadcaster)
+	if err != nil {
+		t.Fatalf("cannot subscribe: %v", err)
+	}
+
+	subs := env.kick.Subscriptions()
+	if len(subs) != len(webhookSubscriptions) {
+		t.Fatalf("got %d subscriptions, want %d", len(subs), len(webhookSubscriptions))
+	}
+	for _, sub := range subs {
+		if sub.BroadcasterUserID != 2 || sub.CreatedBy != 2 {
+			t.Errorf("subscription is not created with broadcaster token: %+v", sub)
+		}
+	}
+
+	stored := env.store.storedSubscriptions("2")
+	if len(stored) != len(subs) {
+		t.Fatalf("got %d stored subscriptions, want %d", len(stored), len(subs))
+	}
+	for _, sub := range stored {
+		if sub.OwnerID != "2" {
+			t.Errorf("subscription owner is %q, want broadcaster", sub.OwnerID)
+		}
+	}
+
+	// channel that is subscribed already is not subscribed again
+	err = env.whService.Subscribe(context.Background(), broadcaster)
+	if err != nil {
+		t.Fatalf("cannot subscribe again: %v", err)
+	}
+	if !sameSubscriptions(subs, env.kick.Subscriptions()) {
+		t.Errorf("subscriptions changed on second subscribe")
+	}
+}
+
+func TestWebhookServiceSubscribeAdoptsAndDeduplicates(t *testing.T) {
+	env := newTestEnv(t)
+	broadcaster := env.addUser(2, "broadcaster")
+
+	// subscriptions created before they were stored, one of them twice
+	client := env.kickManager.GetByProvider(context.Background(), broadcaster)
+	for range 2 {
+		_, err := client.CreateSubscriptions(
+			context.Background(),
+			gokick.SubscriptionMethodWebhook,
+			webhookSubscriptions[:1],
+			nil,
+		)
+		if err != nil {
+			t.Fatalf("cannot create subscription: %v", err)
+		}
+	}
+
+	err := env.whService.Subscribe(context.Background(), broadcaster)
+	if err != nil {
+		t.Fatalf("cannot subscribe: %v", err)
+	}
+
+	subs := env.kick.Subscriptions()
+	if len(subs) != len(webhookSubscriptions) {
+		t.Fatalf("got %d subscriptions, want %d", len(subs), len(webhookSubscriptions))
+	}
+
+	stored := env.store.storedSubscriptions("2")
+	for _, sub := range stored {
+		if !slices.ContainsFunc(subs, func(remote kickfake.Subscription) bool { return remote.ID == sub.SubscriptionID }) {
+			t.Errorf("stored subscription %s does not exist on kick", sub.SubscriptionID)
+		}
+	}
+}
+
+func TestWebhookServiceUnsubscribeAll(t *testing.T) {
+	env := newTestEnv(t)
+	broadcaster := env.addUser(2, "broadcaster")
+	other := env.addUser(3, "other")
+
+	for _, provider := range []sharedData.AuthProvider{broadcaster, other} {
+		err := env.whService.Subscribe(context.Background(), provider)
+		if err != nil {
+			t.Fatalf("cannot subscribe %s: %v", provider.ProviderUserID, err)
+		}
+	}
+
+	// subscription that was never stored is found with app token
+	app, err := env.kickManager.GetApp(context.Background())
+	if err != nil {
+		t.Fatalf("cannot get app client: %v", err)
+	}
+	broadcasterID := 2
+	_, err = app.CreateSubscriptions(
+		context.Background(),
+		gokick.SubscriptionMethodWebhook,
+		webhookSubscriptions[:1],
+		&broadcasterID,
+	)
+	if err != nil {
+		t.Fatalf("cannot create subscription: %v", err)
+	}
+
+	err = env.whService.UnsubscribeAll(context.Background(), "2")
+	if err != nil {
+		t.Fatalf("cannot unsubscribe: %v", err)
+	}
+
+	for _, sub := range env.kick.Subscriptions() {
+		if sub.BroadcasterUserID == 2 {
+			t.Errorf("subscription of unsubscribed channel is left: %+v", sub)
+		}
+	}
+	if len(env.store.storedSubscriptions("2")) != 0 {
+		t.Errorf("subscriptions of unsubscribed channel are still stored")
+	}
+	// other channels are kept
+	if len(env.store.storedSubscriptions("3")) != len(webhookSubscriptions) {
+		t.Errorf("subscriptions of other channel are deleted")
+	}
+}
+
+func sameSubscriptions(a, b []kickfake.Subscription) bool {
+	ids := func(subs []kickfake.Subscription) []string {
+		var ids []string
+		for _, sub := range subs {
+			ids = append(ids, sub.ID)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+
+	return slices.Equal(ids(a), ids(b))
+}
@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"context"
+
+	sharedDB "github.com/arnokay/arnobot-shared/db"
+	"github.com/arnokay/arnobot-shared/storage"
+
+	"github.com/arnokay/arnobot-kick/internal/db"
+)
+
+// Storager extends shared storage.Storager with kick service specific queries
+type Storager interface {
+	storage.Storager
+	KickQuery(ctx context.Context) db.Querier
+}
+
+type Storage struct {
+	storage.Storager
+}
+
+func NewStorage(database sharedDB.DBTX) *Storage {
+	return &Storage{
+		Storager: storage.NewStorage(database),
+	}
+}
+
+func (s *Storage) KickQuery(ctx context.Context) db.Querier {
+	return db.New(s.Database(ctx))
+}
@@ -0,0 +1,28 @@
+package topics
+
+// Kick specific platform topics, built with the shared topics.TopicBuilder
+const (
+	PlatformBroadcasterChannelFollowNotify           = "channel.follow.notify.{platform}.{broadcasterID}"
+	PlatformBroadcasterChannelSubscriptionNotify     = "channel.subscription.notify.{platform}.{broadcasterID}"
+	PlatformBroadcasterChannelSubscriptionGiftNotify = "channel.subscription-gift.notify.{platform}.{broadcasterID}"
+	PlatformBroadcasterStreamOnlineNotify            = "stream.online.notify.{platform}.{broadcasterID}"
+	PlatformBroadcasterStreamOfflineNotify           = "stream.offline.notify.{platform}.{broadcasterID}"
+	PlatformBroadcasterStreamMetadataNotify          = "stream.metadata.notify.{platform}.{broadcasterID}"
+	PlatformStreamMetadataHistoryGet                 = "stream.{platform}.metadata-history.get"
+	PlatformBroadcasterModerationBanNotify           = "moderation.ban.notify.{platform}.{broadcasterID}"
+	PlatformModerationBansGet                        = "moderation.{platform}.bans.get"
+	PlatformBroadcasterReauthRequiredNotify          = "bot.reauth-required.notify.{platform}.{broadcasterID}"
+	PlatformBroadcasterChatMessageSentNotify         = "chat.message.sent.notify.{platform}.{broadcasterID}"
+	PlatformChatSettingsGet                          = "chat.{platform}.settings.get"
+	PlatformChatSettingsUpdate                       = "chat.{platform}.settings.update"
+	// PlatformChatMessageSendQueue is prefix of outbound chat stream subjects,
+	// followed by partition and broadcaster id
+	PlatformChatMessageSendQueue = "chat.message.send-queue.{platform}"
+	// PlatformBroadcasterChatMessageSendDLQ receives chat messages that could not be sent
+	PlatformBroadcasterChatMessageSendDLQ = "chat.message.send-dlq.{platform}.{broadcasterID}"
+)
+
+// Auth topics that are not (yet) part of the shared module
+const (
+	AuthProviderTokenReauthRequired = "auth.provider-token.reauth-required"
+)